@@ -0,0 +1,249 @@
+package addon
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	textTemplate "text/template"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// serve certificate installation page like http://mitm.it of mitmproxy,
+// visit the magic host through proxy or visit the proxy address directly
+
+const defaultCertPortalHost = "mitm.it"
+
+type CertPortal struct {
+	proxy.BaseAddon
+	Host string // magic hostname, visit http://Host through proxy to install certificate
+
+	getCert func() x509.Certificate
+}
+
+func NewCertPortal(p *proxy.Proxy) *CertPortal {
+	return &CertPortal{
+		Host:    defaultCertPortalHost,
+		getCert: p.GetCertificate,
+	}
+}
+
+func (portal *CertPortal) Request(f *proxy.Flow) {
+	if f.Request.URL.Scheme != "http" || !strings.EqualFold(f.Request.URL.Hostname(), portal.Host) {
+		return
+	}
+	f.Response = portal.response(f.Request.URL.Path)
+}
+
+// AccessProxyServer only answers paths of the portal, others are left to other addons
+func (portal *CertPortal) AccessProxyServer(req *http.Request, res http.ResponseWriter) {
+	if !isCertPortalPath(req.URL.Path) {
+		return
+	}
+	resp := portal.response(req.URL.Path)
+	for key, values := range resp.Header {
+		for _, v := range values {
+			res.Header().Add(key, v)
+		}
+	}
+	res.WriteHeader(resp.StatusCode)
+	if _, err := res.Write(resp.Body); err != nil {
+		log.Debugf("cert portal write error: %v", err)
+	}
+}
+
+func (portal *CertPortal) response(path string) *proxy.Response {
+	cert := portal.getCert()
+	if len(cert.Raw) == 0 {
+		return certPortalNotFound()
+	}
+	switch path {
+	case "/", "/index.html":
+		buf := new(bytes.Buffer)
+		sum := sha1.Sum(cert.Raw)
+		err := certPortalTemplate.Execute(buf, map[string]interface{}{
+			"Subject":     cert.Subject.String(),
+			"NotAfter":    cert.NotAfter.Format("2006-01-02"),
+			"Fingerprint": fmt.Sprintf("%X", sum[:]),
+		})
+		if err != nil {
+			return certPortalError(err)
+		}
+		return certPortalFile("text/html; charset=utf-8", "", buf.Bytes())
+	case "/cert/pem":
+		data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		return certPortalFile("application/x-x509-ca-cert", "mitmproxy-ca-cert.pem", data)
+	case "/cert/cer":
+		return certPortalFile("application/x-x509-ca-cert", "mitmproxy-ca-cert.cer", cert.Raw)
+	case "/cert/p12":
+		data, err := pkcs12.Modern.EncodeTrustStore([]*x509.Certificate{&cert}, "")
+		if err != nil {
+			return certPortalError(err)
+		}
+		return certPortalFile("application/x-pkcs12", "mitmproxy-ca-cert.p12", data)
+	case "/cert/mobileconfig":
+		buf := new(bytes.Buffer)
+		if err := writeMobileConfig(buf, &cert); err != nil {
+			return certPortalError(err)
+		}
+		return certPortalFile("application/x-apple-aspen-config", "mitmproxy-ca-cert.mobileconfig", buf.Bytes())
+	default:
+		return certPortalNotFound()
+	}
+}
+
+func isCertPortalPath(path string) bool {
+	return path == "/" || path == "/index.html" || strings.HasPrefix(path, "/cert/")
+}
+
+func certPortalNotFound() *proxy.Response {
+	return &proxy.Response{
+		StatusCode: 404,
+		Header:     http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:       []byte("404 page not found"),
+	}
+}
+
+func certPortalFile(contentType string, filename string, data []byte) *proxy.Response {
+	header := http.Header{
+		"Content-Type":   {contentType},
+		"Content-Length": {fmt.Sprint(len(data))},
+		"Cache-Control":  {"no-cache"},
+	}
+	if filename != "" {
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	return &proxy.Response{
+		StatusCode: 200,
+		Header:     header,
+		Body:       data,
+	}
+}
+
+func certPortalError(err error) *proxy.Response {
+	log.Errorf("cert portal error: %v", err)
+	return &proxy.Response{
+		StatusCode: 500,
+		Header:     http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:       []byte(err.Error()),
+	}
+}
+
+// configuration profile for iOS, install in Settings, then enable full trust in
+// Settings > General > About > Certificate Trust Settings
+func writeMobileConfig(w io.Writer, cert *x509.Certificate) error {
+	return mobileConfigTemplate.Execute(w, map[string]interface{}{
+		"Cert":          cert.Raw,
+		"Name":          cert.Subject.CommonName,
+		"PayloadUUID":   strings.ToUpper(uuid.NewString()),
+		"ProfileUUID":   strings.ToUpper(uuid.NewString()),
+		"PayloadPrefix": "org.mitmproxy.go-mitmproxy",
+	})
+}
+
+var mobileConfigTemplate = textTemplate.Must(textTemplate.New("mobileconfig").Funcs(textTemplate.FuncMap{
+	"base64": base64.StdEncoding.EncodeToString,
+	"xml": func(s string) string {
+		buf := new(strings.Builder)
+		_ = xml.EscapeText(buf, []byte(s))
+		return buf.String()
+	},
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadCertificateFileName</key>
+			<string>mitmproxy-ca-cert.cer</string>
+			<key>PayloadContent</key>
+			<data>{{ base64 .Cert }}</data>
+			<key>PayloadDescription</key>
+			<string>Adds a CA root certificate</string>
+			<key>PayloadDisplayName</key>
+			<string>{{ xml .Name }}</string>
+			<key>PayloadIdentifier</key>
+			<string>{{ .PayloadPrefix }}.cert.{{ .PayloadUUID }}</string>
+			<key>PayloadType</key>
+			<string>com.apple.security.root</string>
+			<key>PayloadUUID</key>
+			<string>{{ .PayloadUUID }}</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+		</dict>
+	</array>
+	<key>PayloadDisplayName</key>
+	<string>go-mitmproxy CA ({{ xml .Name }})</string>
+	<key>PayloadIdentifier</key>
+	<string>{{ .PayloadPrefix }}.{{ .ProfileUUID }}</string>
+	<key>PayloadRemovalDisallowed</key>
+	<false/>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>{{ .ProfileUUID }}</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>
+`))
+
+var certPortalTemplate = template.Must(template.New("portal").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>go-mitmproxy certificate</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 760px; margin: 0 auto; padding: 16px; color: #333; }
+h2 { margin-top: 28px; }
+.downloads a { display: inline-block; margin: 4px 8px 4px 0; padding: 6px 12px; border: 1px solid #0d6efd; border-radius: 4px; color: #0d6efd; text-decoration: none; }
+code { background: #f4f4f4; padding: 1px 4px; }
+.meta { color: #666; font-size: 13px; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>Install go-mitmproxy certificate</h1>
+<p>If you can see this page, your traffic is passing through go-mitmproxy. Install and trust the CA certificate to intercept HTTPS traffic.</p>
+<p class="meta">Subject: {{ .Subject }}<br>Expires: {{ .NotAfter }}<br>SHA1: {{ .Fingerprint }}</p>
+
+<h2>Windows</h2>
+<div class="downloads"><a href="/cert/p12">Get mitmproxy-ca-cert.p12</a></div>
+<p>Double click the file, choose "Current User", leave the password empty, place it in "Trusted Root Certification Authorities".</p>
+
+<h2>macOS</h2>
+<div class="downloads"><a href="/cert/pem">Get mitmproxy-ca-cert.pem</a></div>
+<p>Double click the file to add it to Keychain Access, open the certificate and set "When using this certificate" to "Always Trust". Or run:</p>
+<p><code>sudo security add-trusted-cert -d -p ssl -p basic -k /Library/Keychains/System.keychain mitmproxy-ca-cert.pem</code></p>
+
+<h2>Linux</h2>
+<div class="downloads"><a href="/cert/pem">Get mitmproxy-ca-cert.pem</a></div>
+<p>Debian / Ubuntu: <code>sudo cp mitmproxy-ca-cert.pem /usr/local/share/ca-certificates/mitmproxy.crt &amp;&amp; sudo update-ca-certificates</code></p>
+<p>Fedora / RHEL: <code>sudo cp mitmproxy-ca-cert.pem /etc/pki/ca-trust/source/anchors/ &amp;&amp; sudo update-ca-trust</code></p>
+
+<h2>iOS</h2>
+<div class="downloads"><a href="/cert/mobileconfig">Get mitmproxy-ca-cert.mobileconfig</a><a href="/cert/pem">Get mitmproxy-ca-cert.pem</a></div>
+<p>Open this page in Safari, download the profile, install it in Settings &gt; General &gt; VPN &amp; Device Management, then enable full trust in Settings &gt; General &gt; About &gt; Certificate Trust Settings.</p>
+
+<h2>Android</h2>
+<div class="downloads"><a href="/cert/cer">Get mitmproxy-ca-cert.cer</a><a href="/cert/p12">Get mitmproxy-ca-cert.p12</a></div>
+<p>Open Settings &gt; Security &gt; Encryption &amp; credentials &gt; Install a certificate &gt; CA certificate, select the downloaded file. Apps targeting Android 7+ only trust user certificates when their network security config allows it.</p>
+
+<h2>Firefox</h2>
+<div class="downloads"><a href="/cert/pem">Get mitmproxy-ca-cert.pem</a></div>
+<p>Firefox uses its own certificate store: Settings &gt; Privacy &amp; Security &gt; Certificates &gt; View Certificates &gt; Authorities &gt; Import, check "Trust this CA to identify websites".</p>
+</body>
+</html>
+`))
@@ -0,0 +1,58 @@
+package addon
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func TestCertPortal(t *testing.T) {
+	ca, err := cert.NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	portal := &CertPortal{
+		Host:    defaultCertPortalHost,
+		getCert: func() x509.Certificate { return *ca.GetRootCA() },
+	}
+
+	f := &proxy.Flow{Request: &proxy.Request{Method: "GET", URL: &url.URL{Scheme: "http", Host: "mitm.it", Path: "/cert/cer"}}}
+	portal.Request(f)
+	if f.Response == nil || f.Response.StatusCode != 200 {
+		t.Fatal("should serve certificate for magic host")
+	}
+	if !bytes.Equal(f.Response.Body, ca.GetRootCA().Raw) {
+		t.Fatal("cer should be der encoded root certificate")
+	}
+
+	f = &proxy.Flow{Request: &proxy.Request{Method: "GET", URL: &url.URL{Scheme: "http", Host: "example.com", Path: "/"}}}
+	portal.Request(f)
+	if f.Response != nil {
+		t.Fatal("should not handle other hosts")
+	}
+
+	for _, path := range []string{"/", "/cert/pem", "/cert/p12", "/cert/mobileconfig"} {
+		if resp := portal.response(path); resp.StatusCode != 200 || len(resp.Body) == 0 {
+			t.Fatalf("%v expected %d, but got %d", path, 200, resp.StatusCode)
+		}
+	}
+	if resp := portal.response("/not-found"); resp.StatusCode != 404 {
+		t.Fatalf("expected %d, but got %d", 404, resp.StatusCode)
+	}
+
+	rec := httptest.NewRecorder()
+	portal.AccessProxyServer(httptest.NewRequest("GET", "/cert/pem", nil), rec)
+	if rec.Code != 200 || rec.Body.Len() == 0 {
+		t.Fatalf("expected %d, but got %d", 200, rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	portal.AccessProxyServer(httptest.NewRequest("GET", "/other", nil), rec)
+	if rec.Body.Len() != 0 || len(rec.Header()) != 0 {
+		t.Fatal("should not write response of other paths at proxy address")
+	}
+}
@@ -2,57 +2,208 @@ package addon
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
+	"github.com/google/uuid"
 	"github.com/lqqyt2423/go-mitmproxy/log"
 	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/lqqyt2423/go-mitmproxy/proxy/filter"
 )
 
+const (
+	DumpFormatText = "text" // human-oriented text, bodies by level
+	DumpFormatJSON = "json" // one JSON object per line, with full metadata and base64 bodies
+)
+
+// RedactedValue replaces values of redacted headers
+const RedactedValue = "[REDACTED]"
+
+const defaultDumperMaxBodySize = 1024 * 1024 * 10
+
+type DumperOptions struct {
+	Filename       string        // dump filename, appended
+	Level          int           // text format: 0 - header, 1 - header + body
+	Format         string        // text or json. Default: text
+	Filter         string        // only dump flows matched by the filter expression of package proxy/filter, empty - all flows
+	RedactHeaders  []string      // values of these request and response headers are replaced by RedactedValue
+	MaxSize        int64         // rotate the file when it exceeds bytes, rotated files are compressed by gzip, 0 - never
+	RotateInterval time.Duration // rotate the file at the interval, 0 - never
+	MaxBodySize    int           // json format: bodies larger are truncated. Default: 10MB
+}
+
 type Dumper struct {
 	proxy.BaseAddon
 	out   io.Writer
 	level int // 0: header 1: header + body
+
+	format      string
+	filter      *filter.Filter  // nil - all flows
+	redact      map[string]bool // canonical header names
+	maxBodySize int             // max bytes of recorded streamed bodies
+	file        *rotateFile     // nil when out is not opened by dumper
+	flows       *recordFlows
+	mu          sync.Mutex // serialize writes of flows
 }
 
 func NewDumper(out io.Writer, level int) *Dumper {
 	if level != 0 && level != 1 {
 		level = 0
 	}
-	return &Dumper{out: out, level: level}
+	return &Dumper{out: out, level: level, format: DumpFormatText, maxBodySize: defaultDumperMaxBodySize, flows: newRecordFlows()}
 }
 
 func NewDumperWithFilename(filename string, level int) *Dumper {
-	out, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	d, err := NewDumperWithOptions(&DumperOptions{Filename: filename, Level: level})
 	if err != nil {
 		panic(err)
 	}
-	return NewDumper(out, level)
+	return d
+}
+
+// NewDumperWithOptions dumps flows to opts.Filename
+func NewDumperWithOptions(opts *DumperOptions) (*Dumper, error) {
+	var f *filter.Filter
+	if opts.Filter != "" {
+		var err error
+		if f, err = filter.Parse(opts.Filter); err != nil {
+			return nil, err
+		}
+	}
+	format := opts.Format
+	if format == "" {
+		format = DumpFormatText
+	}
+	if format != DumpFormatText && format != DumpFormatJSON {
+		return nil, fmt.Errorf("invalid dump format %q, should be %v or %v", format, DumpFormatText, DumpFormatJSON)
+	}
+
+	file, err := openRotateFile(opts.Filename, opts.MaxSize, opts.RotateInterval)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDumper(file, opts.Level)
+	d.file = file
+	d.format = format
+	d.filter = f
+	if opts.MaxBodySize > 0 {
+		d.maxBodySize = opts.MaxBodySize
+	}
+	if len(opts.RedactHeaders) > 0 {
+		d.redact = make(map[string]bool)
+		for _, name := range opts.RedactHeaders {
+			d.redact[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	return d, nil
+}
+
+// Close closes the file opened by dumper, waits compression of rotated files
+func (d *Dumper) Close() error {
+	if d.file == nil {
+		return nil
+	}
+	return d.file.Close()
+}
+
+func (d *Dumper) BeginFlow(f *proxy.Flow) {
+	d.flows.beginWithBodies(f, d.dump)
+}
+
+// record streamed bodies only when they are written or matched by the filter
+func (d *Dumper) withBody() bool {
+	return d.format == DumpFormatJSON || d.level == 1 || d.filter != nil && d.filter.UsesBody()
 }
 
 func (d *Dumper) Request(f *proxy.Flow) {
-	go func() {
-		<-f.Done()
-		d.dump(f)
-	}()
+	if d.withBody() {
+		d.flows.request(f, d.maxBodySize)
+	}
+}
+
+func (d *Dumper) Response(f *proxy.Flow) {
+	if d.withBody() {
+		d.flows.response(f, d.maxBodySize)
+	}
+}
+
+// call when <-f.Done(), f is the copy with recorded bodies
+func (d *Dumper) dump(f *proxy.Flow, bodies *recordedBodies) {
+	if d.filter != nil && !d.filter.Match(f) {
+		return
+	}
+	f = d.redactFlow(f)
+
+	var data []byte
+	if d.format == DumpFormatJSON {
+		var err error
+		data, err = json.Marshal(newDumpRecord(f, bodies))
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		data = append(data, '\n')
+	} else {
+		data = d.dumpText(f)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.out.Write(data)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// redactFlow returns a copy of f with values of redacted headers replaced, headers of f are shared with other addons
+func (d *Dumper) redactFlow(f *proxy.Flow) *proxy.Flow {
+	if len(d.redact) == 0 {
+		return f
+	}
+	redacted := *f
+	req := *f.Request
+	req.Header = d.redactHeader(f.Request.Header)
+	redacted.Request = &req
+	if f.Response != nil {
+		resp := *f.Response
+		resp.Header = d.redactHeader(f.Response.Header)
+		redacted.Response = &resp
+	}
+	return &redacted
 }
 
-// call when <-f.Done()
-func (d *Dumper) dump(f *proxy.Flow) {
+func (d *Dumper) redactHeader(header http.Header) http.Header {
+	h := header.Clone()
+	for name, values := range h {
+		if !d.redact[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		for i := range values {
+			values[i] = RedactedValue
+		}
+	}
+	return h
+}
+
+func (d *Dumper) dumpText(f *proxy.Flow) []byte {
 	// 参考 httputil.DumpRequest
 
 	buf := bytes.NewBuffer(make([]byte, 0))
 	fmt.Fprintf(buf, "%s %s %s\r\n", f.Request.Method, f.Request.URL.RequestURI(), f.Request.Proto)
 	fmt.Fprintf(buf, "Host: %s\r\n", f.Request.URL.Host)
-	if len(f.Request.Raw().TransferEncoding) > 0 {
-		fmt.Fprintf(buf, "Transfer-Encoding: %s\r\n", strings.Join(f.Request.Raw().TransferEncoding, ","))
-	}
-	if f.Request.Raw().Close {
-		fmt.Fprintf(buf, "Connection: close\r\n")
+	if raw := f.Request.Raw(); raw != nil {
+		if len(raw.TransferEncoding) > 0 {
+			fmt.Fprintf(buf, "Transfer-Encoding: %s\r\n", strings.Join(raw.TransferEncoding, ","))
+		}
+		if raw.Close {
+			fmt.Fprintf(buf, "Connection: close\r\n")
+		}
 	}
 
 	err := f.Request.Header.WriteSubset(buf, nil)
@@ -84,11 +235,86 @@ func (d *Dumper) dump(f *proxy.Flow) {
 	}
 
 	buf.WriteString("\r\n\r\n")
+	return buf.Bytes()
+}
 
-	_, err = d.out.Write(buf.Bytes())
-	if err != nil {
-		log.Error(err)
+// dumpRecord one line of json format, kept apart from the web api so the ingested format only changes here.
+// Bodies are base64 encoded, cut at MaxBodySize when truncated is set, sizes are the real sizes.
+type dumpRecord struct {
+	Id                uuid.UUID     `json:"id"`
+	ConnId            uuid.UUID     `json:"connId"`
+	ClientAddr        string        `json:"clientAddr,omitempty"`
+	ServerAddr        string        `json:"serverAddr,omitempty"`
+	Tls               bool          `json:"tls"`
+	Request           dumpRequest   `json:"request"`
+	Response          *dumpResponse `json:"response"`
+	StartTime         time.Time     `json:"startTime"`
+	ResponseTime      time.Time     `json:"responseTime"`
+	EndTime           time.Time     `json:"endTime"`
+	Error             string        `json:"error,omitempty"`
+	RequestSize       int           `json:"requestSize"`
+	RequestTruncated  bool          `json:"requestTruncated,omitempty"`
+	ResponseSize      int           `json:"responseSize"`
+	ResponseTruncated bool          `json:"responseTruncated,omitempty"`
+	Duration          float64       `json:"durationMs"` // from request received to response written
+}
+
+type dumpRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Proto  string      `json:"proto"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+type dumpResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+func newDumpRecord(f *proxy.Flow, bodies *recordedBodies) *dumpRecord {
+	r := &dumpRecord{
+		Id: f.Id,
+		Request: dumpRequest{
+			Method: f.Request.Method,
+			URL:    f.Request.URL.String(),
+			Proto:  f.Request.Proto,
+			Header: f.Request.Header,
+			Body:   f.Request.Body,
+		},
+		StartTime:        f.StartTime,
+		ResponseTime:     f.ResponseTime,
+		EndTime:          f.EndTime,
+		RequestSize:      bodies.req.size,
+		RequestTruncated: bodies.req.truncated,
+		Duration:         float64(f.EndTime.Sub(f.StartTime)) / float64(time.Millisecond),
+	}
+	if connCtx := f.ConnContext; connCtx != nil {
+		r.ConnId = connCtx.Id()
+		if connCtx.ClientConn != nil {
+			r.Tls = connCtx.ClientConn.Tls
+			if connCtx.ClientConn.Conn != nil {
+				r.ClientAddr = connCtx.ClientConn.Conn.RemoteAddr().String()
+			}
+		}
+		if connCtx.ServerConn != nil {
+			r.ServerAddr = connCtx.ServerConn.Address
+		}
+	}
+	if f.Response != nil {
+		r.Response = &dumpResponse{
+			StatusCode: f.Response.StatusCode,
+			Header:     f.Response.Header,
+			Body:       f.Response.Body,
+		}
+		r.ResponseSize = bodies.resp.size
+		r.ResponseTruncated = bodies.resp.truncated
+	}
+	if f.Error != nil {
+		r.Error = f.Error.Error()
 	}
+	return r
 }
 
 func canPrint(content []byte) bool {
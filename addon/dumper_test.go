@@ -0,0 +1,177 @@
+package addon
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumperJSON(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.jsonl")
+	d, err := NewDumperWithOptions(&DumperOptions{
+		Filename:      filename,
+		Format:        DumpFormatJSON,
+		Filter:        "~u /b | ~u /c",
+		RedactHeaders: []string{"content-type"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows := []string{"/a", "/b", "/c"}
+	for _, path := range flows {
+		f := newFlowStoreTestFlow(path, "hello\x00")
+		f.EndTime = f.StartTime.Add(1500 * time.Microsecond)
+		bodies := bodiesOf(f)
+		if path == "/c" {
+			bodies.resp = recordedBody{size: 100, truncated: true}
+		}
+		d.dump(f, bodies)
+		if f.Request.Header.Get("Content-Type") != "text/plain" {
+			t.Fatal("headers of flow should not be changed")
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records := make([]map[string]interface{}, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, but got %v", len(records))
+	}
+	r := records[0]
+	req := r["request"].(map[string]interface{})
+	resp := r["response"].(map[string]interface{})
+	if req["url"] != "http://example.com/b" || req["body"] != "aGVsbG8A" || resp["body"] != "SEVMTE8A" || r["durationMs"] != 1.5 || r["requestSize"] != 6.0 {
+		t.Fatalf("unexpected record %v", r)
+	}
+	if r["requestTruncated"] != nil || r["responseTruncated"] != nil {
+		t.Fatalf("unexpected truncated record %v", r)
+	}
+	if r := records[1]; r["responseSize"] != 100.0 || r["responseTruncated"] != true || r["requestTruncated"] != nil {
+		t.Fatalf("unexpected truncated record %v", r)
+	}
+	if ct := req["header"].(map[string]interface{})["Content-Type"].([]interface{}); ct[0] != RedactedValue {
+		t.Fatalf("header should be redacted, but got %v", ct)
+	}
+}
+
+func TestDumperText(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.txt")
+	d, err := NewDumperWithOptions(&DumperOptions{Filename: filename, Level: 1, Filter: "~c 404"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFlowStoreTestFlow("/a", "hello")
+	d.dump(f, bodiesOf(f))
+	f = newFlowStoreTestFlow("/b", "world")
+	f.Response.StatusCode = 404
+	d.dump(f, bodiesOf(f))
+	d.Close()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	if strings.Contains(text, "/a") || !strings.Contains(text, "POST /b HTTP/1.1\r\n") || !strings.Contains(text, "HTTP/1.1 404 Not Found\r\n") || !strings.Contains(text, "world\r\n") {
+		t.Fatalf("unexpected dump %q", text)
+	}
+
+	d, err = NewDumperWithOptions(&DumperOptions{Filename: filename, Filter: "~bs world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	if !d.withBody() {
+		t.Fatal("bodies should be recorded for body filter")
+	}
+
+	if _, err := NewDumperWithOptions(&DumperOptions{Filename: filename, Filter: "~x"}); err == nil {
+		t.Fatal("expected error of invalid filter")
+	}
+	if _, err := NewDumperWithOptions(&DumperOptions{Filename: filename, Format: "xml"}); err == nil {
+		t.Fatal("expected error of invalid format")
+	}
+}
+
+func TestRotateFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "dump.jsonl")
+	r, err := openRotateFile(filename, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "third\n" {
+		t.Fatalf("unexpected current file %q", data)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "dump-*"))
+	if len(rotated) != 2 {
+		t.Fatalf("expected 2 rotated files, but got %v", rotated)
+	}
+	contents := make([]string, 0)
+	for _, name := range []string{rotated[0], rotated[1]} {
+		if !strings.HasSuffix(name, ".jsonl.gz") {
+			t.Fatalf("rotated file should be compressed, but got %v", name)
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(zr)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(content))
+	}
+	if strings.Join(contents, "") != "first\nsecond\n" && strings.Join(contents, "") != "second\nfirst\n" {
+		t.Fatalf("unexpected rotated contents %q", contents)
+	}
+
+	r, err = openRotateFile(filename, 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	r.Write([]byte("fourth\n"))
+	r.Close()
+	if data, _ := os.ReadFile(filename); string(data) != "fourth\n" {
+		t.Fatalf("file should be rotated by time, but got %q", data)
+	}
+}
@@ -0,0 +1,42 @@
+package addon
+
+import (
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// FlowRecorder calls OnFlow with every finished flow holding the bodies, for storing flows in other packages.
+// Bodies are not read into Flow in streaming mode, so f.Request.Body and f.Response.Body are empty in other addons.
+// Bodies larger than MaxBodySize are cut, with BodyTruncated and the real BodySize set in the copy.
+
+const defaultFlowRecorderMaxBodySize = 1024 * 1024 * 10
+
+type FlowRecorder struct {
+	proxy.BaseAddon
+	MaxBodySize int                 // bodies larger are truncated. Default: 10MB
+	OnFlow      func(f *proxy.Flow) // called after the flow done with a copy of the flow, in a new goroutine
+
+	flows *recordFlows
+}
+
+func NewFlowRecorder(onFlow func(f *proxy.Flow)) *FlowRecorder {
+	return &FlowRecorder{
+		MaxBodySize: defaultFlowRecorderMaxBodySize,
+		OnFlow:      onFlow,
+		flows:       newRecordFlows(),
+	}
+}
+
+func (r *FlowRecorder) BeginFlow(f *proxy.Flow) {
+	r.flows.beginWithBodies(f, func(f *proxy.Flow, bodies *recordedBodies) {
+		bodies.markTruncated(f)
+		r.OnFlow(f)
+	})
+}
+
+func (r *FlowRecorder) Request(f *proxy.Flow) {
+	r.flows.request(f, r.MaxBodySize)
+}
+
+func (r *FlowRecorder) Response(f *proxy.Flow) {
+	r.flows.response(f, r.MaxBodySize)
+}
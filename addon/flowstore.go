@@ -0,0 +1,667 @@
+package addon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/lqqyt2423/go-mitmproxy/proxy/export"
+)
+
+// keep finished flows with bodies in memory for the web interface, queried by REST api:
+//
+//   GET    /api/flows?offset=0&limit=100   list flows without bodies, in finished order
+//   GET    /api/flows/{id}                 one flow with base64 bodies, query decode=true: response body decoded by Content-Encoding
+//   GET    /api/flows/{id}/export?format=curl  request of the flow as curl, httpie, raw, go or python
+//   DELETE /api/flows/{id}                 delete one flow
+//   DELETE /api/flows                      clear all flows
+//   GET    /api/flows/export?format=flows  download flows: flows - go-mitmproxy flow file, mitm - mitmproxy flow file, har - HAR
+//                                          query flow: comma separated flow ids, empty means all
+//
+// When bodies exceed MaxBytes, bodies of the oldest flows are spilled to the spill file, or the oldest flows are
+// dropped without spill file. The oldest flows are dropped when spilled bodies exceed MaxSpillBytes, and the spill file
+// is compacted when more than half of it is left by dropped flows.
+
+const (
+	defaultFlowStoreMaxFlows    = 10000
+	defaultFlowStoreMaxBytes    = 1024 * 1024 * 256
+	defaultFlowStoreMaxBodySize = 1024 * 1024 * 10
+	defaultFlowStoreMaxSpill    = 1024 * 1024 * 1024 * 2
+
+	defaultFlowStoreListLimit = 100
+	maxFlowStoreListLimit     = 1000
+)
+
+type FlowStore struct {
+	proxy.BaseAddon
+	MaxFlows    int   // number of flows kept, the oldest flows are dropped when exceeded, 0 - unlimited. Default: 10000
+	MaxBytes    int64 // bytes of bodies kept in memory, 0 - unlimited. Default: 256MB
+	MaxBodySize int   // bodies larger are truncated. Default: 10MB
+	// bytes of bodies kept in spill file, the oldest flows are dropped when exceeded, 0 - unlimited. Default: 2GB
+	MaxSpillBytes int64
+
+	spill     *os.File // nil - drop flows instead of spilling bodies
+	spillSize int64    // end of spill file
+	spillLive int64    // bytes of spilled bodies of kept flows, the rest of spill file is reclaimed by compact
+
+	entries  []*flowStoreEntry // in finished order
+	index    map[uuid.UUID]*flowStoreEntry
+	memBytes int64
+	flows    *recordFlows
+	mux      *http.ServeMux
+	mu       sync.Mutex
+}
+
+type flowStoreEntry struct {
+	flow     *proxy.Flow     // bodies are nil when spilled
+	bodies   *recordedBodies // real sizes of bodies, which are cut at MaxBodySize when truncated
+	reqSize  int             // length of the kept request body
+	respSize int
+	spilled  bool
+	offset   int64 // offset of bodies in spill file, request body followed by response body
+}
+
+func (e *flowStoreEntry) size() int64 {
+	return int64(e.reqSize + e.respSize)
+}
+
+// NewFlowStore keeps flows in memory, bodies exceeding MaxBytes are spilled to the file of spillPath,
+// empty spillPath means no spill
+func NewFlowStore(spillPath string) (*FlowStore, error) {
+	s := &FlowStore{
+		MaxFlows:      defaultFlowStoreMaxFlows,
+		MaxBytes:      defaultFlowStoreMaxBytes,
+		MaxBodySize:   defaultFlowStoreMaxBodySize,
+		MaxSpillBytes: defaultFlowStoreMaxSpill,
+		entries:       make([]*flowStoreEntry, 0),
+		index:         make(map[uuid.UUID]*flowStoreEntry),
+		flows:         newRecordFlows(),
+	}
+	if spillPath != "" {
+		file, err := os.OpenFile(spillPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+		s.spill = file
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /api/flows", s.serveList)
+	s.mux.HandleFunc("DELETE /api/flows", s.serveClear)
+	s.mux.HandleFunc("GET /api/flows/export", s.serveExport)
+	s.mux.HandleFunc("GET /api/flows/{id}", s.serveGet)
+	s.mux.HandleFunc("DELETE /api/flows/{id}", s.serveDelete)
+	s.mux.HandleFunc("GET /api/flows/{id}/export", s.serveExportRequest)
+	return s, nil
+}
+
+func (s *FlowStore) BeginFlow(f *proxy.Flow) {
+	s.flows.beginWithBodies(f, s.add)
+}
+
+func (s *FlowStore) Request(f *proxy.Flow) {
+	s.flows.request(f, s.MaxBodySize)
+}
+
+func (s *FlowStore) Response(f *proxy.Flow) {
+	s.flows.response(f, s.MaxBodySize)
+}
+
+// Add keeps the finished flow f, e.g. read from files
+func (s *FlowStore) Add(f *proxy.Flow) {
+	s.add(f, bodiesOf(f))
+}
+
+func (s *FlowStore) add(f *proxy.Flow, bodies *recordedBodies) {
+	bodies.markTruncated(f)
+	e := &flowStoreEntry{flow: f, bodies: bodies, reqSize: len(f.Request.Body)}
+	if f.Response != nil {
+		e.respSize = len(f.Response.Body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.index[f.Id]; ok {
+		s.remove(old)
+	}
+	s.entries = append(s.entries, e)
+	s.index[f.Id] = e
+	s.memBytes += e.size()
+	s.evict()
+}
+
+// Len returns number of flows kept
+func (s *FlowStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Get returns the flow with bodies, nil if not found
+func (s *FlowStore) Get(id uuid.UUID) (*proxy.Flow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.index[id]
+	if !ok {
+		return nil, nil
+	}
+	return s.load(e)
+}
+
+// Summaries returns all flows without bodies in finished order, bodies are read by Get
+func (s *FlowStore) Summaries() []*proxy.Flow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flows := make([]*proxy.Flow, 0, len(s.entries))
+	for _, e := range s.entries {
+		if e.spilled {
+			flows = append(flows, e.flow)
+		} else {
+			flows = append(flows, withoutBodies(e.flow))
+		}
+	}
+	return flows
+}
+
+// Range calls fn with flows with bodies in finished order until fn returns false, bodies are read one flow at a time.
+// Flows removed meanwhile or failed to read from spill file are skipped
+func (s *FlowStore) Range(fn func(f *proxy.Flow) bool) {
+	s.rangeEntries(func(f *proxy.Flow, _ *recordedBodies) bool { return fn(f) })
+}
+
+// rangeEntries is like Range, fn also gets the real sizes of the bodies
+func (s *FlowStore) rangeEntries(fn func(f *proxy.Flow, bodies *recordedBodies) bool) {
+	s.mu.Lock()
+	entries := make([]*flowStoreEntry, len(s.entries))
+	copy(entries, s.entries)
+	s.mu.Unlock()
+
+	for _, e := range entries {
+		f, err := s.Get(e.flow.Id)
+		if err != nil {
+			log.Errorf("flow store read spilled flow %v error: %v", e.flow.Id, err)
+			continue
+		}
+		if f != nil && !fn(f, e.bodies) {
+			return
+		}
+	}
+}
+
+// Delete removes the flow, returns false if not found
+func (s *FlowStore) Delete(id uuid.UUID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.index[id]
+	if !ok {
+		return false
+	}
+	s.remove(e)
+	s.compact()
+	return true
+}
+
+// Clear removes all flows
+func (s *FlowStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = make([]*flowStoreEntry, 0)
+	s.index = make(map[uuid.UUID]*flowStoreEntry)
+	s.memBytes = 0
+	if s.spill != nil {
+		if err := s.spill.Truncate(0); err != nil {
+			log.Warnf("flow store truncate spill file error: %v", err)
+		}
+		s.spillSize = 0
+		s.spillLive = 0
+	}
+}
+
+func (s *FlowStore) Close() error {
+	if s.spill == nil {
+		return nil
+	}
+	return s.spill.Close()
+}
+
+// remove the entry, space of spilled bodies is reclaimed by compact, call with mu held
+func (s *FlowStore) remove(e *flowStoreEntry) {
+	for i, v := range s.entries {
+		if v == e {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			break
+		}
+	}
+	delete(s.index, e.flow.Id)
+	if e.spilled {
+		s.spillLive -= e.size()
+	} else {
+		s.memBytes -= e.size()
+	}
+}
+
+// evict drops the oldest flows or spills their bodies until within limits, call with mu held
+func (s *FlowStore) evict() {
+	for s.MaxFlows > 0 && len(s.entries) > s.MaxFlows {
+		s.remove(s.entries[0])
+	}
+	if s.MaxBytes <= 0 {
+		return
+	}
+	for i := 0; s.memBytes > s.MaxBytes && i < len(s.entries); {
+		e := s.entries[i]
+		if s.spill == nil {
+			s.remove(e)
+			continue
+		}
+		if !e.spilled {
+			if err := s.spillEntry(e); err != nil {
+				log.Errorf("flow store spill flow %v error: %v", e.flow.Id, err)
+				s.remove(e)
+				continue
+			}
+		}
+		i++
+	}
+	for i := 0; s.MaxSpillBytes > 0 && s.spillLive > s.MaxSpillBytes && i < len(s.entries); {
+		if e := s.entries[i]; e.spilled {
+			s.remove(e)
+			continue
+		}
+		i++
+	}
+	s.compact()
+}
+
+// compact moves spilled bodies of kept flows to the front of spill file and truncates it, when space left by
+// dropped flows exceeds both spilled bodies of kept flows and MaxBytes, call with mu held
+func (s *FlowStore) compact() {
+	garbage := s.spillSize - s.spillLive
+	if s.spill == nil || garbage <= s.spillLive || garbage <= s.MaxBytes {
+		return
+	}
+	spilled := make([]*flowStoreEntry, 0)
+	for _, e := range s.entries {
+		if e.spilled {
+			spilled = append(spilled, e)
+		}
+	}
+	sort.Slice(spilled, func(i, j int) bool { return spilled[i].offset < spilled[j].offset })
+
+	// bodies only move forward, so unread bodies are never overwritten
+	var offset int64
+	for _, e := range spilled {
+		if e.offset != offset {
+			data := make([]byte, e.size())
+			if _, err := s.spill.ReadAt(data, e.offset); err != nil {
+				log.Errorf("flow store compact flow %v error: %v", e.flow.Id, err)
+				s.remove(e)
+				continue
+			}
+			if _, err := s.spill.WriteAt(data, offset); err != nil {
+				log.Errorf("flow store compact flow %v error: %v", e.flow.Id, err)
+				s.remove(e)
+				continue
+			}
+			e.offset = offset
+		}
+		offset += e.size()
+	}
+	if err := s.spill.Truncate(offset); err != nil {
+		log.Warnf("flow store truncate spill file error: %v", err)
+	}
+	s.spillSize = offset
+}
+
+// spillEntry writes bodies to spill file and drops them from memory, call with mu held
+func (s *FlowStore) spillEntry(e *flowStoreEntry) error {
+	f := e.flow
+	data := make([]byte, 0, e.size())
+	data = append(data, f.Request.Body...)
+	if f.Response != nil {
+		data = append(data, f.Response.Body...)
+	}
+	if _, err := s.spill.WriteAt(data, s.spillSize); err != nil {
+		return err
+	}
+
+	e.flow = withoutBodies(f)
+	e.spilled = true
+	e.offset = s.spillSize
+	s.spillSize += int64(len(data))
+	s.spillLive += e.size()
+	s.memBytes -= e.size()
+	return nil
+}
+
+// withoutBodies returns a copy of f without bodies
+func withoutBodies(f *proxy.Flow) *proxy.Flow {
+	copied := *f
+	req := *f.Request
+	req.Body = nil
+	copied.Request = &req
+	if f.Response != nil {
+		resp := *f.Response
+		resp.Body = nil
+		copied.Response = &resp
+	}
+	return &copied
+}
+
+// load returns the flow of entry with bodies, call with mu held
+func (s *FlowStore) load(e *flowStoreEntry) (*proxy.Flow, error) {
+	if !e.spilled {
+		return e.flow, nil
+	}
+	data := make([]byte, e.size())
+	if _, err := s.spill.ReadAt(data, e.offset); err != nil {
+		return nil, err
+	}
+	f := *e.flow
+	req := *f.Request
+	req.Body = data[:e.reqSize]
+	f.Request = &req
+	if f.Response != nil {
+		resp := *f.Response
+		resp.Body = data[e.reqSize:]
+		f.Response = &resp
+	}
+	return &f, nil
+}
+
+func (s *FlowStore) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(res, req)
+}
+
+// flowStoreSummary item of flow list
+type flowStoreSummary struct {
+	Id                uuid.UUID `json:"id"`
+	Method            string    `json:"method"`
+	URL               string    `json:"url"`
+	StatusCode        int       `json:"statusCode,omitempty"`
+	ContentType       string    `json:"contentType,omitempty"`
+	RequestSize       int       `json:"requestSize"` // real size, larger than the kept body when truncated
+	ResponseSize      int       `json:"responseSize"`
+	RequestTruncated  bool      `json:"requestTruncated,omitempty"`
+	ResponseTruncated bool      `json:"responseTruncated,omitempty"`
+	StartTime         time.Time `json:"startTime"`
+	EndTime           time.Time `json:"endTime"`
+	Error             string    `json:"error,omitempty"`
+}
+
+func newFlowStoreSummary(e *flowStoreEntry) *flowStoreSummary {
+	f := e.flow
+	summary := &flowStoreSummary{
+		Id:                f.Id,
+		Method:            f.Request.Method,
+		URL:               f.Request.URL.String(),
+		RequestSize:       e.bodies.req.size,
+		ResponseSize:      e.bodies.resp.size,
+		RequestTruncated:  e.bodies.req.truncated,
+		ResponseTruncated: e.bodies.resp.truncated,
+		StartTime:         f.StartTime,
+		EndTime:           f.EndTime,
+	}
+	if f.Response != nil {
+		summary.StatusCode = f.Response.StatusCode
+		summary.ContentType = f.Response.Header.Get("Content-Type")
+	}
+	if f.Error != nil {
+		summary.Error = f.Error.Error()
+	}
+	return summary
+}
+
+type flowStoreRequest struct {
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	Proto     string      `json:"proto"`
+	Header    http.Header `json:"header"`
+	Body      []byte      `json:"body"`
+	Size      int         `json:"size"` // real size, larger than body when truncated
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+type flowStoreResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	Size       int         `json:"size"`
+	Truncated  bool        `json:"truncated,omitempty"`
+}
+
+// flowStoreDetail one flow with bodies
+type flowStoreDetail struct {
+	Id           uuid.UUID          `json:"id"`
+	ConnId       uuid.UUID          `json:"connId"`
+	ClientAddr   string             `json:"clientAddr,omitempty"`
+	ServerAddr   string             `json:"serverAddr,omitempty"`
+	Request      flowStoreRequest   `json:"request"`
+	Response     *flowStoreResponse `json:"response"`
+	StartTime    time.Time          `json:"startTime"`
+	ResponseTime time.Time          `json:"responseTime"`
+	EndTime      time.Time          `json:"endTime"`
+	Error        string             `json:"error,omitempty"`
+}
+
+func newFlowStoreDetail(f *proxy.Flow) *flowStoreDetail {
+	bodies := bodiesOf(f)
+	detail := &flowStoreDetail{
+		Id: f.Id,
+		Request: flowStoreRequest{
+			Method:    f.Request.Method,
+			URL:       f.Request.URL.String(),
+			Proto:     f.Request.Proto,
+			Header:    f.Request.Header,
+			Body:      f.Request.Body,
+			Size:      bodies.req.size,
+			Truncated: bodies.req.truncated,
+		},
+		StartTime:    f.StartTime,
+		ResponseTime: f.ResponseTime,
+		EndTime:      f.EndTime,
+	}
+	if connCtx := f.ConnContext; connCtx != nil {
+		detail.ConnId = connCtx.Id()
+		if connCtx.ClientConn != nil && connCtx.ClientConn.Conn != nil {
+			detail.ClientAddr = connCtx.ClientConn.Conn.RemoteAddr().String()
+		}
+		if connCtx.ServerConn != nil {
+			detail.ServerAddr = connCtx.ServerConn.Address
+		}
+	}
+	if f.Response != nil {
+		detail.Response = &flowStoreResponse{
+			StatusCode: f.Response.StatusCode,
+			Header:     f.Response.Header,
+			Body:       f.Response.Body,
+			Size:       bodies.resp.size,
+			Truncated:  bodies.resp.truncated,
+		}
+	}
+	if f.Error != nil {
+		detail.Error = f.Error.Error()
+	}
+	return detail
+}
+
+func (s *FlowStore) serveList(res http.ResponseWriter, req *http.Request) {
+	offset, limit := 0, defaultFlowStoreListLimit
+	var err error
+	if v := req.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(res, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := req.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxFlowStoreListLimit {
+			http.Error(res, fmt.Sprintf("limit should be 1 - %v", maxFlowStoreListLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	total := len(s.entries)
+	flows := make([]*flowStoreSummary, 0)
+	for i := offset; i < total && i < offset+limit; i++ {
+		flows = append(flows, newFlowStoreSummary(s.entries[i]))
+	}
+	s.mu.Unlock()
+
+	writeFlowStoreJSON(res, map[string]interface{}{
+		"total":  total,
+		"offset": offset,
+		"flows":  flows,
+	})
+}
+
+func (s *FlowStore) serveGet(res http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		http.Error(res, "invalid flow id", http.StatusBadRequest)
+		return
+	}
+	f, err := s.Get(id)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if f == nil {
+		http.NotFound(res, req)
+		return
+	}
+	if req.URL.Query().Get("decode") == "true" && f.Response != nil && len(f.Response.Body) > 0 {
+		if body, err := f.Response.DecodedBody(); err == nil {
+			decoded := *f
+			resp := *f.Response
+			resp.Body = body
+			decoded.Response = &resp
+			f = &decoded
+		}
+	}
+	writeFlowStoreJSON(res, newFlowStoreDetail(f))
+}
+
+func (s *FlowStore) serveExportRequest(res http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		http.Error(res, "invalid flow id", http.StatusBadRequest)
+		return
+	}
+	f, err := s.Get(id)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if f == nil {
+		http.NotFound(res, req)
+		return
+	}
+	data, err := export.Export(f.Request, req.URL.Query().Get("format"))
+	if errors.Is(err, export.ErrBodyTruncated) {
+		http.Error(res, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	res.Write(data)
+}
+
+func (s *FlowStore) serveDelete(res http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		http.Error(res, "invalid flow id", http.StatusBadRequest)
+		return
+	}
+	if !s.Delete(id) {
+		http.NotFound(res, req)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (s *FlowStore) serveClear(res http.ResponseWriter, req *http.Request) {
+	s.Clear()
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// serveExport streams flows, bodies are read one flow at a time
+func (s *FlowStore) serveExport(res http.ResponseWriter, req *http.Request) {
+	ids, err := parseFlowIds(req.URL.Query().Get("flow"))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	each := func(fn func(f *proxy.Flow, bodies *recordedBodies) error) {
+		s.rangeEntries(func(f *proxy.Flow, bodies *recordedBodies) bool {
+			if len(ids) > 0 && !containsUUID(ids, f.Id) {
+				return true
+			}
+			if err := fn(f, bodies); err != nil {
+				log.Errorf("flow store export error: %v", err)
+				return false
+			}
+			return true
+		})
+	}
+
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = "flows"
+	}
+	switch format {
+	case "flows", "mitm":
+		res.Header().Set("Content-Type", "application/octet-stream")
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "go-mitmproxy."+format))
+		var writer flowWriter = proxy.NewFlowWriter(res)
+		if format == "mitm" {
+			writer = proxy.NewMitmproxyFlowWriter(res)
+		}
+		each(func(f *proxy.Flow, _ *recordedBodies) error { return writer.Write(f) })
+	case "har":
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "go-mitmproxy.har"))
+		if _, err := io.WriteString(res, harHeader); err != nil {
+			return
+		}
+		n := 0
+		each(func(f *proxy.Flow, bodies *recordedBodies) error {
+			entry := newHarEntry(f, bodies, &harFlow{start: f.StartTime}, harConn{}, f.EndTime, s.MaxBodySize)
+			raw, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			sep := ",\n"
+			if n == 0 {
+				sep = "\n"
+			}
+			n++
+			_, err = res.Write(append([]byte(sep), raw...))
+			return err
+		})
+		io.WriteString(res, harTrailer)
+	default:
+		http.Error(res, "format should be flows, mitm or har", http.StatusBadRequest)
+	}
+}
+
+func writeFlowStoreJSON(res http.ResponseWriter, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(v); err != nil {
+		log.Errorf("flow store write response error: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package addon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func newFlowStoreTestFlow(path string, body string) *proxy.Flow {
+	u, _ := url.Parse("http://example.com" + path)
+	return &proxy.Flow{
+		Id:        uuid.New(),
+		Request:   &proxy.Request{Method: "POST", URL: u, Proto: "HTTP/1.1", Header: http.Header{"Content-Type": {"text/plain"}}, Body: []byte(body)},
+		Response:  &proxy.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"text/html"}}, Body: []byte(strings.ToUpper(body))},
+		StartTime: time.Now(),
+		EndTime:   time.Now(),
+	}
+}
+
+func TestFlowStore(t *testing.T) {
+	store, err := NewFlowStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.MaxFlows = 3
+	store.MaxBytes = 20
+
+	flows := make([]*proxy.Flow, 0)
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		f := newFlowStoreTestFlow(path, "hello")
+		flows = append(flows, f)
+		store.Add(f)
+	}
+	// 3 flows of 10 bytes exceed MaxBytes, only the newest 2 are kept
+	if store.Len() != 2 {
+		t.Fatalf("expected 2 flows, but got %v", store.Len())
+	}
+	if f, _ := store.Get(flows[1].Id); f != nil {
+		t.Fatal("oldest flow should be dropped")
+	}
+
+	// list
+	res := httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", "/api/flows?offset=1&limit=10", nil))
+	var list struct {
+		Total int
+		Flows []*flowStoreSummary
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 2 || len(list.Flows) != 1 || list.Flows[0].Id != flows[3].Id || list.Flows[0].StatusCode != 200 || list.Flows[0].ResponseSize != 5 {
+		t.Fatalf("unexpected list %s", res.Body.Bytes())
+	}
+
+	// get
+	res = httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", "/api/flows/"+flows[3].Id.String(), nil))
+	var detail flowStoreDetail
+	if err := json.Unmarshal(res.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.Request.URL != "http://example.com/d" || string(detail.Request.Body) != "hello" || detail.Response == nil || string(detail.Response.Body) != "HELLO" {
+		t.Fatalf("unexpected flow %s", res.Body.Bytes())
+	}
+
+	// export
+	res = httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", "/api/flows/export?format=flows&flow="+flows[3].Id.String(), nil))
+	exported, err := proxy.ReadFlows(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 1 || exported[0].Id != flows[3].Id || string(exported[0].Response.Body) != "HELLO" {
+		t.Fatalf("unexpected exported flows %v", exported)
+	}
+	res = httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", "/api/flows/export?format=har", nil))
+	harFlows, err := ReadHar(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(harFlows) != 2 {
+		t.Fatalf("expected 2 har entries, but got %v", len(harFlows))
+	}
+
+	// request as curl command
+	res = httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", "/api/flows/"+flows[3].Id.String()+"/export?format=curl", nil))
+	if res.Code != http.StatusOK || !strings.HasPrefix(res.Body.String(), "curl -g -X POST http://example.com/d") || !strings.Contains(res.Body.String(), "--data-raw hello") {
+		t.Fatalf("unexpected curl command %s", res.Body.Bytes())
+	}
+
+	// delete and clear
+	for _, c := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"DELETE", "/api/flows/" + flows[3].Id.String(), http.StatusNoContent},
+		{"GET", "/api/flows/" + flows[3].Id.String(), http.StatusNotFound},
+		{"GET", "/api/flows/invalid", http.StatusBadRequest},
+		{"GET", "/api/flows/" + flows[1].Id.String() + "/export?format=curl", http.StatusNotFound},
+		{"GET", "/api/flows/" + flows[2].Id.String() + "/export?format=unknown", http.StatusBadRequest},
+		{"GET", "/api/flows?limit=0", http.StatusBadRequest},
+		{"DELETE", "/api/flows", http.StatusNoContent},
+	} {
+		res = httptest.NewRecorder()
+		store.ServeHTTP(res, httptest.NewRequest(c.method, c.path, nil))
+		if res.Code != c.status {
+			t.Fatalf("%v %v expected status %v, but got %v", c.method, c.path, c.status, res.Code)
+		}
+	}
+	if store.Len() != 0 {
+		t.Fatalf("expected no flows after clear, but got %v", store.Len())
+	}
+}
+
+func TestFlowStoreSpill(t *testing.T) {
+	store, err := NewFlowStore(filepath.Join(t.TempDir(), "spill"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.MaxBytes = 20
+
+	flows := make([]*proxy.Flow, 0)
+	for _, body := range []string{"first", "second", "third"} {
+		f := newFlowStoreTestFlow("/"+body, body)
+		flows = append(flows, f)
+		store.Add(f)
+	}
+	if store.Len() != 3 {
+		t.Fatalf("expected 3 flows, but got %v", store.Len())
+	}
+	if store.memBytes > store.MaxBytes || !store.index[flows[0].Id].spilled {
+		t.Fatalf("oldest bodies should be spilled, %v bytes in memory", store.memBytes)
+	}
+
+	got := make([]*proxy.Flow, 0)
+	store.Range(func(f *proxy.Flow) bool {
+		got = append(got, f)
+		return true
+	})
+	if len(got) != 3 {
+		t.Fatalf("expected 3 flows, but got %v", len(got))
+	}
+	for i, f := range got {
+		if f.Id != flows[i].Id || !bytes.Equal(f.Request.Body, flows[i].Request.Body) || !bytes.Equal(f.Response.Body, flows[i].Response.Body) {
+			t.Fatalf("unexpected flow %v: %q %q", i, f.Request.Body, f.Response.Body)
+		}
+	}
+}
+
+func TestFlowStoreSpillCompact(t *testing.T) {
+	store, err := NewFlowStore(filepath.Join(t.TempDir(), "spill"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.MaxFlows = 5
+	store.MaxBytes = 10
+	store.MaxSpillBytes = 30
+
+	// bodies of 10 bytes, all but the newest are spilled, only 3 spilled are kept
+	flows := make([]*proxy.Flow, 0)
+	for i := 0; i < 20; i++ {
+		f := newFlowStoreTestFlow(fmt.Sprintf("/%v", i), fmt.Sprintf("body%v", i%10))
+		flows = append(flows, f)
+		store.Add(f)
+		if store.spillLive > store.MaxSpillBytes || store.spillSize > 2*store.spillLive+store.MaxBytes {
+			t.Fatalf("spill file of %v bytes with %v bytes kept, should be compacted", store.spillSize, store.spillLive)
+		}
+	}
+	if store.Len() != 4 {
+		t.Fatalf("expected 4 flows, but got %v", store.Len())
+	}
+	for _, f := range flows[16:] {
+		got, err := store.Get(f.Id)
+		if err != nil || got == nil || !bytes.Equal(got.Request.Body, f.Request.Body) || !bytes.Equal(got.Response.Body, f.Response.Body) {
+			t.Fatalf("unexpected flow %v: %v", f.Request.URL, err)
+		}
+	}
+	for _, f := range store.Summaries() {
+		if f.Request.Body != nil || f.Response.Body != nil {
+			t.Fatal("summaries should not have bodies")
+		}
+	}
+	if !bytes.Equal(flows[19].Request.Body, []byte("body9")) {
+		t.Fatal("flow added should not be changed")
+	}
+}
+
+func TestFlowStoreTruncated(t *testing.T) {
+	store, err := NewFlowStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// response body cut at MaxBodySize by the recorder
+	f := newFlowStoreTestFlow("/a", "hello")
+	bodies := bodiesOf(f)
+	bodies.resp.size, bodies.resp.truncated = 100, true
+	store.add(f, bodies)
+
+	res := httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", "/api/flows", nil))
+	var list struct {
+		Flows []*flowStoreSummary
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Flows) != 1 || list.Flows[0].ResponseSize != 100 || !list.Flows[0].ResponseTruncated || list.Flows[0].RequestTruncated {
+		t.Fatalf("unexpected list %s", res.Body.Bytes())
+	}
+
+	res = httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", "/api/flows/export?format=har", nil))
+	if har := res.Body.String(); strings.Contains(har, "HELLO") || !strings.Contains(har, "response body of 100 bytes exceeds") {
+		t.Fatalf("truncated response content should be omitted, but got %s", har)
+	}
+
+	// flow files keep the mark
+	res = httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", "/api/flows/export?format=flows", nil))
+	exported, err := proxy.ReadFlows(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 1 || !exported[0].Response.BodyTruncated || exported[0].Response.BodySize != 100 {
+		t.Fatalf("unexpected exported flows %v", exported)
+	}
+
+	// truncated request body is not exported as a snippet sending the cut body
+	f = newFlowStoreTestFlow("/b", "hello")
+	bodies = bodiesOf(f)
+	bodies.req.size, bodies.req.truncated = 100, true
+	store.add(f, bodies)
+	res = httptest.NewRecorder()
+	store.ServeHTTP(res, httptest.NewRequest("GET", "/api/flows/"+f.Id.String()+"/export?format=curl", nil))
+	if res.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, but got %v %s", res.Code, res.Body.Bytes())
+	}
+}
@@ -0,0 +1,655 @@
+package addon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// write flows in HAR 1.2 format, which can be imported by browser devtools, Charles, Fiddler etc.
+// Custom fields, prefixed by underscore as the spec allows:
+//   postData._encoding  base64 when the request body is not utf8 and postData.text is base64 encoded
+//   response._error     error of the flow
+// Bodies larger than MaxBodySize are noted in comment of the entry, content text of a truncated response is omitted,
+// as the cut body can be neither decoded nor used as is.
+// reference
+// http://www.softwareishard.com/blog/har-12-spec/
+
+const (
+	defaultHarMaxBodySize = 1024 * 1024
+	defaultHarMaxEntries  = 1000
+)
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HttpOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type harPostData struct {
+	MimeType string         `json:"mimeType"`
+	Params   []harNameValue `json:"params"`
+	Text     string         `json:"text"`
+	Encoding string         `json:"_encoding,omitempty"` // custom field, base64 for binary body
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size        int    `json:"size"`
+	Compression int    `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harCookie    `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Error       string         `json:"_error,omitempty"` // custom field
+}
+
+// harTimings in milliseconds, -1 means not applicable
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Connection      string      `json:"connection,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+	flowId          uuid.UUID   // not serialized
+	raw             []byte      // serialized entry
+}
+
+// harFlow event times of one flow
+type harFlow struct {
+	start    time.Time
+	request  time.Time
+	response time.Time
+}
+
+// harConn event times of one server connection
+type harConn struct {
+	connected      time.Time
+	tlsEstablished time.Time
+}
+
+type HarWriter struct {
+	proxy.BaseAddon
+	MaxBodySize int  // bytes of body kept for each request and response, exceeded bytes are dropped
+	MaxEntries  int  // number of recent entries kept in memory for download, 0 - disable
+	WriteOnDone bool // write entry when flow done, set false to write by AddFlow in custom order, default true
+
+	file    *os.File
+	offset  int64 // end of the last entry in file, the trailer follows
+	written int
+
+	flows   *recordFlows
+	times   map[uuid.UUID]*harFlow
+	conns   map[uuid.UUID]*harConn
+	entries []*harEntry
+	mu      sync.Mutex
+}
+
+const (
+	harHeader  = `{"log":{"version":"1.2","creator":{"name":"go-mitmproxy","version":"` + proxy.Version + `"},"pages":[],"entries":[`
+	harTrailer = "\n]}}\n"
+)
+
+// NewHarWriter write entries to the HAR file of path, the file is kept valid after each entry.
+// Empty path means only keep recent entries in memory for download.
+func NewHarWriter(path string) (*HarWriter, error) {
+	w := &HarWriter{
+		MaxBodySize: defaultHarMaxBodySize,
+		MaxEntries:  defaultHarMaxEntries,
+		WriteOnDone: true,
+		flows:       newRecordFlows(),
+		times:       make(map[uuid.UUID]*harFlow),
+		conns:       make(map[uuid.UUID]*harConn),
+		entries:     make([]*harEntry, 0),
+	}
+	if path == "" {
+		return w, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.WriteString(harHeader + harTrailer); err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.file = file
+	w.offset = int64(len(harHeader))
+	return w, nil
+}
+
+func (w *HarWriter) ServerConnected(connCtx *proxy.ConnContext) {
+	w.mu.Lock()
+	w.conns[connCtx.ServerConn.Id] = &harConn{connected: time.Now()}
+	w.mu.Unlock()
+}
+
+func (w *HarWriter) TlsEstablishedServer(connCtx *proxy.ConnContext) {
+	w.mu.Lock()
+	if c, ok := w.conns[connCtx.ServerConn.Id]; ok {
+		c.tlsEstablished = time.Now()
+	}
+	w.mu.Unlock()
+}
+
+func (w *HarWriter) ServerDisconnected(connCtx *proxy.ConnContext) {
+	w.mu.Lock()
+	delete(w.conns, connCtx.ServerConn.Id)
+	w.mu.Unlock()
+}
+
+func (w *HarWriter) BeginFlow(f *proxy.Flow) {
+	w.mu.Lock()
+	w.times[f.Id] = &harFlow{start: time.Now()}
+	w.mu.Unlock()
+
+	if w.WriteOnDone {
+		w.flows.beginWithBodies(f, w.addFlow)
+	}
+}
+
+// AddFlow writes the entry of finished flow f with its bodies as is, timings are gathered from events of f received by the addon
+func (w *HarWriter) AddFlow(f *proxy.Flow) {
+	w.addFlow(f, bodiesOf(f))
+}
+
+func (w *HarWriter) addFlow(f *proxy.Flow, bodies *recordedBodies) {
+	end := time.Now()
+	w.mu.Lock()
+	hf, ok := w.times[f.Id]
+	if !ok {
+		hf = &harFlow{start: end}
+	}
+	delete(w.times, f.Id)
+	var conn harConn
+	if f.ConnContext != nil && f.ConnContext.ServerConn != nil {
+		if c, ok := w.conns[f.ConnContext.ServerConn.Id]; ok {
+			conn = *c
+		}
+	}
+	w.mu.Unlock()
+	w.add(newHarEntry(f, bodies, hf, conn, end, w.MaxBodySize))
+}
+
+func (w *HarWriter) getTimes(f *proxy.Flow) *harFlow {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.times[f.Id]
+}
+
+func (w *HarWriter) Request(f *proxy.Flow) {
+	if hf := w.getTimes(f); hf != nil {
+		hf.request = time.Now()
+	}
+	w.flows.request(f, w.MaxBodySize)
+}
+
+func (w *HarWriter) Response(f *proxy.Flow) {
+	if hf := w.getTimes(f); hf != nil {
+		hf.response = time.Now()
+	}
+	w.flows.response(f, w.MaxBodySize)
+}
+
+func (w *HarWriter) add(entry *harEntry) {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("har writer marshal error: %v", err)
+		return
+	}
+	entry.raw = raw
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.MaxEntries > 0 {
+		w.entries = append(w.entries, entry)
+		if len(w.entries) > w.MaxEntries {
+			w.entries = w.entries[len(w.entries)-w.MaxEntries:]
+		}
+	}
+	if w.file != nil {
+		if err := w.writeEntry(raw); err != nil {
+			log.Errorf("har writer write error: %v", err)
+		}
+	}
+}
+
+// writeEntry overwrite the trailer with the entry, then write the trailer again
+func (w *HarWriter) writeEntry(raw []byte) error {
+	buf := make([]byte, 0, len(raw)+len(harTrailer)+2)
+	if w.written > 0 {
+		buf = append(buf, ',')
+	}
+	buf = append(buf, '\n')
+	buf = append(buf, raw...)
+	n := len(buf)
+	buf = append(buf, harTrailer...)
+	if _, err := w.file.WriteAt(buf, w.offset); err != nil {
+		return err
+	}
+	w.offset += int64(n)
+	w.written++
+	return nil
+}
+
+func (w *HarWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
+
+// WriteTo writes the selected entries kept in memory as a HAR file, all entries when ids is empty
+func (w *HarWriter) WriteTo(out io.Writer, ids []uuid.UUID) error {
+	w.mu.Lock()
+	entries := make([]*harEntry, 0, len(w.entries))
+	for _, entry := range w.entries {
+		if len(ids) == 0 || containsUUID(ids, entry.flowId) {
+			entries = append(entries, entry)
+		}
+	}
+	w.mu.Unlock()
+	return writeHarEntries(out, entries)
+}
+
+func writeHarEntries(out io.Writer, entries []*harEntry) error {
+	buf := new(bytes.Buffer)
+	buf.WriteString(harHeader)
+	for i, entry := range entries {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+		buf.Write(entry.raw)
+	}
+	buf.WriteString(harTrailer)
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP download HAR file, query flow: comma separated flow ids, empty means all
+func (w *HarWriter) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	ids, err := parseFlowIds(req.URL.Query().Get("flow"))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filename := "go-mitmproxy.har"
+	if len(ids) == 1 {
+		filename = fmt.Sprintf("go-mitmproxy-%v.har", ids[0])
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := w.WriteTo(res, ids); err != nil {
+		log.Errorf("har writer download error: %v", err)
+	}
+}
+
+// parseFlowIds parses comma separated flow ids
+func parseFlowIds(flowIds string) ([]uuid.UUID, error) {
+	ids := make([]uuid.UUID, 0)
+	if flowIds == "" {
+		return ids, nil
+	}
+	for _, s := range strings.Split(flowIds, ",") {
+		id, err := uuid.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid flow id %v", s)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func containsUUID(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// newHarEntry f holds the recorded bodies, which may be truncated as told by bodies
+func newHarEntry(f *proxy.Flow, bodies *recordedBodies, hf *harFlow, conn harConn, end time.Time, maxBodySize int) *harEntry {
+	entry := &harEntry{
+		StartedDateTime: hf.start.Format(time.RFC3339Nano),
+		flowId:          f.Id,
+	}
+
+	// request
+	reqBody, reqSize := f.Request.Body, bodies.req.size
+	entry.Request = harRequest{
+		Method:      f.Request.Method,
+		URL:         f.Request.URL.String(),
+		HTTPVersion: f.Request.Proto,
+		Cookies:     harRequestCookies(f.Request.Header),
+		Headers:     harHeaders(f.Request.Header),
+		QueryString: harQueryString(f.Request),
+		HeadersSize: -1,
+		BodySize:    reqSize,
+	}
+	if reqSize > 0 {
+		text, encoding := harText(reqBody)
+		entry.Request.PostData = &harPostData{
+			MimeType: f.Request.Header.Get("Content-Type"),
+			Params:   []harNameValue{},
+			Text:     text,
+			Encoding: encoding,
+		}
+	}
+
+	// response
+	if f.Response != nil {
+		respBody, respSize := f.Response.Body, bodies.resp.size
+		entry.Response = harResponse{
+			Status:      f.Response.StatusCode,
+			StatusText:  http.StatusText(f.Response.StatusCode),
+			HTTPVersion: f.Request.Proto,
+			Cookies:     harResponseCookies(f.Response.Header),
+			Headers:     harHeaders(f.Response.Header),
+			RedirectURL: f.Response.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    respSize,
+		}
+		content := harContent{
+			Size:     respSize,
+			MimeType: f.Response.Header.Get("Content-Type"),
+		}
+		if len(respBody) > 0 && !bodies.resp.truncated {
+			body := respBody
+			decoded, err := (&proxy.Response{Header: f.Response.Header, Body: respBody}).DecodedBody()
+			if err == nil {
+				body = decoded
+				content.Size = len(decoded)
+				content.Compression = respSize - len(decoded)
+			}
+			content.Text, content.Encoding = harText(body)
+		}
+		entry.Response.Content = content
+	} else {
+		entry.Response = harResponse{
+			Cookies:     []harCookie{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+	}
+	if f.Error != nil {
+		entry.Response.Error = f.Error.Error()
+	}
+	comments := make([]string, 0)
+	if bodies.req.truncated {
+		comments = append(comments, fmt.Sprintf("request body of %v bytes truncated to %v bytes", bodies.req.size, maxBodySize))
+	}
+	if bodies.resp.truncated {
+		comments = append(comments, fmt.Sprintf("response body of %v bytes exceeds %v bytes, content text omitted", bodies.resp.size, maxBodySize))
+	}
+	entry.Comment = strings.Join(comments, "; ")
+
+	// timings
+	entry.Timings = harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	sendStart := hf.request
+	if sendStart.IsZero() {
+		sendStart = hf.start
+	}
+	if !conn.connected.IsZero() && conn.connected.After(hf.start) {
+		connectEnd := conn.connected
+		if !conn.tlsEstablished.IsZero() {
+			connectEnd = conn.tlsEstablished
+			entry.Timings.SSL = harMillis(conn.tlsEstablished.Sub(conn.connected))
+		}
+		entry.Timings.Connect = harMillis(connectEnd.Sub(sendStart))
+		sendStart = connectEnd
+	}
+	sendEnd := sendStart
+	if bodies.req.end.After(sendStart) {
+		sendEnd = bodies.req.end
+	}
+	entry.Timings.Send = harMillis(sendEnd.Sub(sendStart))
+	receiveStart := hf.response
+	if receiveStart.IsZero() || receiveStart.Before(sendEnd) {
+		receiveStart = sendEnd
+	}
+	entry.Timings.Wait = harMillis(receiveStart.Sub(sendEnd))
+	receiveEnd := end
+	if !bodies.resp.end.IsZero() {
+		receiveEnd = bodies.resp.end
+	}
+	entry.Timings.Receive = harMillis(receiveEnd.Sub(receiveStart))
+	entry.Time = harMillis(receiveEnd.Sub(hf.start))
+
+	if f.ConnContext != nil {
+		if f.ConnContext.ClientConn != nil {
+			entry.Connection = f.ConnContext.ClientConn.Id.String()
+		}
+		if f.ConnContext.ServerConn != nil && f.ConnContext.ServerConn.Conn != nil {
+			if host, _, err := net.SplitHostPort(f.ConnContext.ServerConn.Conn.RemoteAddr().String()); err == nil {
+				entry.ServerIPAddress = host
+			}
+		}
+	}
+
+	return entry
+}
+
+func harMillis(d time.Duration) float64 {
+	if d < 0 {
+		return 0
+	}
+	return float64(d.Microseconds()) / 1000
+}
+
+// harText utf8 text as is, others in base64
+func harText(body []byte) (text string, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headers := make([]harNameValue, 0, len(header))
+	for _, name := range names {
+		for _, value := range header[name] {
+			headers = append(headers, harNameValue{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+func harQueryString(req *proxy.Request) []harNameValue {
+	query := make([]harNameValue, 0)
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			query = append(query, harNameValue{Name: name, Value: value})
+		}
+	}
+	return query
+}
+
+func harRequestCookies(header http.Header) []harCookie {
+	cookies := make([]harCookie, 0)
+	for _, c := range (&http.Request{Header: header}).Cookies() {
+		cookies = append(cookies, harCookie{Name: c.Name, Value: c.Value})
+	}
+	return cookies
+}
+
+func harResponseCookies(header http.Header) []harCookie {
+	cookies := make([]harCookie, 0)
+	for _, c := range (&http.Response{Header: header}).Cookies() {
+		cookie := harCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HttpOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			cookie.Expires = c.Expires.Format(time.RFC3339)
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies
+}
+
+// ReadHar returns flows of the entries in HAR, e.g. for replay.
+// Response of the flow is nil when the entry has no response, response body is decoded and Content-Encoding removed.
+func ReadHar(r io.Reader) ([]*proxy.Flow, error) {
+	var har struct {
+		Log struct {
+			Entries []*harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return nil, fmt.Errorf("decode har: %w", err)
+	}
+
+	flows := make([]*proxy.Flow, 0, len(har.Log.Entries))
+	for i, entry := range har.Log.Entries {
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("har entry %v: %w", i, err)
+		}
+		var body []byte
+		if postData := entry.Request.PostData; postData != nil {
+			body, err = harDecodeText(postData.Text, postData.Encoding)
+			if err != nil {
+				return nil, fmt.Errorf("har entry %v: %w", i, err)
+			}
+		}
+		proto := entry.Request.HTTPVersion
+		if !strings.HasPrefix(proto, "HTTP/") {
+			proto = "HTTP/1.1"
+		}
+		f := &proxy.Flow{
+			Id: uuid.New(),
+			Request: &proxy.Request{
+				Method: entry.Request.Method,
+				URL:    u,
+				Proto:  proto,
+				Header: harReadHeaders(entry.Request.Headers),
+				Body:   body,
+			},
+		}
+
+		if entry.Response.Status > 0 {
+			body, err := harDecodeText(entry.Response.Content.Text, entry.Response.Content.Encoding)
+			if err != nil {
+				return nil, fmt.Errorf("har entry %v: %w", i, err)
+			}
+			resp := &proxy.Response{
+				StatusCode: entry.Response.Status,
+				Header:     harReadHeaders(entry.Response.Headers),
+				Body:       body,
+			}
+			// content text is decoded by most writers, still encoded when decoding failed
+			if decoded, err := resp.DecodedBody(); err == nil {
+				resp.Body = decoded
+			}
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Del("Content-Length")
+			resp.Header.Del("Transfer-Encoding")
+			f.Response = resp
+		}
+		flows = append(flows, f)
+	}
+	return flows, nil
+}
+
+func ReadHarFile(filename string) ([]*proxy.Flow, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadHar(file)
+}
+
+func harReadHeaders(headers []harNameValue) http.Header {
+	header := make(http.Header)
+	for _, h := range headers {
+		// http2 pseudo headers exported by browsers, e.g. :authority
+		if strings.HasPrefix(h.Name, ":") {
+			continue
+		}
+		header.Add(h.Name, h.Value)
+	}
+	return header
+}
+
+func harDecodeText(text string, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
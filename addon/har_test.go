@@ -0,0 +1,129 @@
+package addon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func TestHarWriter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(append([]byte{0xff, 0xfe}, body...))
+	}))
+	defer server.Close()
+
+	harFile := filepath.Join(t.TempDir(), "flows.har")
+	harWriter, err := NewHarWriter(harFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer harWriter.Close()
+
+	p, err := proxy.NewProxy(&proxy.Options{Addr: ":29092"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddAddon(harWriter)
+	go p.Start()
+	time.Sleep(time.Millisecond * 10) // wait for proxy startup
+
+	proxyUrl, _ := url.Parse("http://127.0.0.1:29092")
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	resp, err := client.Post(server.URL+"/upload?a=1", "text/plain", bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var har struct {
+		Log struct {
+			Version string
+			Entries []*harEntry
+		}
+	}
+	for i := 0; i < 50; i++ {
+		time.Sleep(time.Millisecond * 10) // entry is written after the flow done
+		data, err := os.ReadFile(harFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &har); err != nil {
+			t.Fatalf("har file should always be valid json: %v", err)
+		}
+		if len(har.Log.Entries) > 0 {
+			break
+		}
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 1 {
+		t.Fatalf("expected 1 entry, but got %v", len(har.Log.Entries))
+	}
+
+	entry := har.Log.Entries[0]
+	if entry.Request.Method != "POST" || entry.Request.PostData == nil || entry.Request.PostData.Text != "hello" {
+		t.Fatalf("unexpected request %+v", entry.Request)
+	}
+	if len(entry.Request.QueryString) != 1 || entry.Request.QueryString[0].Value != "1" {
+		t.Fatalf("unexpected query string %+v", entry.Request.QueryString)
+	}
+	if entry.Response.Status != 200 || entry.Response.Content.Encoding != "base64" || entry.Response.Content.Size != 7 {
+		t.Fatalf("unexpected response %+v", entry.Response)
+	}
+	if len(entry.Response.Cookies) != 1 || entry.Response.Cookies[0].Value != "abc" {
+		t.Fatalf("unexpected response cookies %+v", entry.Response.Cookies)
+	}
+	if entry.Timings.Connect < 0 || entry.Time <= 0 {
+		t.Fatalf("unexpected timings %+v", entry.Timings)
+	}
+
+	flows, err := ReadHarFile(harFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow read from har, got %v", len(flows))
+	}
+	if req := flows[0].Request; req.Method != "POST" || string(req.Body) != "hello" || req.URL.Query().Get("a") != "1" {
+		t.Fatalf("unexpected request read from har %+v", req)
+	}
+	if resp := flows[0].Response; resp == nil || resp.StatusCode != 200 || len(resp.Body) != 7 {
+		t.Fatalf("unexpected response read from har %+v", resp)
+	}
+
+	rec := httptest.NewRecorder()
+	harWriter.ServeHTTP(rec, httptest.NewRequest("GET", "/export/har", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &har); err != nil || len(har.Log.Entries) != 1 {
+		t.Fatalf("download should contain 1 entry, err %v", err)
+	}
+}
+
+func TestHarEntryTruncated(t *testing.T) {
+	u, _ := url.Parse("http://example.com/upload")
+	f := &proxy.Flow{
+		Request:  &proxy.Request{Method: "POST", URL: u, Proto: "HTTP/1.1", Header: make(http.Header), Body: []byte("hel")},
+		Response: &proxy.Response{StatusCode: 200, Header: http.Header{"Content-Encoding": {"gzip"}}, Body: []byte{0x1f, 0x8b, 0x08}},
+	}
+	bodies := &recordedBodies{req: recordedBody{size: 5, truncated: true}, resp: recordedBody{size: 20, truncated: true}}
+	entry := newHarEntry(f, bodies, &harFlow{start: time.Now()}, harConn{}, time.Now(), 3)
+	if entry.Request.BodySize != 5 || entry.Request.PostData == nil || entry.Request.PostData.Text != "hel" {
+		t.Fatalf("unexpected request %+v", entry.Request)
+	}
+	if content := entry.Response.Content; content.Size != 20 || content.Text != "" || content.Encoding != "" {
+		t.Fatalf("content text of truncated response should be omitted, got %+v", content)
+	}
+	if entry.Comment != "request body of 5 bytes truncated to 3 bytes; response body of 20 bytes exceeds 3 bytes, content text omitted" {
+		t.Fatalf("unexpected comment %q", entry.Comment)
+	}
+}
@@ -0,0 +1,296 @@
+package addon
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/internal/pcapng"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// record the raw bytes of recent connections, export them as pcapng with TLS secrets embedded,
+// so that Wireshark opens the file already decrypted. At most MaxConnBytes of each connection and MaxBytes of all
+// connections are kept, 16MB and 256MB by default.
+
+const (
+	defaultPcapMaxConns     = 1000
+	defaultPcapMaxConnBytes = 1024 * 1024 * 16
+	defaultPcapMaxBytes     = 1024 * 1024 * 256
+)
+
+type pcapEvent struct {
+	ts         time.Time
+	server     bool // on the proxy to server connection
+	fromClient bool // sent by the client side of the tcp connection, for server connection the proxy is the client side
+	data       []byte
+	fin        bool
+}
+
+type pcapConn struct {
+	connCtx    *proxy.ConnContext
+	clientAddr *net.TCPAddr
+	proxyAddr  *net.TCPAddr
+	localAddr  *net.TCPAddr // proxy side of the server connection
+	serverAddr *net.TCPAddr
+	start      time.Time
+	events     []*pcapEvent
+	size       int
+	truncated  bool
+}
+
+type PcapExporter struct {
+	proxy.BaseAddon
+	MaxConns     int // number of recent connections kept. Default: 1000
+	MaxConnBytes int // bytes kept for each connection, exceeded bytes are dropped. Default: 16MB
+	MaxBytes     int // bytes kept for all connections, the oldest connections except the recording one are dropped when exceeded. Default: 256MB
+
+	conns map[uuid.UUID]*pcapConn
+	order []uuid.UUID
+	size  int // bytes of all connections
+	mu    sync.Mutex
+}
+
+func NewPcapExporter(maxConns int) *PcapExporter {
+	if maxConns <= 0 {
+		maxConns = defaultPcapMaxConns
+	}
+	return &PcapExporter{
+		MaxConns:     maxConns,
+		MaxConnBytes: defaultPcapMaxConnBytes,
+		MaxBytes:     defaultPcapMaxBytes,
+		conns:        make(map[uuid.UUID]*pcapConn),
+		order:        make([]uuid.UUID, 0),
+	}
+}
+
+func toTCPAddr(addr net.Addr) *net.TCPAddr {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok && tcpAddr != nil {
+		return tcpAddr
+	}
+	if addr != nil {
+		if tcpAddr, err := net.ResolveTCPAddr("tcp", addr.String()); err == nil {
+			return tcpAddr
+		}
+	}
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func (e *PcapExporter) getConn(connCtx *proxy.ConnContext) *pcapConn {
+	id := connCtx.Id()
+	if c, ok := e.conns[id]; ok {
+		return c
+	}
+
+	c := &pcapConn{
+		connCtx:    connCtx,
+		clientAddr: toTCPAddr(connCtx.ClientConn.Conn.RemoteAddr()),
+		proxyAddr:  toTCPAddr(connCtx.ClientConn.Conn.LocalAddr()),
+		start:      time.Now(),
+		events:     make([]*pcapEvent, 0),
+	}
+	e.conns[id] = c
+	e.order = append(e.order, id)
+	for len(e.order) > e.MaxConns {
+		e.removeOldest()
+	}
+	return c
+}
+
+// removeOldest drops the oldest connection, call with mu held
+func (e *PcapExporter) removeOldest() {
+	id := e.order[0]
+	if c, ok := e.conns[id]; ok {
+		e.size -= c.size
+		delete(e.conns, id)
+	}
+	e.order = e.order[1:]
+}
+
+func (e *PcapExporter) addEvent(connCtx *proxy.ConnContext, event *pcapEvent) {
+	if connCtx.ClientConn == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := e.getConn(connCtx)
+	if c.size+len(event.data) > e.MaxConnBytes {
+		if !c.truncated {
+			log.Warnf("pcap exporter: connection %v exceeds %v bytes, truncated", connCtx.Id(), e.MaxConnBytes)
+		}
+		c.truncated = true
+		return
+	}
+	c.size += len(event.data)
+	c.events = append(c.events, event)
+	e.size += len(event.data)
+	// keep the connection being recorded
+	for e.MaxBytes > 0 && e.size > e.MaxBytes && len(e.order) > 1 && e.order[0] != connCtx.Id() {
+		e.removeOldest()
+	}
+}
+
+func (e *PcapExporter) ClientData(connCtx *proxy.ConnContext, data []byte, fromClient bool) {
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	e.addEvent(connCtx, &pcapEvent{ts: time.Now(), fromClient: fromClient, data: buf})
+}
+
+func (e *PcapExporter) ServerData(connCtx *proxy.ConnContext, data []byte, fromServer bool) {
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	e.addEvent(connCtx, &pcapEvent{ts: time.Now(), server: true, fromClient: !fromServer, data: buf})
+}
+
+func (e *PcapExporter) ServerConnected(connCtx *proxy.ConnContext) {
+	if connCtx.ClientConn == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := e.getConn(connCtx)
+	c.localAddr = toTCPAddr(connCtx.ServerConn.Conn.LocalAddr())
+	c.serverAddr = toTCPAddr(connCtx.ServerConn.Conn.RemoteAddr())
+	// the handshake of server connection
+	c.events = append(c.events, &pcapEvent{ts: time.Now(), server: true})
+}
+
+func (e *PcapExporter) ServerDisconnected(connCtx *proxy.ConnContext) {
+	e.addEvent(connCtx, &pcapEvent{ts: time.Now(), server: true, fromClient: true, fin: true})
+}
+
+func (e *PcapExporter) ClientDisconnected(client *proxy.ClientConn) {
+	e.mu.Lock()
+	c, ok := e.conns[client.Id]
+	e.mu.Unlock()
+	if ok {
+		e.addEvent(c.connCtx, &pcapEvent{ts: time.Now(), fromClient: true, fin: true})
+	}
+}
+
+type pcapPacket struct {
+	ts   time.Time
+	data []byte
+}
+
+func (c *pcapConn) packets(events []*pcapEvent) []*pcapPacket {
+	packets := make([]*pcapPacket, 0, len(events)+6)
+	add := func(ts time.Time, datas [][]byte) {
+		for _, data := range datas {
+			packets = append(packets, &pcapPacket{ts: ts, data: data})
+		}
+	}
+
+	clientStream := pcapng.NewTCPStream(c.clientAddr, c.proxyAddr)
+	add(c.start, clientStream.Handshake())
+	var serverStream *pcapng.TCPStream
+
+	for _, event := range events {
+		stream := clientStream
+		if event.server {
+			if serverStream == nil {
+				if c.serverAddr == nil {
+					continue
+				}
+				serverStream = pcapng.NewTCPStream(c.localAddr, c.serverAddr)
+				add(event.ts, serverStream.Handshake())
+				if event.data == nil && !event.fin {
+					continue
+				}
+			}
+			stream = serverStream
+		}
+		if event.fin {
+			add(event.ts, stream.Close(event.fromClient))
+		} else if len(event.data) > 0 {
+			add(event.ts, stream.Data(event.fromClient, event.data))
+		}
+	}
+	return packets
+}
+
+// WriteTo writes the selected connections in pcapng format, all recorded connections when ids is empty
+func (e *PcapExporter) WriteTo(w io.Writer, ids []uuid.UUID) error {
+	e.mu.Lock()
+	if len(ids) == 0 {
+		ids = make([]uuid.UUID, len(e.order))
+		copy(ids, e.order)
+	}
+	conns := make([]*pcapConn, 0, len(ids))
+	eventsList := make([][]*pcapEvent, 0, len(ids))
+	for _, id := range ids {
+		if c, ok := e.conns[id]; ok {
+			conns = append(conns, c)
+			eventsList = append(eventsList, c.events[:len(c.events):len(c.events)])
+		}
+	}
+	e.mu.Unlock()
+
+	pw, err := pcapng.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	keyLog := new(strings.Builder)
+	for _, c := range conns {
+		for _, line := range c.connCtx.TlsKeyLog() {
+			keyLog.WriteString(line)
+			keyLog.WriteString("\n")
+		}
+	}
+	if keyLog.Len() > 0 {
+		if err := pw.WriteDecryptionSecrets(pcapng.SecretsTypeTLSKeyLog, []byte(keyLog.String())); err != nil {
+			return err
+		}
+	}
+
+	if err := pw.WriteInterface(pcapng.LinkTypeRaw); err != nil {
+		return err
+	}
+
+	packets := make([]*pcapPacket, 0)
+	for i, c := range conns {
+		packets = append(packets, c.packets(eventsList[i])...)
+	}
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].ts.Before(packets[j].ts)
+	})
+	for _, p := range packets {
+		if err := pw.WritePacket(0, p.ts, p.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP download pcapng file, query conn: comma separated connection ids, empty means all
+func (e *PcapExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ids := make([]uuid.UUID, 0)
+	if connIds := r.URL.Query().Get("conn"); connIds != "" {
+		for _, s := range strings.Split(connIds, ",") {
+			id, err := uuid.Parse(strings.TrimSpace(s))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid conn id %v", s), http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	filename := "go-mitmproxy.pcapng"
+	if len(ids) == 1 {
+		filename = fmt.Sprintf("go-mitmproxy-%v.pcapng", ids[0])
+	}
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := e.WriteTo(w, ids); err != nil {
+		log.Errorf("pcap exporter write error: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package addon
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func TestPcapExporterMaxBytes(t *testing.T) {
+	e := NewPcapExporter(10)
+	e.MaxBytes = 25
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conns := make([]*proxy.ConnContext, 0)
+	for i := 0; i < 3; i++ {
+		connCtx := &proxy.ConnContext{ClientConn: &proxy.ClientConn{Id: uuid.New(), Conn: client}}
+		conns = append(conns, connCtx)
+		e.ClientData(connCtx, make([]byte, 10), true)
+	}
+	// 30 bytes exceed MaxBytes, the oldest connection is dropped
+	if _, ok := e.conns[conns[0].Id()]; ok || len(e.conns) != 2 || e.size != 20 {
+		t.Fatalf("expected the oldest connection dropped, but got %v connections of %v bytes", len(e.conns), e.size)
+	}
+
+	// the connection being recorded is kept, even it exceeds MaxBytes alone
+	e.ClientData(conns[2], make([]byte, 30), true)
+	if _, ok := e.conns[conns[2].Id()]; !ok || len(e.conns) != 1 || e.size != 40 {
+		t.Fatalf("expected 1 connection of 40 bytes, but got %v connections of %v bytes", len(e.conns), e.size)
+	}
+}
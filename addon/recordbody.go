@@ -0,0 +1,185 @@
+package addon
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// recordBody records the body while it is streamed through proxy, up to max bytes
+type recordBody struct {
+	r         io.Reader
+	max       int
+	buf       bytes.Buffer
+	size      int
+	truncated bool
+	end       time.Time // time of EOF
+	mu        sync.Mutex
+}
+
+func newRecordBody(r io.Reader, max int) *recordBody {
+	return &recordBody{r: r, max: max}
+}
+
+func (b *recordBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.mu.Lock()
+	b.size += n
+	if remain := b.max - b.buf.Len(); remain > 0 {
+		if n > remain {
+			b.buf.Write(p[:remain])
+			b.truncated = true
+		} else {
+			b.buf.Write(p[:n])
+		}
+	} else if n > 0 {
+		b.truncated = true
+	}
+	if err != nil && b.end.IsZero() {
+		b.end = time.Now()
+	}
+	b.mu.Unlock()
+	return n, err
+}
+
+func (b *recordBody) Close() error {
+	if closer, ok := b.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (b *recordBody) result() (body []byte, size int, truncated bool, end time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes(), b.size, b.truncated, b.end
+}
+
+// recordFlows records bodies of flows streamed through proxy, for addons writing finished flows
+type recordFlows struct {
+	flows map[uuid.UUID]*recordFlow
+	mu    sync.Mutex
+}
+
+type recordFlow struct {
+	reqBody  *recordBody
+	respBody *recordBody
+}
+
+func newRecordFlows() *recordFlows {
+	return &recordFlows{flows: make(map[uuid.UUID]*recordFlow)}
+}
+
+// begin calls done with a copy of f holding the recorded bodies after f is done, call in BeginFlow
+func (r *recordFlows) begin(f *proxy.Flow, done func(*proxy.Flow)) {
+	r.beginWithBodies(f, func(f *proxy.Flow, _ *recordedBodies) { done(f) })
+}
+
+// beginWithBodies is like begin, done also gets the real sizes of the bodies, which may be truncated in the copy
+func (r *recordFlows) beginWithBodies(f *proxy.Flow, done func(*proxy.Flow, *recordedBodies)) {
+	rf := new(recordFlow)
+	r.mu.Lock()
+	r.flows[f.Id] = rf
+	r.mu.Unlock()
+
+	go func() {
+		<-f.Done()
+		r.mu.Lock()
+		delete(r.flows, f.Id)
+		r.mu.Unlock()
+		done(rf.flow(f))
+	}()
+}
+
+func (r *recordFlows) get(f *proxy.Flow) *recordFlow {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.flows[f.Id]
+}
+
+// request records the request body up to max bytes, call in Request
+func (r *recordFlows) request(f *proxy.Flow, max int) {
+	rf := r.get(f)
+	if rf == nil || f.Request.Body != nil {
+		return
+	}
+	if raw := f.Request.Raw(); raw != nil && raw.Body != nil && raw.Body != http.NoBody {
+		rf.reqBody = newRecordBody(raw.Body, max)
+		raw.Body = rf.reqBody
+	}
+}
+
+// response records the response body up to max bytes, call in Response
+func (r *recordFlows) response(f *proxy.Flow, max int) {
+	rf := r.get(f)
+	if rf == nil {
+		return
+	}
+	if f.Response.Body == nil && f.Response.BodyReader != nil {
+		rf.respBody = newRecordBody(f.Response.BodyReader, max)
+		f.Response.BodyReader = rf.respBody
+	}
+}
+
+// recordedBody describes a body of the recorded copy
+type recordedBody struct {
+	size      int       // bytes streamed through proxy
+	truncated bool      // the recorded body is cut at max bytes
+	end       time.Time // time of EOF, zero when the body is not streamed
+}
+
+type recordedBodies struct {
+	req  recordedBody
+	resp recordedBody
+}
+
+// bodiesOf describes bodies of f as is, e.g. f is not recorded by recordFlows, real sizes of bodies marked
+// truncated are kept, e.g. read from flow files
+func bodiesOf(f *proxy.Flow) *recordedBodies {
+	bodies := &recordedBodies{req: recordedBody{size: len(f.Request.Body)}}
+	if f.Request.BodyTruncated {
+		bodies.req.size, bodies.req.truncated = f.Request.BodySize, true
+	}
+	if f.Response != nil {
+		bodies.resp.size = len(f.Response.Body)
+		if f.Response.BodyTruncated {
+			bodies.resp.size, bodies.resp.truncated = f.Response.BodySize, true
+		}
+	}
+	return bodies
+}
+
+// markTruncated marks truncated bodies of the recorded copy f, e.g. for flow files
+func (b *recordedBodies) markTruncated(f *proxy.Flow) {
+	if b.req.truncated {
+		f.Request.BodyTruncated = true
+		f.Request.BodySize = b.req.size
+	}
+	if b.resp.truncated && f.Response != nil {
+		f.Response.BodyTruncated = true
+		f.Response.BodySize = b.resp.size
+	}
+}
+
+// flow returns a copy of f with the recorded bodies, f is shared with other addons
+func (rf *recordFlow) flow(f *proxy.Flow) (*proxy.Flow, *recordedBodies) {
+	recorded := *f
+	bodies := bodiesOf(f)
+	if rf.reqBody != nil {
+		req := *f.Request
+		req.Body, bodies.req.size, bodies.req.truncated, bodies.req.end = rf.reqBody.result()
+		recorded.Request = &req
+	}
+	if f.Response != nil && rf.respBody != nil {
+		resp := *f.Response
+		resp.Body, bodies.resp.size, bodies.resp.truncated, bodies.resp.end = rf.respBody.result()
+		resp.BodyReader = nil
+		recorded.Response = &resp
+	}
+	return &recorded, bodies
+}
@@ -0,0 +1,150 @@
+package addon
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/log"
+)
+
+const rotateTimeFormat = "20060102-150405"
+
+// rotateFile appends to a file, which is renamed with the time of rotation and compressed by gzip in background
+// when it exceeds maxSize bytes or was opened interval ago, e.g. dump.jsonl to dump-20060102-150405.jsonl.gz
+type rotateFile struct {
+	filename string
+	maxSize  int64         // 0 - never rotate by size
+	interval time.Duration // 0 - never rotate by time
+
+	file   *os.File
+	size   int64
+	opened time.Time
+	wg     sync.WaitGroup // compressing rotated files
+	mu     sync.Mutex
+}
+
+func openRotateFile(filename string, maxSize int64, interval time.Duration) (*rotateFile, error) {
+	r := &rotateFile{filename: filename, maxSize: maxSize, interval: interval}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open appends to the file, call with mu held
+func (r *rotateFile) open() error {
+	file, err := os.OpenFile(r.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	r.opened = time.Now()
+	return nil
+}
+
+// Write writes p to the file, rotates before writing when p would exceed maxSize or interval elapsed
+func (r *rotateFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && (r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize || r.interval > 0 && time.Since(r.opened) >= r.interval) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file and opens a new one, call with mu held
+func (r *rotateFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		log.Warnf("close %v error: %v", r.filename, err)
+	}
+	r.file = nil
+	rotated := rotatedFilename(r.filename, time.Now())
+	if err := os.Rename(r.filename, rotated); err != nil {
+		// keep appending to the file
+		log.Errorf("rotate %v error: %v", r.filename, err)
+		return r.open()
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := gzipFile(rotated); err != nil {
+			log.Errorf("compress %v error: %v", rotated, err)
+		}
+	}()
+	return r.open()
+}
+
+func (r *rotateFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return err
+}
+
+// rotatedFilename returns unused name of the rotated file, e.g. dump.jsonl to dump-20060102-150405.jsonl,
+// a serial is appended when rotated in the same second
+func rotatedFilename(filename string, t time.Time) string {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext) + "-" + t.Format(rotateTimeFormat)
+	name := base + ext
+	for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+		name = fmt.Sprintf("%v-%v%v", base, i, ext)
+	}
+	return name
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// gzipFile compresses the file to filename.gz and removes it
+func gzipFile(filename string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(filename+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(filename)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(filename)
+}
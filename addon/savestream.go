@@ -0,0 +1,110 @@
+package addon
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// save finished flows with bodies to a flow file, read back with proxy.FlowReader or ReadFlowsFile.
+// Bodies larger than MaxBodySize are truncated and marked in the flow file, they are not served by server replay
+// or sent by replay client.
+// Files with extension .mitm are written in the mitmproxy flow format, which can be opened by python mitmproxy,
+// the format has no truncated mark.
+
+const defaultSaveStreamMaxBodySize = 1024 * 1024 * 10
+
+type SaveStream struct {
+	proxy.BaseAddon
+	MaxBodySize int // bodies larger are truncated. Default: 10MB
+
+	file   *os.File
+	buf    *bufio.Writer
+	writer flowWriter
+	flows  *recordFlows
+	mu     sync.Mutex
+}
+
+// flowWriter proxy.FlowWriter or proxy.MitmproxyFlowWriter
+type flowWriter interface {
+	Write(f *proxy.Flow) error
+}
+
+func NewSaveStream(filename string) (*SaveStream, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(file)
+	var writer flowWriter = proxy.NewFlowWriter(buf)
+	if strings.ToLower(filepath.Ext(filename)) == ".mitm" {
+		writer = proxy.NewMitmproxyFlowWriter(buf)
+	}
+	return &SaveStream{
+		MaxBodySize: defaultSaveStreamMaxBodySize,
+		file:        file,
+		buf:         buf,
+		writer:      writer,
+		flows:       newRecordFlows(),
+	}, nil
+}
+
+func (s *SaveStream) BeginFlow(f *proxy.Flow) {
+	s.flows.beginWithBodies(f, s.write)
+}
+
+func (s *SaveStream) Request(f *proxy.Flow) {
+	s.flows.request(f, s.MaxBodySize)
+}
+
+func (s *SaveStream) Response(f *proxy.Flow) {
+	s.flows.response(f, s.MaxBodySize)
+}
+
+func (s *SaveStream) write(f *proxy.Flow, bodies *recordedBodies) {
+	bodies.markTruncated(f)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writer.Write(f); err != nil {
+		log.Errorf("save stream write flow error: %v", err)
+		return
+	}
+	if err := s.buf.Flush(); err != nil {
+		log.Errorf("save stream flush error: %v", err)
+	}
+}
+
+func (s *SaveStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
+
+// ReadFlowsFile reads flows from a flow file written by SaveStream, a mitmproxy flow file or a HAR file
+func ReadFlowsFile(filename string) ([]*proxy.Flow, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	magic, _ := r.Peek(len(proxy.FlowFileMagic))
+	switch {
+	case string(magic) == proxy.FlowFileMagic:
+		return proxy.ReadFlows(r)
+	case len(magic) > 0 && magic[0] >= '0' && magic[0] <= '9':
+		// tnetstring starts with the length
+		return proxy.ReadMitmproxyFlows(r)
+	default:
+		return ReadHar(r)
+	}
+}
@@ -0,0 +1,107 @@
+package addon
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func TestSaveStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(append([]byte{0xff, 0xfe}, body...))
+	}))
+	defer server.Close()
+
+	flowFile := filepath.Join(t.TempDir(), "flows")
+	saveStream, err := NewSaveStream(flowFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer saveStream.Close()
+
+	p, err := proxy.NewProxy(&proxy.Options{Addr: ":29093"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddAddon(saveStream)
+	go p.Start()
+	time.Sleep(time.Millisecond * 10) // wait for proxy startup
+
+	proxyUrl, _ := url.Parse("http://127.0.0.1:29093")
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	resp, err := client.Post(server.URL+"/upload?a=1", "text/plain", bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var flows []*proxy.Flow
+	for i := 0; i < 50; i++ {
+		time.Sleep(time.Millisecond * 10) // flow is written after done
+		flows, err = ReadFlowsFile(flowFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(flows) > 0 {
+			break
+		}
+	}
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, but got %v", len(flows))
+	}
+	f := flows[0]
+	if f.Request.Method != "POST" || string(f.Request.Body) != "hello" || f.Request.URL.Query().Get("a") != "1" {
+		t.Fatalf("unexpected request %+v", f.Request)
+	}
+	if f.Response == nil || f.Response.StatusCode != 200 || !bytes.Equal(f.Response.Body, []byte("\xff\xfehello")) {
+		t.Fatalf("unexpected response %+v", f.Response)
+	}
+	if f.ConnContext.ClientConn.Conn.RemoteAddr().String() == "" || f.ConnContext.ServerConn == nil || f.StartTime.IsZero() || f.EndTime.Before(f.StartTime) {
+		t.Fatalf("unexpected connection or timestamps %+v", f.ConnContext)
+	}
+
+	// mitmproxy flow file
+	mitmFile := filepath.Join(t.TempDir(), "flows.mitm")
+	mitmSaveStream, err := NewSaveStream(mitmFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitmSaveStream.write(f, bodiesOf(f))
+	mitmSaveStream.Close()
+	mitmFlows, err := ReadFlowsFile(mitmFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mitmFlows) != 1 || mitmFlows[0].Id != f.Id || string(mitmFlows[0].Request.Body) != "hello" || !bytes.Equal(mitmFlows[0].Response.Body, f.Response.Body) {
+		t.Fatalf("unexpected flows read from mitmproxy flow file %+v", mitmFlows)
+	}
+
+	// recorded flows answer requests without server
+	serverReplay := NewServerReplay()
+	serverReplay.Load(flows)
+	replayed := &proxy.Flow{Request: &proxy.Request{Method: "POST", URL: f.Request.URL, Header: make(http.Header), Body: []byte("hello")}}
+	serverReplay.Request(replayed)
+	if replayed.Response == nil || !bytes.Equal(replayed.Response.Body, f.Response.Body) {
+		t.Fatalf("unexpected replayed response %+v", replayed.Response)
+	}
+
+	// truncated response is not served
+	f.Response.BodyTruncated = true
+	serverReplay = NewServerReplay()
+	serverReplay.Load(flows)
+	replayed = &proxy.Flow{Request: &proxy.Request{Method: "POST", URL: f.Request.URL, Header: make(http.Header), Body: []byte("hello")}}
+	serverReplay.Request(replayed)
+	if replayed.Response != nil {
+		t.Fatalf("truncated response should not be replayed, but got %+v", replayed.Response)
+	}
+}
@@ -0,0 +1,273 @@
+package addon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/samber/lo"
+)
+
+// answer requests with responses of recorded flows, servers are never contacted for matched requests.
+// A request matches a recorded flow with the same method, scheme, host, path, query params,
+// values of MatchHeaders and body hash. When several recorded flows match, they are served in
+// recorded order and the last one is repeated. Recorded flows with truncated bodies are skipped, e.g. saved by
+// SaveStream beyond MaxBodySize.
+
+// what to do with requests not matching any recorded flow
+const (
+	ServerReplayPass     = "pass" // send to server as usual
+	ServerReplayNotFound = "404"  // respond 404
+	ServerReplayKill     = "kill" // close the client connection
+)
+
+var errServerReplayKilled = errors.New("server replay: no recorded flow matched, killed")
+
+type ServerReplay struct {
+	proxy.BaseAddon
+	Unmatched    string   // ServerReplayPass, ServerReplayNotFound or ServerReplayKill. Default: pass
+	IgnoreParams []string // query params not matched, e.g. timestamps or nonces
+	MatchHeaders []string // request headers matched, none by default
+	IgnoreBody   bool     // not match request body
+	IgnoreHost   bool     // not match scheme and host, e.g. recorded from another environment
+
+	mu        sync.Mutex
+	recorded  []*proxy.Flow
+	index     map[string][]*proxy.Response // built on first request with the options above
+	bodySizes map[string]int               // largest recorded body by key without body
+}
+
+func NewServerReplay() *ServerReplay {
+	return &ServerReplay{
+		Unmatched: ServerReplayPass,
+		recorded:  make([]*proxy.Flow, 0),
+	}
+}
+
+// NewServerReplayFromFiles loads recorded flows from flow files or HAR files
+func NewServerReplayFromFiles(filenames []string) (*ServerReplay, error) {
+	sr := NewServerReplay()
+	for _, filename := range filenames {
+		if err := sr.LoadFile(filename); err != nil {
+			return nil, fmt.Errorf("load %v: %w", filename, err)
+		}
+	}
+	return sr, nil
+}
+
+// Load adds recorded flows, flows without response are skipped
+func (sr *ServerReplay) Load(flows []*proxy.Flow) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	for _, f := range flows {
+		if f.Request == nil || f.Request.URL == nil || f.Response == nil {
+			continue
+		}
+		sr.recorded = append(sr.recorded, f)
+	}
+	sr.index = nil
+}
+
+func (sr *ServerReplay) LoadFile(filename string) error {
+	flows, err := ReadFlowsFile(filename)
+	if err != nil {
+		return err
+	}
+	sr.Load(flows)
+	return nil
+}
+
+func (sr *ServerReplay) Request(f *proxy.Flow) {
+	body, ok, err := sr.readBody(f.Request)
+	if err != nil {
+		log.Errorf("server replay read request body error: %v", err)
+		f.Error = err
+		f.Response = &proxy.Response{StatusCode: 502}
+		return
+	}
+
+	aurl := f.Request.URL.String()
+	if ok {
+		if resp := sr.match(f.Request, body); resp != nil {
+			log.Infof("server replay %v %v", f.Request.Method, aurl)
+			f.Response = resp
+			return
+		}
+	}
+
+	switch sr.Unmatched {
+	case ServerReplayNotFound:
+		log.Infof("server replay %v %v not found", f.Request.Method, aurl)
+		f.Response = &proxy.Response{
+			StatusCode: 404,
+			Header: map[string][]string{
+				"Content-Type": {"text/plain; charset=utf-8"},
+			},
+			Body: []byte("no recorded flow matched\n"),
+		}
+	case ServerReplayKill:
+		log.Infof("server replay %v %v killed", f.Request.Method, aurl)
+		f.Error = errServerReplayKilled
+		f.Response = &proxy.Response{StatusCode: 502}
+		if f.ConnContext != nil && f.ConnContext.ClientConn != nil && f.ConnContext.ClientConn.Conn != nil {
+			_ = f.ConnContext.ClientConn.Conn.Close()
+		}
+	}
+}
+
+// prefixedBody puts the bytes read for matching back before the rest of the streamed body
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// readBody returns the request body for matching, false when no recorded flow can match the request.
+// The streamed body is not read when the body is ignored or no recorded flow matches without body,
+// otherwise it is read up to the largest recorded body of the request and still streamed to server
+func (sr *ServerReplay) readBody(req *proxy.Request) ([]byte, bool, error) {
+	maxSize, ok := sr.maxBodySize(req)
+	if !ok {
+		return nil, false, nil
+	}
+	if sr.IgnoreBody || req.Body != nil {
+		return req.Body, true, nil
+	}
+	raw := req.Raw()
+	if raw == nil || raw.Body == nil {
+		return nil, true, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(raw.Body, int64(maxSize)+1))
+	if err != nil {
+		_ = raw.Body.Close()
+		return nil, false, err
+	}
+	raw.Body = &prefixedBody{io.MultiReader(bytes.NewReader(body), raw.Body), raw.Body}
+	if len(body) > maxSize {
+		return nil, false, nil
+	}
+	return body, true, nil
+}
+
+// buildIndex indexes recorded flows with the options, call with mu held
+func (sr *ServerReplay) buildIndex() {
+	if sr.index != nil {
+		return
+	}
+	sr.index = make(map[string][]*proxy.Response)
+	sr.bodySizes = make(map[string]int)
+	skipped := 0
+	for _, f := range sr.recorded {
+		// cut bodies are never served or matched as complete ones
+		if f.Response.BodyTruncated || (f.Request.BodyTruncated && !sr.IgnoreBody) {
+			skipped++
+			continue
+		}
+		key := sr.key(f.Request, f.Request.Body)
+		sr.index[key] = append(sr.index[key], f.Response)
+		reqKey := sr.requestKey(f.Request)
+		if size, ok := sr.bodySizes[reqKey]; !ok || len(f.Request.Body) > size {
+			sr.bodySizes[reqKey] = len(f.Request.Body)
+		}
+	}
+	if skipped > 0 {
+		log.Warnf("server replay skipped %v recorded flows with truncated bodies", skipped)
+	}
+}
+
+// maxBodySize returns size of the largest recorded body of flows matching req without body, false when none
+func (sr *ServerReplay) maxBodySize(req *proxy.Request) (int, bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.buildIndex()
+	size, ok := sr.bodySizes[sr.requestKey(req)]
+	return size, ok
+}
+
+func (sr *ServerReplay) match(req *proxy.Request, body []byte) *proxy.Response {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.buildIndex()
+
+	key := sr.key(req, body)
+	resps := sr.index[key]
+	if len(resps) == 0 {
+		return nil
+	}
+	resp := resps[0]
+	if len(resps) > 1 {
+		sr.index[key] = resps[1:]
+	}
+
+	// addons may modify the response of the flow
+	header := resp.Header.Clone()
+	header.Del("Content-Length") // body may be truncated when recorded
+	return &proxy.Response{
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Body:       bytes.Clone(resp.Body),
+	}
+}
+
+func (sr *ServerReplay) key(req *proxy.Request, body []byte) string {
+	key := sr.requestKey(req)
+	if sr.IgnoreBody {
+		return key
+	}
+	sum := sha256.Sum256(body)
+	return key + "\n" + hex.EncodeToString(sum[:])
+}
+
+// requestKey is the key without body
+func (sr *ServerReplay) requestKey(req *proxy.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	if !sr.IgnoreHost {
+		b.WriteString(req.URL.Scheme)
+		b.WriteString("://")
+		b.WriteString(serverReplayHost(req.URL))
+	}
+	b.WriteString(req.URL.EscapedPath())
+
+	query := make(url.Values)
+	for name, values := range req.URL.Query() {
+		if !lo.Contains(sr.IgnoreParams, name) {
+			query[name] = values
+		}
+	}
+	if len(query) > 0 {
+		b.WriteByte('?')
+		b.WriteString(query.Encode())
+	}
+
+	for _, name := range sr.MatchHeaders {
+		b.WriteByte('\n')
+		b.WriteString(strings.ToLower(name))
+		b.WriteByte(':')
+		b.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// serverReplayHost lower case host without the default port of scheme
+func serverReplayHost(u *url.URL) string {
+	host := strings.ToLower(u.Host)
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+			host = h
+			if strings.Contains(h, ":") {
+				host = "[" + h + "]"
+			}
+		}
+	}
+	return host
+}
@@ -0,0 +1,154 @@
+package addon
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+const testServerReplayHar = `{"log": {"version": "1.2", "entries": [
+	{"request": {"method": "GET", "url": "http://api.example.com/items?page=1&ts=100", "httpVersion": "HTTP/1.1", "headers": [{"name": "Accept", "value": "application/json"}]},
+	 "response": {"status": 200, "headers": [{"name": "Content-Type", "value": "application/json"}, {"name": "Content-Encoding", "value": "gzip"}, {"name": "Content-Length", "value": "30"}],
+	              "content": {"size": 9, "mimeType": "application/json", "text": "[1,2,3,4]"}}},
+	{"request": {"method": "POST", "url": "http://api.example.com:80/items", "httpVersion": "HTTP/1.1", "headers": [], "postData": {"mimeType": "text/plain", "text": "a"}},
+	 "response": {"status": 201, "headers": [], "content": {"size": 7, "mimeType": "text/plain", "text": "created"}}},
+	{"request": {"method": "GET", "url": "http://api.example.com/poll", "httpVersion": "HTTP/1.1", "headers": []},
+	 "response": {"status": 200, "headers": [], "content": {"size": 7, "mimeType": "text/plain", "text": "pending"}}},
+	{"request": {"method": "GET", "url": "http://api.example.com/poll", "httpVersion": "HTTP/1.1", "headers": []},
+	 "response": {"status": 200, "headers": [], "content": {"size": 4, "mimeType": "text/plain", "text": "done"}}},
+	{"request": {"method": "GET", "url": "http://api.example.com/error", "httpVersion": "HTTP/1.1", "headers": []},
+	 "response": {"status": 0, "headers": [], "content": {"size": 0, "mimeType": ""}, "_error": "connection refused"}}
+]}}`
+
+func TestServerReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("live"))
+	}))
+	defer server.Close()
+
+	flows, err := ReadHar(strings.NewReader(testServerReplayHar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverReplay := NewServerReplay()
+	serverReplay.IgnoreParams = []string{"ts"}
+	serverReplay.Load(flows)
+
+	p, err := proxy.NewProxy(&proxy.Options{
+		NewCaFunc: func() (cert.CA, error) { return cert.NewSelfSignCAMemory() },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddAddon(serverReplay)
+
+	replay := func(method, rawurl, body string) *proxy.Flow {
+		t.Helper()
+		u, _ := url.Parse(rawurl)
+		return p.ReplayRequest(&proxy.Request{Method: method, URL: u, Proto: "HTTP/1.1", Header: make(http.Header), Body: []byte(body)})
+	}
+	expect := func(f *proxy.Flow, statusCode int, body string) {
+		t.Helper()
+		if f.Response.StatusCode != statusCode || string(f.Response.Body) != body {
+			t.Fatalf("expected %v %q, but got %v %q, error %v", statusCode, body, f.Response.StatusCode, f.Response.Body, f.Error)
+		}
+	}
+
+	t.Run("match ignoring params", func(t *testing.T) {
+		f := replay("GET", "http://api.example.com/items?ts=200&page=1", "")
+		expect(f, 200, "[1,2,3,4]")
+		if f.Response.Header.Get("Content-Encoding") != "" || f.Response.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("unexpected header %v", f.Response.Header)
+		}
+		expect(replay("GET", "http://api.example.com/items?page=1", ""), 200, "[1,2,3,4]")
+	})
+
+	t.Run("match body", func(t *testing.T) {
+		expect(replay("POST", "http://API.example.com/items", "a"), 201, "created")
+	})
+
+	t.Run("repeated flows in order", func(t *testing.T) {
+		expect(replay("GET", "http://api.example.com/poll", ""), 200, "pending")
+		expect(replay("GET", "http://api.example.com/poll", ""), 200, "done")
+		expect(replay("GET", "http://api.example.com/poll", ""), 200, "done")
+	})
+
+	t.Run("unmatched pass", func(t *testing.T) {
+		expect(replay("GET", server.URL+"/items?page=1", ""), 200, "live")
+		expect(replay("POST", server.URL+"/items", "b"), 200, "live")
+	})
+
+	t.Run("unmatched 404", func(t *testing.T) {
+		serverReplay.Unmatched = ServerReplayNotFound
+		defer func() { serverReplay.Unmatched = ServerReplayPass }()
+		expect(replay("GET", server.URL+"/items?page=2", ""), 404, "no recorded flow matched\n")
+		// recorded flow without response is not replayed
+		expect(replay("GET", "http://api.example.com/error", ""), 404, "no recorded flow matched\n")
+	})
+
+	t.Run("unmatched kill", func(t *testing.T) {
+		serverReplay.Unmatched = ServerReplayKill
+		defer func() { serverReplay.Unmatched = ServerReplayPass }()
+		f := replay("GET", server.URL+"/items?page=2", "")
+		if f.Error != errServerReplayKilled {
+			t.Fatalf("expected killed, but got %v", f.Error)
+		}
+	})
+}
+
+func TestServerReplayStreamedBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	flows, err := ReadHar(strings.NewReader(testServerReplayHar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverReplay := NewServerReplay()
+	serverReplay.IgnoreHost = true
+	serverReplay.Load(flows)
+
+	p, err := proxy.NewProxy(&proxy.Options{Addr: ":29095"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddAddon(serverReplay)
+	go p.Start()
+	time.Sleep(time.Millisecond * 10) // wait for proxy startup
+
+	proxyUrl, _ := url.Parse("http://127.0.0.1:29095")
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	post := func(path, body string) (int, string) {
+		t.Helper()
+		resp, err := client.Post(server.URL+path, "text/plain", bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	if code, body := post("/items", "a"); code != 201 || body != "created" {
+		t.Fatalf("expected replayed response, but got %v %q", code, body)
+	}
+	// longer than any recorded body, the read prefix is sent to server with the rest
+	if code, body := post("/items", "abcdef"); code != 200 || body != "abcdef" {
+		t.Fatalf("expected full body sent to server, but got %v %q", code, body)
+	}
+	// no recorded flow of the url, body is not read
+	if code, body := post("/upload", "abcdef"); code != 200 || body != "abcdef" {
+		t.Fatalf("expected full body sent to server, but got %v %q", code, body)
+	}
+}
@@ -0,0 +1,304 @@
+package addon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// write finished flows to WARC 1.1 files, as request and response records, for web archiving tools such as pywb.
+// Files with extension .gz are compressed, one gzip member per record.
+// Bodies larger than MaxBodySize are cut, the records are marked by WARC-Truncated: length.
+// When a file exceeds MaxFileSize, a new file named like capture-00001.warc.gz is started.
+// reference
+// https://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/
+
+const (
+	defaultWarcMaxBodySize = 1024 * 1024 * 10
+	defaultWarcMaxFileSize = 1024 * 1024 * 1024
+
+	warcVersion    = "WARC/1.1"
+	warcConformsTo = "https://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/"
+	warcDateFormat = "2006-01-02T15:04:05.000000Z"
+)
+
+type WarcWriter struct {
+	proxy.BaseAddon
+	MaxBodySize int   // bodies larger are truncated. Default: 10MB
+	MaxFileSize int64 // start a new file when the file exceeds, 0 - no rotation. Default: 1GB
+
+	path       string
+	gzip       bool
+	file       *os.File
+	size       int64
+	flowCount  int // flows in the current file
+	serial     int
+	warcinfoId string
+	flows      *recordFlows
+	mu         sync.Mutex
+}
+
+func NewWarcWriter(path string) (*WarcWriter, error) {
+	w := &WarcWriter{
+		MaxBodySize: defaultWarcMaxBodySize,
+		MaxFileSize: defaultWarcMaxFileSize,
+		path:        path,
+		gzip:        strings.ToLower(filepath.Ext(path)) == ".gz",
+		flows:       newRecordFlows(),
+	}
+	if err := w.open(path); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *WarcWriter) BeginFlow(f *proxy.Flow) {
+	w.flows.beginWithBodies(f, w.write)
+}
+
+func (w *WarcWriter) Request(f *proxy.Flow) {
+	w.flows.request(f, w.MaxBodySize)
+}
+
+func (w *WarcWriter) Response(f *proxy.Flow) {
+	w.flows.response(f, w.MaxBodySize)
+}
+
+// Filename returns the file currently written
+func (w *WarcWriter) Filename() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Name()
+}
+
+func (w *WarcWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// open creates the file and writes the warcinfo record, call with mu held
+func (w *WarcWriter) open(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+	w.flowCount = 0
+	w.warcinfoId = warcRecordId()
+
+	fields := new(bytes.Buffer)
+	fmt.Fprintf(fields, "software: go-mitmproxy/%v\r\n", proxy.Version)
+	fields.WriteString("format: WARC File Format 1.1\r\n")
+	fmt.Fprintf(fields, "conformsTo: %v\r\n", warcConformsTo)
+	return w.writeRecord([][2]string{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", w.warcinfoId},
+		{"WARC-Date", time.Now().UTC().Format(warcDateFormat)},
+		{"WARC-Filename", filepath.Base(filename)},
+		{"Content-Type", "application/warc-fields"},
+	}, fields.Bytes())
+}
+
+// rotate starts a new file when the current one exceeds MaxFileSize, call with mu held
+func (w *WarcWriter) rotate() error {
+	if w.MaxFileSize <= 0 || w.size < w.MaxFileSize || w.flowCount == 0 {
+		return nil
+	}
+	if err := w.file.Close(); err != nil {
+		log.Warnf("warc close file error: %v", err)
+	}
+	w.serial++
+	return w.open(warcRotatedName(w.path, w.serial))
+}
+
+func (w *WarcWriter) write(f *proxy.Flow, bodies *recordedBodies) {
+	if f.Response == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.rotate(); err != nil {
+		log.Errorf("warc rotate file error: %v", err)
+		return
+	}
+	if err := w.writeFlow(f, bodies); err != nil {
+		log.Errorf("warc write flow error: %v", err)
+		return
+	}
+	w.flowCount++
+}
+
+func (w *WarcWriter) writeFlow(f *proxy.Flow, bodies *recordedBodies) error {
+	targetUri := f.Request.URL.String()
+	responseId := warcRecordId()
+	responseDate := f.ResponseTime
+	if responseDate.IsZero() {
+		responseDate = f.StartTime
+	}
+
+	respHeaders := [][2]string{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseId},
+		{"WARC-Date", responseDate.UTC().Format(warcDateFormat)},
+		{"WARC-Target-URI", targetUri},
+	}
+	if ip := warcIpAddress(f); ip != "" {
+		respHeaders = append(respHeaders, [2]string{"WARC-IP-Address", ip})
+	}
+	respHeaders = append(respHeaders,
+		[2]string{"WARC-Warcinfo-ID", w.warcinfoId},
+		[2]string{"Content-Type", "application/http;msgtype=response"},
+	)
+	respHeaders = appendWarcPayload(respHeaders, f.Response.Body, bodies.resp.truncated)
+	if err := w.writeRecord(respHeaders, warcResponseBlock(f.Response, bodies.resp.size)); err != nil {
+		return err
+	}
+
+	reqHeaders := [][2]string{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", warcRecordId()},
+		{"WARC-Date", f.StartTime.UTC().Format(warcDateFormat)},
+		{"WARC-Target-URI", targetUri},
+		{"WARC-Concurrent-To", responseId},
+		{"WARC-Warcinfo-ID", w.warcinfoId},
+		{"Content-Type", "application/http;msgtype=request"},
+	}
+	reqHeaders = appendWarcPayload(reqHeaders, f.Request.Body, bodies.req.truncated)
+	return w.writeRecord(reqHeaders, warcRequestBlock(f.Request, bodies.req.size))
+}
+
+// appendWarcPayload appends the payload digest, or WARC-Truncated when the payload is cut,
+// as the digest of the partial payload can not identify it
+func appendWarcPayload(headers [][2]string, body []byte, truncated bool) [][2]string {
+	if truncated {
+		return append(headers, [2]string{"WARC-Truncated", "length"})
+	}
+	return append(headers, [2]string{"WARC-Payload-Digest", warcDigest(body)})
+}
+
+// writeRecord writes the record with WARC-Block-Digest and Content-Length appended to headers
+func (w *WarcWriter) writeRecord(headers [][2]string, block []byte) error {
+	record := new(bytes.Buffer)
+	record.WriteString(warcVersion + "\r\n")
+	headers = append(headers,
+		[2]string{"WARC-Block-Digest", warcDigest(block)},
+		[2]string{"Content-Length", strconv.Itoa(len(block))},
+	)
+	for _, h := range headers {
+		record.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	record.WriteString("\r\n")
+	record.Write(block)
+	record.WriteString("\r\n\r\n")
+
+	data := record.Bytes()
+	if w.gzip {
+		buf := new(bytes.Buffer)
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	return err
+}
+
+// warcRequestBlock renders the request as HTTP/1.1 message, size is the real size of the body, which may be truncated
+func warcRequestBlock(req *proxy.Request, size int) []byte {
+	block := new(bytes.Buffer)
+	fmt.Fprintf(block, "%v %v HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	header := req.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("Host") == "" {
+		header.Set("Host", req.URL.Host)
+	}
+	// body is written unchunked
+	header.Del("Transfer-Encoding")
+	if size > 0 && header.Get("Content-Length") == "" {
+		header.Set("Content-Length", strconv.Itoa(size))
+	}
+	header.Write(block)
+	block.WriteString("\r\n")
+	block.Write(req.Body)
+	return block.Bytes()
+}
+
+// warcResponseBlock renders the response as HTTP/1.1 message, body is kept as received, e.g. gzip encoded,
+// size is the real size of the body, which may be truncated
+func warcResponseBlock(resp *proxy.Response, size int) []byte {
+	block := new(bytes.Buffer)
+	fmt.Fprintf(block, "HTTP/1.1 %v %v\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	header := resp.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	// body is written unchunked
+	header.Del("Transfer-Encoding")
+	if size > 0 && header.Get("Content-Length") == "" {
+		header.Set("Content-Length", strconv.Itoa(size))
+	}
+	header.Write(block)
+	block.WriteString("\r\n")
+	block.Write(resp.Body)
+	return block.Bytes()
+}
+
+func warcRecordId() string {
+	return "<urn:uuid:" + uuid.NewString() + ">"
+}
+
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+func warcIpAddress(f *proxy.Flow) string {
+	if f.ConnContext == nil || f.ConnContext.ServerConn == nil || f.ConnContext.ServerConn.Conn == nil {
+		return ""
+	}
+	addr := f.ConnContext.ServerConn.Conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// warcRotatedName returns name of the rotated file, e.g. capture.warc.gz -> capture-00001.warc.gz
+func warcRotatedName(path string, serial int) string {
+	base, ext := path, ""
+	for _, e := range []string{".gz", ".warc"} {
+		if strings.HasSuffix(strings.ToLower(base), e) {
+			ext = base[len(base)-len(e):] + ext
+			base = base[:len(base)-len(e)]
+		}
+	}
+	return fmt.Sprintf("%v-%05d%v", base, serial, ext)
+}
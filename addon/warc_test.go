@@ -0,0 +1,202 @@
+package addon
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+type warcTestRecord struct {
+	header textproto.MIMEHeader
+	block  []byte
+}
+
+func readWarcTestRecords(t *testing.T, r io.Reader) []*warcTestRecord {
+	t.Helper()
+	br := bufio.NewReader(r)
+	records := make([]*warcTestRecord, 0)
+	for {
+		version, err := br.ReadString('\n')
+		if err == io.EOF {
+			return records
+		}
+		if err != nil || version != warcVersion+"\r\n" {
+			t.Fatalf("unexpected record version %q %v", version, err)
+		}
+		header, err := textproto.NewReader(br).ReadMIMEHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := strconv.Atoi(header.Get("Content-Length"))
+		block := make([]byte, n+4)
+		if _, err := io.ReadFull(br, block); err != nil {
+			t.Fatal(err)
+		}
+		if string(block[n:]) != "\r\n\r\n" {
+			t.Fatalf("unexpected record end %q", block[n:])
+		}
+		records = append(records, &warcTestRecord{header: header, block: block[:n]})
+	}
+}
+
+func TestWarcWriter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(append([]byte{0xff, 0xfe}, body...))
+	}))
+	defer server.Close()
+
+	warcFile := filepath.Join(t.TempDir(), "capture.warc")
+	warcWriter, err := NewWarcWriter(warcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer warcWriter.Close()
+
+	p, err := proxy.NewProxy(&proxy.Options{Addr: ":29094"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddAddon(warcWriter)
+	go p.Start()
+	time.Sleep(time.Millisecond * 10) // wait for proxy startup
+
+	proxyUrl, _ := url.Parse("http://127.0.0.1:29094")
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+	resp, err := client.Post(server.URL+"/upload?a=1", "text/plain", bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var records []*warcTestRecord
+	for i := 0; i < 50; i++ {
+		time.Sleep(time.Millisecond * 10) // records are written after the flow done
+		data, err := os.ReadFile(warcFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if records = readWarcTestRecords(t, bytes.NewReader(data)); len(records) == 3 {
+			break
+		}
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected warcinfo, response and request records, but got %v", len(records))
+	}
+
+	warcinfo, response, request := records[0], records[1], records[2]
+	if warcinfo.header.Get("WARC-Type") != "warcinfo" || !bytes.Contains(warcinfo.block, []byte("go-mitmproxy/"+proxy.Version)) {
+		t.Fatalf("unexpected warcinfo record %v %q", warcinfo.header, warcinfo.block)
+	}
+	targetUri := server.URL + "/upload?a=1"
+	if response.header.Get("WARC-Type") != "response" || response.header.Get("WARC-Target-URI") != targetUri || response.header.Get("WARC-IP-Address") != "127.0.0.1" {
+		t.Fatalf("unexpected response record %v", response.header)
+	}
+	if response.header.Get("WARC-Warcinfo-ID") != warcinfo.header.Get("WARC-Record-ID") || response.header.Get("WARC-Block-Digest") != warcDigest(response.block) {
+		t.Fatalf("unexpected response record %v", response.header)
+	}
+	if response.header.Get("WARC-Payload-Digest") != warcDigest([]byte("\xff\xfehello")) || !bytes.HasPrefix(response.block, []byte("HTTP/1.1 200 OK\r\n")) {
+		t.Fatalf("unexpected response record %v %q", response.header, response.block)
+	}
+	if _, err := time.Parse(warcDateFormat, response.header.Get("WARC-Date")); err != nil {
+		t.Fatal(err)
+	}
+	if request.header.Get("WARC-Type") != "request" || request.header.Get("WARC-Concurrent-To") != response.header.Get("WARC-Record-ID") || request.header.Get("WARC-Target-URI") != targetUri {
+		t.Fatalf("unexpected request record %v", request.header)
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(request.block)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if req.Method != "POST" || req.RequestURI != "/upload?a=1" || req.Host != proxyUrl.Host && req.Host != server.Listener.Addr().String() || string(body) != "hello" {
+		t.Fatalf("unexpected request block %q", request.block)
+	}
+}
+
+func TestWarcWriterRotate(t *testing.T) {
+	warcFile := filepath.Join(t.TempDir(), "capture.warc.gz")
+	warcWriter, err := NewWarcWriter(warcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warcWriter.MaxFileSize = 1
+
+	u, _ := url.Parse("http://example.com/")
+	for i := 0; i < 2; i++ {
+		warcWriter.write(&proxy.Flow{
+			Request:   &proxy.Request{Method: "GET", URL: u, Header: make(http.Header)},
+			Response:  &proxy.Response{StatusCode: 404, Header: make(http.Header), Body: []byte("not found")},
+			StartTime: time.Now(),
+		}, &recordedBodies{resp: recordedBody{size: 9}})
+	}
+	rotated := warcWriter.Filename()
+	warcWriter.Close()
+
+	if rotated != filepath.Join(filepath.Dir(warcFile), "capture-00001.warc.gz") {
+		t.Fatalf("unexpected rotated filename %v", rotated)
+	}
+	for _, filename := range []string{warcFile, rotated} {
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records := readWarcTestRecords(t, zr)
+		file.Close()
+		if len(records) != 3 || records[1].header.Get("WARC-Type") != "response" || !bytes.HasPrefix(records[1].block, []byte("HTTP/1.1 404 Not Found\r\n")) {
+			t.Fatalf("unexpected records in %v", filename)
+		}
+	}
+}
+
+func TestWarcWriterTruncated(t *testing.T) {
+	warcFile := filepath.Join(t.TempDir(), "capture.warc")
+	warcWriter, err := NewWarcWriter(warcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://example.com/")
+	warcWriter.write(&proxy.Flow{
+		Request:   &proxy.Request{Method: "POST", URL: u, Header: make(http.Header), Body: []byte("hello")},
+		Response:  &proxy.Response{StatusCode: 200, Header: http.Header{"Transfer-Encoding": {"chunked"}}, Body: []byte("wor")},
+		StartTime: time.Now(),
+	}, &recordedBodies{req: recordedBody{size: 5}, resp: recordedBody{size: 5, truncated: true}})
+	warcWriter.Close()
+
+	data, err := os.ReadFile(warcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := readWarcTestRecords(t, bytes.NewReader(data))
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, but got %v", len(records))
+	}
+	response, request := records[1], records[2]
+	if response.header.Get("WARC-Truncated") != "length" || response.header.Get("WARC-Payload-Digest") != "" {
+		t.Fatalf("unexpected truncated response record %v", response.header)
+	}
+	if !bytes.Contains(response.block, []byte("Content-Length: 5\r\n")) || !bytes.HasSuffix(response.block, []byte("\r\n\r\nwor")) {
+		t.Fatalf("unexpected truncated response block %q", response.block)
+	}
+	if request.header.Get("WARC-Truncated") != "" || request.header.Get("WARC-Payload-Digest") != warcDigest([]byte("hello")) {
+		t.Fatalf("unexpected request record %v", request.header)
+	}
+}
@@ -8,4 +8,8 @@ import (
 type CA interface {
 	GetRootCA() *x509.Certificate
 	GetCert(commonName string) (*tls.Certificate, error)
+
+	// GetCertFor returns certificate for the client hello, hello.ServerName is the target host when client sends no SNI.
+	// upstreamCert is the leaf certificate of upstream server, may be nil when not connected to upstream yet.
+	GetCertFor(hello *tls.ClientHelloInfo, upstreamCert *x509.Certificate) (*tls.Certificate, error)
 }
@@ -0,0 +1,157 @@
+package cert
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/log"
+)
+
+// certificates expire within this duration are treated as expired
+const diskCacheExpiryMargin = time.Hour
+
+// diskCache persist generated leaf certificates under StorePath/certs, survive process restart
+type diskCache struct {
+	dir     string
+	maxSize int
+	mu      sync.Mutex
+}
+
+func newDiskCache(dir string, maxSize int) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir: dir, maxSize: maxSize}, nil
+}
+
+func (c *diskCache) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:16])+".pem")
+}
+
+// get returns nil when not found, expired or not issued by root
+func (c *diskCache) get(key string, root *x509.Certificate) *tls.Certificate {
+	filename := c.filename(key)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("read cert cache %v error: %v", filename, err)
+		}
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(data, data)
+	if err == nil {
+		var leaf *x509.Certificate
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err == nil {
+			if time.Now().Add(diskCacheExpiryMargin).After(leaf.NotAfter) {
+				err = errors.New("expired")
+			} else if leaf.CheckSignatureFrom(root) != nil {
+				err = errors.New("not issued by current ca")
+			}
+		}
+	}
+	if err != nil {
+		log.Debugf("remove cert cache %v: %v", filename, err)
+		os.Remove(filename)
+		return nil
+	}
+
+	// touch for eviction order
+	now := time.Now()
+	os.Chtimes(filename, now, now)
+	return &cert
+}
+
+func (c *diskCache) put(key string, cert *tls.Certificate) {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		log.Warnf("marshal cert cache key error: %v", err)
+		return
+	}
+	buf := new(strings.Builder)
+	for _, der := range cert.Certificate {
+		pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	pem.Encode(buf, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+
+	filename := c.filename(key)
+	if err := os.WriteFile(filename, []byte(buf.String()), 0600); err != nil {
+		log.Warnf("write cert cache %v error: %v", filename, err)
+		return
+	}
+	c.evict()
+}
+
+// evict expired certificates first, then the least recently used ones exceed maxSize
+func (c *diskCache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Warnf("read cert cache dir error: %v", err)
+		return
+	}
+	if len(entries) <= c.maxSize {
+		return
+	}
+
+	type cacheFile struct {
+		path    string
+		modTime time.Time
+	}
+	files := make([]*cacheFile, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), ".pem") {
+			continue
+		}
+		path := filepath.Join(c.dir, entry.Name())
+		if isExpiredCertFile(path) {
+			os.Remove(path)
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, &cacheFile{path: path, modTime: info.ModTime()})
+	}
+
+	if len(files) <= c.maxSize {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files[:len(files)-c.maxSize] {
+		os.Remove(f.path)
+	}
+}
+
+func isExpiredCertFile(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return true
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	return time.Now().Add(diskCacheExpiryMargin).After(leaf.NotAfter)
+}
@@ -0,0 +1,240 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// export formats
+const (
+	FormatPEM    = "pem"   // certificate chain, with private key when exporting the whole ca
+	FormatDER    = "der"   // root certificate only
+	FormatPKCS12 = "p12"   // certificate chain, with private key when exporting the whole ca
+	FormatCert   = "cert"  // root certificate in pem format
+	FormatTrust  = "p12ca" // root certificate only pkcs12 trust store, for importing on Windows/Android
+)
+
+var ExportFormats = []string{FormatPEM, FormatDER, FormatPKCS12, FormatCert, FormatTrust}
+
+// The ca in PKCS12 format, loaded when mitmproxy-ca.pem does not exist.
+func (ca *SelfSignCA) caP12File() string {
+	return filepath.Join(ca.StorePath, "mitmproxy-ca.p12")
+}
+
+// The certificate in PKCS12 format. For use on Windows.
+func (ca *SelfSignCA) caCertP12File() string {
+	return filepath.Join(ca.StorePath, "mitmproxy-ca-cert.p12")
+}
+
+// ImportPKCS12 replace the ca with private key and certificates in PKCS#12 data
+func (ca *SelfSignCA) ImportPKCS12(data []byte, password string) error {
+	key, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported private key type %T", key)
+	}
+	return ca.setChain(signer, sortChain(cert, caCerts))
+}
+
+// ImportPEM replace the ca with private key and certificates in PEM data, password is used to decrypt encrypted private key
+func (ca *SelfSignCA) ImportPEM(data []byte, password string) error {
+	var privateKey crypto.Signer
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch {
+		case block.Type == "CERTIFICATE":
+			x509Cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, x509Cert)
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			if privateKey != nil {
+				continue
+			}
+			key, err := decodePrivateKeyBlock(block, password)
+			if err != nil {
+				return err
+			}
+			privateKey = key
+		}
+	}
+	if privateKey == nil {
+		return errors.New("PRIVATE KEY not found")
+	}
+	if len(certs) == 0 {
+		return errors.New("CERTIFICATE not found")
+	}
+	return ca.setChain(privateKey, certs)
+}
+
+// ImportFile import pem or PKCS#12 file by extension
+func (ca *SelfSignCA) ImportFile(filename string, password string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".p12" || ext == ".pfx" {
+		return ca.ImportPKCS12(data, password)
+	}
+	return ca.ImportPEM(data, password)
+}
+
+// ImportSelfSignCA import ca file into store path, replace the existing ca
+func ImportSelfSignCA(path string, filename string, password string, opts *Options) (*SelfSignCA, error) {
+	storePath, err := getStorePath(path)
+	if err != nil {
+		return nil, err
+	}
+	ca := newSelfSignCA(storePath, opts)
+	if err := ca.ImportFile(filename, password); err != nil {
+		return nil, err
+	}
+	if err := ca.Save(); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+func decodePrivateKeyBlock(block *pem.Block, password string) (crypto.Signer, error) {
+	der := block.Bytes
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		if password == "" {
+			return nil, errors.New("password required for encrypted private key")
+		}
+		key, err := pkcs8.ParsePKCS8PrivateKey(der, []byte(password))
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	case block.Headers["Proc-Type"] == "4,ENCRYPTED":
+		// legacy openssl encrypted pem, insecure by design
+		return nil, errors.New("legacy encrypted PEM private key is not supported, convert it to encrypted PKCS#8: openssl pkcs8 -topk8 -v2 aes-256-cbc -in key.pem -out key.p8.pem")
+	}
+	return parsePrivateKey(der)
+}
+
+// sortChain order certificates from leaf to root, certificates not in the chain are dropped
+func sortChain(leaf *x509.Certificate, certs []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{leaf}
+	for cur := leaf; ; {
+		if cur.CheckSignatureFrom(cur) == nil {
+			break
+		}
+		var next *x509.Certificate
+		for _, c := range certs {
+			if !c.Equal(cur) && cur.CheckSignatureFrom(c) == nil {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		chain = append(chain, next)
+		cur = next
+	}
+	return chain
+}
+
+func (ca *SelfSignCA) chain() []*x509.Certificate {
+	return append(append([]*x509.Certificate{}, ca.Intermediates...), &ca.RootCert)
+}
+
+// Export write the ca in format, password encrypts private key of pem and p12 formats, includeKey exports the private key
+func (ca *SelfSignCA) Export(out io.Writer, format string, password string, includeKey bool) error {
+	switch format {
+	case FormatPEM:
+		if includeKey {
+			if err := ca.encodeKeyPEM(out, password); err != nil {
+				return err
+			}
+		}
+		for _, cert := range ca.chain() {
+			if err := pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCert:
+		return ca.saveCertTo(out)
+	case FormatDER:
+		_, err := out.Write(ca.RootCert.Raw)
+		return err
+	case FormatPKCS12:
+		if !includeKey {
+			return ca.Export(out, FormatTrust, password, false)
+		}
+		var intermediates []*x509.Certificate
+		if len(ca.Intermediates) > 0 {
+			intermediates = ca.chain()[1:]
+		}
+		leaf := ca.issuer()
+		data, err := pkcs12.Modern.Encode(ca.PrivateKey, leaf, intermediates, password)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	case FormatTrust:
+		data, err := pkcs12.Modern.EncodeTrustStore([]*x509.Certificate{&ca.RootCert}, password)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unknown export format %v, should be one of %v", format, ExportFormats)
+	}
+}
+
+// encodeKeyPEM encrypt with PKCS#8 PBES2 when password is not empty
+func (ca *SelfSignCA) encodeKeyPEM(out io.Writer, password string) error {
+	if password == "" {
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(ca.PrivateKey)
+		if err != nil {
+			return err
+		}
+		return pem.Encode(out, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	}
+	keyBytes, err := pkcs8.MarshalPrivateKey(ca.PrivateKey, []byte(password), nil)
+	if err != nil {
+		return err
+	}
+	return pem.Encode(out, &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: keyBytes})
+}
+
+// Save write the ca files to StorePath, private key is encrypted when Options.CaPassword is set
+func (ca *SelfSignCA) Save() error {
+	if ca.StorePath == "" {
+		return errors.New("ca store path is empty")
+	}
+	if err := ca.save(); err != nil {
+		return err
+	}
+	return ca.saveCert()
+}
@@ -0,0 +1,183 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	caApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+
+	for _, format := range []string{FormatPEM, FormatPKCS12} {
+		t.Run(format, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := ca.Export(buf, format, "secret", true); err != nil {
+				t.Fatal(err)
+			}
+			filename := filepath.Join(t.TempDir(), "ca."+format)
+			if err := os.WriteFile(filename, buf.Bytes(), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			dir := t.TempDir()
+			if _, err := ImportSelfSignCA(dir, filename, "wrong", nil); err == nil {
+				t.Fatal("should fail with wrong password")
+			}
+			imported, err := ImportSelfSignCA(dir, filename, "secret", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !imported.RootCert.Equal(&ca.RootCert) {
+				t.Fatal("root certificate should equal")
+			}
+
+			// load from store path again
+			loaded, err := NewSelfSignCA(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !loaded.GetRootCA().Equal(&ca.RootCert) {
+				t.Fatal("root certificate should equal")
+			}
+		})
+	}
+
+	t.Run("memory from pem", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		if err := ca.Export(buf, FormatPEM, "secret", true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewSelfSignCAFromPEM(buf.Bytes(), "", nil); err == nil {
+			t.Fatal("should fail without password")
+		}
+		loaded, err := NewSelfSignCAFromPEM(buf.Bytes(), "secret", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !loaded.GetRootCA().Equal(&ca.RootCert) {
+			t.Fatal("root certificate should equal")
+		}
+	})
+
+	t.Run(FormatDER, func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		if err := ca.Export(buf, FormatDER, "", false); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), ca.RootCert.Raw) {
+			t.Fatal("der should be the raw root certificate")
+		}
+	})
+}
+
+func TestLoadEncryptedStore(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{CaPassword: "secret"}
+	created, err := NewSelfSignCAWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "mitmproxy-ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(data); block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatal("private key should be encrypted")
+	}
+
+	if _, err := NewSelfSignCA(dir); err == nil {
+		t.Fatal("should fail without password")
+	}
+	loaded, err := NewSelfSignCAWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.GetRootCA().Equal(created.GetRootCA()) {
+		t.Fatal("root certificate should equal")
+	}
+}
+
+func TestImportLegacyEncryptedPEM(t *testing.T) {
+	caApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+
+	// bytes are not decrypted, any content is ok
+	block := &pem.Block{
+		Type:    "RSA PRIVATE KEY",
+		Headers: map[string]string{"Proc-Type": "4,ENCRYPTED", "DEK-Info": "AES-256-CBC,00112233445566778899AABBCCDDEEFF"},
+		Bytes:   make([]byte, 64),
+	}
+	data := pem.EncodeToMemory(block)
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.RootCert.Raw})...)
+
+	imported := newSelfSignCA("", nil)
+	err = imported.ImportPEM(data, "secret")
+	if err == nil || !strings.Contains(err.Error(), "PKCS#8") {
+		t.Fatalf("expected error of legacy encrypted PEM, but got %v", err)
+	}
+}
+
+func TestRotateSelfSignCA(t *testing.T) {
+	dir := t.TempDir()
+	if exists, err := HasSelfSignCA(dir); err != nil || exists {
+		t.Fatal("should not have ca")
+	}
+	old, err := NewSelfSignCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := HasSelfSignCA(dir); err != nil || !exists {
+		t.Fatal("should have ca")
+	}
+
+	ca, backupDir, err := RotateSelfSignCA(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.RootCert.Equal(old.GetRootCA()) {
+		t.Fatal("should create new ca")
+	}
+	backup, err := NewSelfSignCA(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !backup.GetRootCA().Equal(old.GetRootCA()) {
+		t.Fatal("old ca should be moved to backup dir")
+	}
+}
+
+func TestIssueCert(t *testing.T) {
+	caApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+	c, err := ca.IssueCert("", []string{"example.com", "*.example.org", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "example.com" {
+		t.Fatalf("expected common name %v, but got %v", "example.com", leaf.Subject.CommonName)
+	}
+	for _, host := range []string{"example.com", "www.example.org", "127.0.0.1"} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
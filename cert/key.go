@@ -0,0 +1,128 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type KeyType string
+
+const (
+	KeyTypeRSA2048   KeyType = "rsa2048"
+	KeyTypeRSA3072   KeyType = "rsa3072"
+	KeyTypeECDSAP256 KeyType = "ecdsa-p256"
+	KeyTypeECDSAP384 KeyType = "ecdsa-p384"
+	KeyTypeEd25519   KeyType = "ed25519"
+)
+
+var KeyTypes = []KeyType{KeyTypeRSA2048, KeyTypeRSA3072, KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519}
+
+// ParseKeyType empty string returns empty KeyType, which means the default of the option
+func ParseKeyType(s string) (KeyType, error) {
+	if s == "" {
+		return "", nil
+	}
+	for _, keyType := range KeyTypes {
+		if string(keyType) == s {
+			return keyType, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key type %v, should be one of %v", s, KeyTypes)
+}
+
+func GenerateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyTypeECDSAP256, "":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unknown key type %v", keyType)
+	}
+}
+
+// parsePrivateKey supports PKCS#8, PKCS#1 rsa and SEC 1 ec private key
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	// fix #14
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("failed to parse private key")
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	if k, ok := a.(interface{ Equal(crypto.PublicKey) bool }); ok {
+		return k.Equal(b)
+	}
+	return false
+}
+
+// key usage of leaf certificate depends on the public key algorithm
+func leafKeyUsage(key crypto.Signer) x509.KeyUsage {
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+// keyPool generates leaf keys lazily and reuses them in turn
+type keyPool struct {
+	keyType KeyType
+	size    int
+	keys    []crypto.Signer
+	next    int
+	mu      sync.Mutex
+}
+
+func newKeyPool(keyType KeyType, size int) *keyPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &keyPool{
+		keyType: keyType,
+		size:    size,
+		keys:    make([]crypto.Signer, 0, size),
+	}
+}
+
+func (p *keyPool) get() (crypto.Signer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.keys) < p.size {
+		key, err := GenerateKey(p.keyType)
+		if err != nil {
+			return nil, err
+		}
+		p.keys = append(p.keys, key)
+		return key, nil
+	}
+
+	key := p.keys[p.next]
+	p.next = (p.next + 1) % p.size
+	return key, nil
+}
@@ -0,0 +1,55 @@
+package cert
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HasSelfSignCA whether ca files exist in store path
+func HasSelfSignCA(path string) (bool, error) {
+	storePath, err := resolveStorePath(path)
+	if err != nil {
+		return false, err
+	}
+	ca := &SelfSignCA{StorePath: storePath}
+	for _, file := range []string{ca.caFile(), ca.caP12File()} {
+		if _, err := os.Stat(file); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// RotateSelfSignCA move current ca files and cached leaf certificates to a backup directory in store path, then create a new ca
+func RotateSelfSignCA(path string, opts *Options) (*SelfSignCA, string, error) {
+	storePath, err := getStorePath(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ca := newSelfSignCA(storePath, opts)
+	backupDir := filepath.Join(storePath, "backup-"+time.Now().Format("20060102150405"))
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return nil, "", err
+	}
+	files := []string{ca.caFile(), ca.caP12File(), ca.caCertFile(), ca.caCertCerFile(), ca.caCertP12File(), filepath.Join(storePath, "certs")}
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, "", err
+		}
+		if err := os.Rename(file, filepath.Join(backupDir, filepath.Base(file))); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := ca.create(); err != nil {
+		return nil, "", err
+	}
+	return ca, backupDir, nil
+}
@@ -0,0 +1,98 @@
+package cert
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	LeafKeyType     KeyType // 服务器证书的密钥类型，默认 ecdsa-p256
+	LeafKeyPoolSize int     // 服务器证书密钥池大小，池中的密钥轮流复用，默认 4
+
+	// 服务器证书磁盘缓存(StorePath/certs)数量上限，0 表示不缓存到磁盘，仅对 NewSelfSignCAWithOptions 有效
+	LeafCertCacheSize int
+
+	// 签发 *.example.com 形式的通配符证书，基础域名由公共后缀列表计算，同一域名下的子域名共用一张证书
+	WildcardLeafCerts bool
+
+	// CA 私钥密码，用于加载加密的 PEM 或 PKCS#12 CA 文件，保存时也用于加密私钥
+	CaPassword string
+
+	// 以下选项仅在新建 CA 时生效
+	CaSubject           pkix.Name     // CA 证书主题，默认 CN=mitmproxy,O=mitmproxy
+	CaValidity          time.Duration // CA 证书有效期，默认 3 年
+	CaKeyType           KeyType       // CA 的密钥类型，默认 rsa2048
+	PermittedDNSDomains []string      // X.509 名称约束，设置后 CA 只能为这些域名及其子域名签发证书
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		LeafKeyType:     KeyTypeECDSAP256,
+		LeafKeyPoolSize: 4,
+		CaSubject: pkix.Name{
+			CommonName:   "mitmproxy",
+			Organization: []string{"mitmproxy"},
+		},
+		CaValidity: time.Hour * 24 * 365 * 3,
+		CaKeyType:  KeyTypeRSA2048,
+	}
+}
+
+func (opts *Options) withDefaults() *Options {
+	def := DefaultOptions()
+	if opts == nil {
+		return def
+	}
+	o := *opts
+	if o.LeafKeyType == "" {
+		o.LeafKeyType = def.LeafKeyType
+	}
+	if o.LeafKeyPoolSize <= 0 {
+		o.LeafKeyPoolSize = def.LeafKeyPoolSize
+	}
+	if o.CaSubject.CommonName == "" && len(o.CaSubject.Organization) == 0 {
+		o.CaSubject = def.CaSubject
+	}
+	if o.CaValidity <= 0 {
+		o.CaValidity = def.CaValidity
+	}
+	if o.CaKeyType == "" {
+		o.CaKeyType = def.CaKeyType
+	}
+	return &o
+}
+
+// ParseSubject parse distinguished name like "CN=mitmproxy,O=mitmproxy,C=CN", supported attributes: CN, O, OU, C, ST, L
+func ParseSubject(s string) (pkix.Name, error) {
+	var name pkix.Name
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return name, fmt.Errorf("invalid subject attribute %v", part)
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.ToUpper(strings.TrimSpace(kv[0])) {
+		case "CN":
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		default:
+			return name, fmt.Errorf("unsupported subject attribute %v", kv[0])
+		}
+	}
+	return name, nil
+}
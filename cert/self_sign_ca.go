@@ -1,11 +1,13 @@
 package cert
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -31,47 +33,42 @@ import (
 var errCaNotFound = errors.New("ca not found")
 
 type SelfSignCA struct {
-	rsa.PrivateKey
-	RootCert  x509.Certificate
-	StorePath string
+	PrivateKey    crypto.Signer       // key of the issuing certificate, the first intermediate when Intermediates not empty
+	RootCert      x509.Certificate    // the certificate clients trust
+	Intermediates []*x509.Certificate // issuing intermediate first, sent to clients after the leaf certificate
+	StorePath     string
+	Opts          *Options
 
-	cache *lru.Cache
-	group *singleflight.Group
+	cache     *lru.Cache
+	group     *singleflight.Group
+	keyPool   *keyPool
+	diskCache *diskCache // nil when disabled
 
 	cacheMu sync.Mutex
 }
 
-func createCert() (*rsa.PrivateKey, *x509.Certificate, error) {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+func createCert(opts *Options) (crypto.Signer, *x509.Certificate, error) {
+	key, err := GenerateKey(opts.CaKeyType)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	template := &x509.Certificate{
-		SerialNumber: big.NewInt(time.Now().UnixNano() / 100000),
-		Subject: pkix.Name{
-			CommonName:   "mitmproxy",
-			Organization: []string{"mitmproxy"},
-		},
+		SerialNumber:          big.NewInt(time.Now().UnixNano() / 100000),
+		Subject:               opts.CaSubject,
 		NotBefore:             time.Now().Add(-time.Hour * 48),
-		NotAfter:              time.Now().Add(time.Hour * 24 * 365 * 3),
+		NotAfter:              time.Now().Add(opts.CaValidity),
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		SignatureAlgorithm:    x509.SHA256WithRSA,
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
-		ExtKeyUsage: []x509.ExtKeyUsage{
-			x509.ExtKeyUsageServerAuth,
-			x509.ExtKeyUsageClientAuth,
-			x509.ExtKeyUsageEmailProtection,
-			x509.ExtKeyUsageTimeStamping,
-			x509.ExtKeyUsageCodeSigning,
-			x509.ExtKeyUsageMicrosoftCommercialCodeSigning,
-			x509.ExtKeyUsageMicrosoftServerGatedCrypto,
-			x509.ExtKeyUsageNetscapeServerGatedCrypto,
-		},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if len(opts.PermittedDNSDomains) > 0 {
+		template.PermittedDNSDomainsCritical = true
+		template.PermittedDNSDomains = opts.PermittedDNSDomains
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -85,30 +82,39 @@ func createCert() (*rsa.PrivateKey, *x509.Certificate, error) {
 
 // NewSelfSignCAMemory Create new ca only live in memory, will change when process restart
 func NewSelfSignCAMemory() (CA, error) {
-	key, cert, err := createCert()
+	return NewSelfSignCAMemoryWithOptions(nil)
+}
+
+// NewSelfSignCAMemoryWithOptions same as NewSelfSignCAMemory, nil opts means DefaultOptions
+func NewSelfSignCAMemoryWithOptions(opts *Options) (CA, error) {
+	ca := newSelfSignCA("", opts)
+	key, cert, err := createCert(ca.Opts)
 	if err != nil {
 		return nil, err
 	}
-	return &SelfSignCA{
-		PrivateKey: *key,
-		RootCert:   *cert,
-		StorePath:  "",
-		cache:      lru.New(100),
-		group:      new(singleflight.Group),
-	}, nil
+	ca.PrivateKey = key
+	ca.RootCert = *cert
+	return ca, nil
 }
 
 // NewSelfSignCA Load ca from store path or create new ca then store
 func NewSelfSignCA(path string) (CA, error) {
+	return NewSelfSignCAWithOptions(path, nil)
+}
+
+// NewSelfSignCAWithOptions same as NewSelfSignCA, nil opts means DefaultOptions
+func NewSelfSignCAWithOptions(path string, opts *Options) (CA, error) {
 	storePath, err := getStorePath(path)
 	if err != nil {
 		return nil, err
 	}
 
-	ca := &SelfSignCA{
-		StorePath: storePath,
-		cache:     lru.New(100),
-		group:     new(singleflight.Group),
+	ca := newSelfSignCA(storePath, opts)
+	if ca.Opts.LeafCertCacheSize > 0 {
+		ca.diskCache, err = newDiskCache(filepath.Join(storePath, "certs"), ca.Opts.LeafCertCacheSize)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if err := ca.load(); err != nil {
@@ -127,7 +133,42 @@ func NewSelfSignCA(path string) (CA, error) {
 	return ca, nil
 }
 
-func getStorePath(path string) (string, error) {
+// NewSelfSignCAFromChain ca only live in memory, chain is ordered from the certificate of key to the root,
+// e.g. an intermediate issued by corporate PKI and the corporate root
+func NewSelfSignCAFromChain(key crypto.Signer, chain []*x509.Certificate, opts *Options) (CA, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("empty certificate chain")
+	}
+	ca := newSelfSignCA("", opts)
+	if err := ca.setChain(key, chain); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// NewSelfSignCAFromPEM ca only live in memory, loaded from PEM data of the private key and certificates,
+// e.g. content of an environment variable, password is used to decrypt encrypted PKCS#8 private key
+func NewSelfSignCAFromPEM(data []byte, password string, opts *Options) (CA, error) {
+	ca := newSelfSignCA("", opts)
+	if err := ca.ImportPEM(data, password); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+func newSelfSignCA(storePath string, opts *Options) *SelfSignCA {
+	opts = opts.withDefaults()
+	return &SelfSignCA{
+		StorePath: storePath,
+		Opts:      opts,
+		cache:     lru.New(100),
+		group:     new(singleflight.Group),
+		keyPool:   newKeyPool(opts.LeafKeyType, opts.LeafKeyPoolSize),
+	}
+}
+
+// resolveStorePath returns the absolute store path without creating it, ~/.mitmproxy when path is empty
+func resolveStorePath(path string) (string, error) {
 	if path == "" {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
@@ -143,6 +184,14 @@ func getStorePath(path string) (string, error) {
 		}
 		path = filepath.Join(dir, path)
 	}
+	return path, nil
+}
+
+func getStorePath(path string) (string, error) {
+	path, err := resolveStorePath(path)
+	if err != nil {
+		return "", err
+	}
 
 	stat, err := os.Stat(path)
 	if err != nil {
@@ -164,6 +213,7 @@ func getStorePath(path string) (string, error) {
 }
 
 // The certificate and the private key in PEM format.
+// Intermediate certificates may follow, e.g. key + intermediate + root, then leaf certificates are signed by the intermediate.
 func (ca *SelfSignCA) caFile() string {
 	return filepath.Join(ca.StorePath, "mitmproxy-ca.pem")
 }
@@ -182,7 +232,7 @@ func (ca *SelfSignCA) load() error {
 	stat, err := os.Stat(caFile)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return errCaNotFound
+			return ca.loadP12()
 		}
 		return err
 	}
@@ -196,52 +246,59 @@ func (ca *SelfSignCA) load() error {
 		return err
 	}
 
-	keyDERBlock, data := pem.Decode(data)
-	if keyDERBlock == nil {
-		return fmt.Errorf("%v 中不存在 PRIVATE KEY", caFile)
-	}
-	certDERBlock, _ := pem.Decode(data)
-	if certDERBlock == nil {
-		return fmt.Errorf("%v 中不存在 CERTIFICATE", caFile)
+	if err := ca.ImportPEM(data, ca.Opts.CaPassword); err != nil {
+		return fmt.Errorf("%v: %w", caFile, err)
 	}
+	return nil
+}
 
-	var privateKey *rsa.PrivateKey
-	key, err := x509.ParsePKCS8PrivateKey(keyDERBlock.Bytes)
+func (ca *SelfSignCA) loadP12() error {
+	p12File := ca.caP12File()
+	data, err := ioutil.ReadFile(p12File)
 	if err != nil {
-		// fix #14
-		if strings.Contains(err.Error(), "use ParsePKCS1PrivateKey instead") {
-			privateKey, err = x509.ParsePKCS1PrivateKey(keyDERBlock.Bytes)
-			if err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
-	} else {
-		if v, ok := key.(*rsa.PrivateKey); ok {
-			privateKey = v
-		} else {
-			return errors.New("found unknown rsa private key type in PKCS#8 wrapping")
+		if os.IsNotExist(err) {
+			return errCaNotFound
 		}
+		return err
+	}
+	if err := ca.ImportPKCS12(data, ca.Opts.CaPassword); err != nil {
+		return fmt.Errorf("%v: %w", p12File, err)
 	}
-	ca.PrivateKey = *privateKey
+	return nil
+}
 
-	x509Cert, err := x509.ParseCertificate(certDERBlock.Bytes)
-	if err != nil {
-		return err
+// setChain certs[0] is the certificate of key, every certificate should be issued by the next one
+func (ca *SelfSignCA) setChain(key crypto.Signer, certs []*x509.Certificate) error {
+	if !publicKeyEqual(key.Public(), certs[0].PublicKey) {
+		return errors.New("private key does not match the issuing certificate")
+	}
+	for i := 0; i < len(certs)-1; i++ {
+		if err := certs[i].CheckSignatureFrom(certs[i+1]); err != nil {
+			return fmt.Errorf("certificate %v is not issued by %v: %w", certs[i].Subject, certs[i+1].Subject, err)
+		}
 	}
-	ca.RootCert = *x509Cert
 
+	ca.PrivateKey = key
+	ca.RootCert = *certs[len(certs)-1]
+	ca.Intermediates = certs[:len(certs)-1]
 	return nil
 }
 
+// issuer the certificate signs leaf certificates
+func (ca *SelfSignCA) issuer() *x509.Certificate {
+	if len(ca.Intermediates) > 0 {
+		return ca.Intermediates[0]
+	}
+	return &ca.RootCert
+}
+
 func (ca *SelfSignCA) create() error {
-	key, cert, err := createCert()
+	key, cert, err := createCert(ca.Opts)
 	if err != nil {
 		return err
 	}
 
-	ca.PrivateKey = *key
+	ca.PrivateKey = key
 	ca.RootCert = *cert
 
 	if err := ca.save(); err != nil {
@@ -251,15 +308,15 @@ func (ca *SelfSignCA) create() error {
 }
 
 func (ca *SelfSignCA) saveTo(out io.Writer) error {
-	keyBytes, err := x509.MarshalPKCS8PrivateKey(&ca.PrivateKey)
-	if err != nil {
-		return err
-	}
-	err = pem.Encode(out, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
-	if err != nil {
+	if err := ca.encodeKeyPEM(out, ca.Opts.CaPassword); err != nil {
 		return err
 	}
 
+	for _, cert := range ca.Intermediates {
+		if err := pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return err
+		}
+	}
 	return pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: ca.RootCert.Raw})
 }
 
@@ -268,7 +325,7 @@ func (ca *SelfSignCA) saveCertTo(out io.Writer) error {
 }
 
 func (ca *SelfSignCA) save() error {
-	file, err := os.Create(ca.caFile())
+	file, err := os.OpenFile(ca.caFile(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -296,7 +353,13 @@ func (ca *SelfSignCA) saveCert() error {
 	if err != nil {
 		return err
 	}
-	return err
+
+	p12File, err := os.Create(ca.caCertP12File())
+	if err != nil {
+		return err
+	}
+	defer p12File.Close()
+	return ca.Export(p12File, FormatTrust, "", false)
 }
 
 func (ca *SelfSignCA) GetRootCA() *x509.Certificate {
@@ -304,19 +367,46 @@ func (ca *SelfSignCA) GetRootCA() *x509.Certificate {
 }
 
 func (ca *SelfSignCA) GetCert(commonName string) (*tls.Certificate, error) {
+	commonName = ca.leafName(commonName)
+	return ca.getCert(commonName, commonName, nil)
+}
+
+func (ca *SelfSignCA) GetCertFor(hello *tls.ClientHelloInfo, upstreamCert *x509.Certificate) (*tls.Certificate, error) {
+	commonName := ca.leafName(hello.ServerName)
+	if upstreamCert == nil {
+		return ca.getCert(commonName, commonName, nil)
+	}
+	// upstream certificate may change, the fingerprint is part of the cache key
+	sum := sha256.Sum256(upstreamCert.Raw)
+	key := commonName + "|" + hex.EncodeToString(sum[:8])
+	return ca.getCert(key, commonName, upstreamCert)
+}
+
+func (ca *SelfSignCA) getCert(key string, commonName string, upstreamCert *x509.Certificate) (*tls.Certificate, error) {
 	ca.cacheMu.Lock()
-	if val, ok := ca.cache.Get(commonName); ok {
+	if val, ok := ca.cache.Get(key); ok {
 		ca.cacheMu.Unlock()
 		log.Debugf("ca GetCert: %v", commonName)
 		return val.(*tls.Certificate), nil
 	}
 	ca.cacheMu.Unlock()
 
-	val, err := ca.group.Do(commonName, func() (interface{}, error) {
-		cert, err := ca.DummyCert(commonName)
+	val, err := ca.group.Do(key, func() (interface{}, error) {
+		if ca.diskCache != nil {
+			if cert := ca.diskCache.get(key, ca.issuer()); cert != nil {
+				ca.cacheMu.Lock()
+				ca.cache.Add(key, cert)
+				ca.cacheMu.Unlock()
+				return cert, nil
+			}
+		}
+		cert, err := ca.DummyCertFor(commonName, upstreamCert)
 		if err == nil {
+			if ca.diskCache != nil {
+				ca.diskCache.put(key, cert)
+			}
 			ca.cacheMu.Lock()
-			ca.cache.Add(commonName, cert)
+			ca.cache.Add(key, cert)
 			ca.cacheMu.Unlock()
 		}
 		return cert, err
@@ -329,37 +419,158 @@ func (ca *SelfSignCA) GetCert(commonName string) (*tls.Certificate, error) {
 	return val.(*tls.Certificate), nil
 }
 
-// TODO: 是否应该支持多个 SubjectAltName
 func (ca *SelfSignCA) DummyCert(commonName string) (*tls.Certificate, error) {
+	return ca.DummyCertFor(commonName, nil)
+}
+
+// DummyCertFor generate certificate for commonName, mirror SubjectAltNames, organization and validity of upstreamCert if not nil
+func (ca *SelfSignCA) DummyCertFor(commonName string, upstreamCert *x509.Certificate) (*tls.Certificate, error) {
 	log.Debugf("ca DummyCert: %v", commonName)
-	template := &x509.Certificate{
+	template := leafTemplate(commonName)
+
+	if upstreamCert != nil {
+		if upstreamCert.Subject.CommonName != "" {
+			template.Subject.CommonName = upstreamCert.Subject.CommonName
+		}
+		if len(upstreamCert.Subject.Organization) > 0 {
+			template.Subject.Organization = upstreamCert.Subject.Organization
+		}
+		template.DNSNames = append(template.DNSNames, upstreamCert.DNSNames...)
+		template.IPAddresses = append(template.IPAddresses, upstreamCert.IPAddresses...)
+		// expire no later than upstream, but never issue an already expired certificate
+		if upstreamCert.NotAfter.Before(template.NotAfter) && upstreamCert.NotAfter.After(time.Now()) {
+			template.NotAfter = upstreamCert.NotAfter
+		}
+	}
+
+	names := []string{commonName}
+	if parent, ok := wildcardParent(commonName); ok {
+		names = append(names, parent)
+	}
+	return ca.signLeaf(template, names)
+}
+
+// IssueCert generate certificate for commonName and extra SubjectAltNames, each san is a dns name or ip
+func (ca *SelfSignCA) IssueCert(commonName string, sans []string) (*tls.Certificate, error) {
+	if commonName == "" && len(sans) > 0 {
+		commonName = sans[0]
+	}
+	return ca.signLeaf(leafTemplate(commonName), append([]string{commonName}, sans...))
+}
+
+func leafTemplate(commonName string) *x509.Certificate {
+	return &x509.Certificate{
 		SerialNumber: big.NewInt(time.Now().UnixNano() / 100000),
 		Subject: pkix.Name{
 			CommonName:   commonName,
 			Organization: []string{"mitmproxy"},
 		},
-		NotBefore:          time.Now().Add(-time.Hour * 48),
-		NotAfter:           time.Now().Add(time.Hour * 24 * 365),
-		SignatureAlgorithm: x509.SHA256WithRSA,
-		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		NotBefore:   time.Now().Add(-time.Hour * 48),
+		NotAfter:    time.Now().Add(time.Hour * 24 * 365),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 	}
+}
 
-	ip := net.ParseIP(commonName)
-	if ip != nil {
-		template.IPAddresses = []net.IP{ip}
-	} else {
-		template.DNSNames = []string{commonName}
+// signLeaf names are required to be covered by the certificate
+func (ca *SelfSignCA) signLeaf(template *x509.Certificate, names []string) (*tls.Certificate, error) {
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			if !containsIP(template.IPAddresses, ip) {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			}
+		} else if name != "" {
+			if !ca.dnsNamePermitted(name) {
+				return nil, fmt.Errorf("%v is not permitted by the name constraints of ca", name)
+			}
+			if !containsString(template.DNSNames, name) {
+				template.DNSNames = append(template.DNSNames, name)
+			}
+		}
+	}
+
+	// drop mirrored names the ca can not sign, or clients will reject the whole certificate
+	dnsNames := make([]string, 0, len(template.DNSNames))
+	for _, name := range template.DNSNames {
+		if ca.dnsNamePermitted(name) {
+			dnsNames = append(dnsNames, name)
+		}
 	}
+	template.DNSNames = dnsNames
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, &ca.RootCert, &ca.PrivateKey.PublicKey, &ca.PrivateKey)
+	issuer := ca.issuer()
+	if issuer.NotAfter.Before(template.NotAfter) {
+		template.NotAfter = issuer.NotAfter
+	}
+
+	key, err := ca.keyPool.get()
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = leafKeyUsage(key)
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), ca.PrivateKey)
 	if err != nil {
 		return nil, err
 	}
 
+	// full chain without root
+	chain := [][]byte{certBytes}
+	for _, intermediate := range ca.Intermediates {
+		chain = append(chain, intermediate.Raw)
+	}
 	cert := &tls.Certificate{
-		Certificate: [][]byte{certBytes},
-		PrivateKey:  &ca.PrivateKey,
+		Certificate: chain,
+		PrivateKey:  key,
 	}
 
 	return cert, nil
 }
+
+// dnsNamePermitted check PermittedDNSDomains of every ca certificate in the chain
+func (ca *SelfSignCA) dnsNamePermitted(name string) bool {
+	certs := append([]*x509.Certificate{&ca.RootCert}, ca.Intermediates...)
+	for _, cert := range certs {
+		if len(cert.PermittedDNSDomains) == 0 {
+			continue
+		}
+		permitted := false
+		for _, domain := range cert.PermittedDNSDomains {
+			if matchDomainConstraint(name, domain) {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return false
+		}
+	}
+	return true
+}
+
+// matchDomainConstraint "example.com" matches itself and subdomains, ".example.com" matches subdomains only
+func matchDomainConstraint(name, constraint string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(name, constraint)
+	}
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, v := range ips {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(items []string, item string) bool {
+	for _, v := range items {
+		if strings.EqualFold(v, item) {
+			return true
+		}
+	}
+	return false
+}
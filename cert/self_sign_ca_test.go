@@ -2,9 +2,20 @@ package cert
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGetStorePath(t *testing.T) {
@@ -41,3 +52,308 @@ func TestNewCA(t *testing.T) {
 		t.Fatal("pem content should equal")
 	}
 }
+
+func TestDummyCertKeyType(t *testing.T) {
+	for _, keyType := range KeyTypes {
+		t.Run(string(keyType), func(t *testing.T) {
+			caApi, err := NewSelfSignCAMemoryWithOptions(&Options{LeafKeyType: keyType, LeafKeyPoolSize: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ca := caApi.(*SelfSignCA)
+
+			cert1, err := ca.GetCert("example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := x509.ParseCertificate(cert1.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := leaf.CheckSignatureFrom(&ca.RootCert); err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(leaf.RawSubjectPublicKeyInfo, ca.RootCert.RawSubjectPublicKeyInfo) {
+				t.Fatal("leaf key should not be the ca key")
+			}
+
+			// pool size 1, the key should be reused
+			cert2, err := ca.GetCert("example.org")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cert1.PrivateKey.(interface{ Equal(crypto.PrivateKey) bool }).Equal(cert2.PrivateKey) {
+				t.Fatal("leaf key should be reused")
+			}
+		})
+	}
+}
+
+func TestGetCertFor(t *testing.T) {
+	caApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+
+	upstreamCert := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "example.com", Organization: []string{"Example Inc"}},
+		DNSNames:    []string{"example.com", "www.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+		NotAfter:    time.Now().Add(time.Hour * 24 * 30),
+	}
+
+	t.Run("mirror upstream", func(t *testing.T) {
+		c, err := ca.GetCertFor(&tls.ClientHelloInfo{ServerName: "192.168.1.1"}, upstreamCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.Subject.Organization[0] != "Example Inc" {
+			t.Fatalf("expected organization %v, but got %v", "Example Inc", leaf.Subject.Organization)
+		}
+		for _, host := range []string{"example.com", "www.example.com", "10.0.0.1", "192.168.1.1"} {
+			if err := leaf.VerifyHostname(host); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !leaf.NotAfter.Equal(upstreamCert.NotAfter.Truncate(time.Second)) {
+			t.Fatalf("expected not after %v, but got %v", upstreamCert.NotAfter, leaf.NotAfter)
+		}
+	})
+
+	t.Run("without upstream", func(t *testing.T) {
+		c, err := ca.GetCertFor(&tls.ClientHelloInfo{ServerName: "example.org"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := leaf.VerifyHostname("example.org"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestLeafCertDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{LeafCertCacheSize: 2}
+	caApi, err := NewSelfSignCAWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last *tls.Certificate
+	for _, name := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		last, err = caApi.GetCert(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 10) // distinct modify time
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "certs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected %d cached certs, but got %d", 2, len(entries))
+	}
+
+	// load again, should hit the disk cache
+	caApi, err = NewSelfSignCAWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := caApi.GetCert("c.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.Certificate[0], last.Certificate[0]) {
+		t.Fatal("should load cert from disk cache")
+	}
+}
+
+func TestIntermediateCA(t *testing.T) {
+	rootKey, err := GenerateKey(KeyTypeECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "corporate root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootBytes, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, rootKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := x509.ParseCertificate(rootBytes)
+
+	interKey, err := GenerateKey(KeyTypeECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "mitm intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	interBytes, err := x509.CreateCertificate(rand.Reader, interTemplate, root, interKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inter, _ := x509.ParseCertificate(interBytes)
+
+	// store layout: key + intermediate + root
+	dir := t.TempDir()
+	keyBytes, _ := x509.MarshalPKCS8PrivateKey(interKey)
+	buf := new(bytes.Buffer)
+	pem.Encode(buf, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: interBytes})
+	pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: rootBytes})
+	if err := os.WriteFile(filepath.Join(dir, "mitmproxy-ca.pem"), buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	caApi, err := NewSelfSignCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !caApi.GetRootCA().Equal(root) {
+		t.Fatal("root should be the last certificate")
+	}
+
+	c, err := caApi.GetCert("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Certificate) != 2 || !bytes.Equal(c.Certificate[1], inter.Raw) {
+		t.Fatal("should send leaf and intermediate")
+	}
+	leaf, _ := x509.ParseCertificate(c.Certificate[0])
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(inter)
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Fatal(err)
+	}
+
+	// key not matching the issuing certificate
+	if _, err := NewSelfSignCAFromChain(rootKey, []*x509.Certificate{inter, root}, nil); err == nil {
+		t.Fatal("should return mismatch error")
+	}
+}
+
+func TestCaOptions(t *testing.T) {
+	subject, err := ParseSubject("CN=test ca, O=test org, C=CN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caApi, err := NewSelfSignCAMemoryWithOptions(&Options{
+		CaSubject:           subject,
+		CaValidity:          time.Hour * 24 * 30,
+		CaKeyType:           KeyTypeECDSAP384,
+		PermittedDNSDomains: []string{"example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := caApi.GetRootCA()
+	if root.Subject.CommonName != "test ca" || root.Subject.Organization[0] != "test org" || root.Subject.Country[0] != "CN" {
+		t.Fatalf("unexpected subject %v", root.Subject)
+	}
+	if root.NotAfter.After(time.Now().Add(time.Hour * 24 * 31)) {
+		t.Fatalf("unexpected not after %v", root.NotAfter)
+	}
+	if root.PublicKeyAlgorithm != x509.ECDSA {
+		t.Fatalf("expected %v, but got %v", x509.ECDSA, root.PublicKeyAlgorithm)
+	}
+
+	c, err := caApi.GetCert("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(c.Certificate[0])
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "www.example.com", Roots: roots}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := caApi.GetCert("example.org"); err == nil {
+		t.Fatal("should not sign domain outside name constraints")
+	}
+}
+
+func TestWildcardLeafCerts(t *testing.T) {
+	for name, expected := range map[string]string{
+		"example.com":          "*.example.com",
+		"www.example.com":      "*.example.com",
+		"a.b.example.co.uk":    "*.b.example.co.uk",
+		"static.example.co.uk": "*.example.co.uk",
+		"co.uk":                "",
+		"localhost":            "",
+		"10.0.0.1":             "",
+	} {
+		wildcard, _ := wildcardName(name)
+		if wildcard != expected {
+			t.Fatalf("%v: expected %q, but got %q", name, expected, wildcard)
+		}
+	}
+
+	caApi, err := NewSelfSignCAMemoryWithOptions(&Options{WildcardLeafCerts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := caApi.(*SelfSignCA)
+
+	c1, err := ca.GetCert("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := ca.GetCertFor(&tls.ClientHelloInfo{ServerName: "cdn.example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Fatal("subdomains should share the wildcard certificate")
+	}
+	leaf, err := x509.ParseCertificate(c1.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"example.com", "www.example.com", "cdn.example.com"} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ip and single label names are issued as is
+	for _, host := range []string{"10.0.0.1", "localhost"} {
+		c, err := ca.GetCert(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.Subject.CommonName != host {
+			t.Fatalf("expected common name %v, but got %v", host, leaf.Subject.CommonName)
+		}
+	}
+}
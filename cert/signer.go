@@ -0,0 +1,215 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signer signs with the ca private key kept outside of the process, e.g. in a PKCS#11 token or a local signing service
+type Signer interface {
+	crypto.Signer
+
+	// Chain returns certificates from the certificate of the key to the root
+	Chain() ([]*x509.Certificate, error)
+}
+
+// NewSelfSignCAFromSigner ca only live in memory, leaf certificates are signed by signer
+func NewSelfSignCAFromSigner(signer Signer, opts *Options) (CA, error) {
+	chain, err := signer.Chain()
+	if err != nil {
+		return nil, err
+	}
+	return NewSelfSignCAFromChain(signer, chain, opts)
+}
+
+// signer http api, served by NewSignerHandler:
+//   GET  /chain  PEM certificates, from the certificate of the key to the root
+//   POST /sign   request signRequest, response signResponse
+// basic auth password is checked when configured, HTTPSigner sends it from the userinfo of url
+
+type signRequest struct {
+	Digest        []byte `json:"digest"`
+	Hash          string `json:"hash"`                      // e.g. SHA-256, empty means no hash(ed25519)
+	PSSSaltLength int    `json:"pss_salt_length,omitempty"` // only for rsa pss
+	PSS           bool   `json:"pss,omitempty"`
+}
+
+type signResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+var signerHashes = []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+func hashName(h crypto.Hash) string {
+	if h == 0 {
+		return ""
+	}
+	return h.String()
+}
+
+func parseHashName(name string) (crypto.Hash, error) {
+	if name == "" {
+		return 0, nil
+	}
+	for _, h := range signerHashes {
+		if h.String() == name {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported hash %v", name)
+}
+
+// HTTPSigner Signer backed by the signer http api, e.g. http://:password@127.0.0.1:9443
+type HTTPSigner struct {
+	URL    string
+	Client *http.Client
+
+	chain []*x509.Certificate
+	mu    sync.Mutex // guard chain, Chain and Sign are called by concurrent handshakes
+}
+
+func NewHTTPSigner(url string) *HTTPSigner {
+	return &HTTPSigner{
+		URL:    strings.TrimSuffix(url, "/"),
+		Client: &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (s *HTTPSigner) Chain() ([]*x509.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.chain != nil {
+		return s.chain, nil
+	}
+	resp, err := s.Client.Get(s.URL + "/chain")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("signer chain: %v %s", resp.Status, bytes.TrimSpace(data))
+	}
+	chain, err := parseCertsPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("signer chain: %w", err)
+	}
+	s.chain = chain
+	return chain, nil
+}
+
+// Public returns nil before Chain called successfully
+func (s *HTTPSigner) Public() crypto.PublicKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.chain) == 0 {
+		return nil
+	}
+	return s.chain[0].PublicKey
+}
+
+func (s *HTTPSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := &signRequest{
+		Digest: digest,
+		Hash:   hashName(opts.HashFunc()),
+	}
+	if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+		req.PSS = true
+		req.PSSSaltLength = pssOpts.SaltLength
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.Client.Post(s.URL+"/sign", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("signer sign: %v %s", resp.Status, bytes.TrimSpace(data))
+	}
+	res := new(signResponse)
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+	if len(res.Signature) == 0 {
+		return nil, errors.New("signer sign: empty signature")
+	}
+	return res.Signature, nil
+}
+
+// NewSignerHandler serves the signer http api with signer and its certificate chain, password is optional
+func NewSignerHandler(signer crypto.Signer, chain []*x509.Certificate, password string) http.Handler {
+	chainPEM := new(bytes.Buffer)
+	for _, c := range chain {
+		_ = pem.Encode(chainPEM, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		_, _ = w.Write(chainPEM.Bytes())
+	})
+	mux.HandleFunc("/sign", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		req := new(signRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hash, err := parseHashName(req.Hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var opts crypto.SignerOpts = hash
+		if req.PSS {
+			opts = &rsa.PSSOptions{SaltLength: req.PSSSaltLength, Hash: hash}
+		}
+		signature, err := signer.Sign(rand.Reader, req.Digest, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&signResponse{Signature: signature})
+	})
+
+	if password == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pass, _ := r.BasicAuth()
+		if subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="go-mitmproxy signer"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
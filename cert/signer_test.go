@@ -0,0 +1,44 @@
+package cert
+
+import (
+	"crypto/x509"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSigner(t *testing.T) {
+	for _, keyType := range []KeyType{KeyTypeRSA2048, KeyTypeECDSAP256, KeyTypeEd25519} {
+		t.Run(string(keyType), func(t *testing.T) {
+			caApi, err := NewSelfSignCAMemoryWithOptions(&Options{CaKeyType: keyType})
+			if err != nil {
+				t.Fatal(err)
+			}
+			issuer := caApi.(*SelfSignCA)
+			server := httptest.NewServer(NewSignerHandler(issuer.PrivateKey, []*x509.Certificate{&issuer.RootCert}, "secret"))
+			defer server.Close()
+
+			if _, err := NewSelfSignCAFromSigner(NewHTTPSigner(server.URL), nil); err == nil || !strings.Contains(err.Error(), "401") {
+				t.Fatalf("expected unauthorized error, but got %v", err)
+			}
+
+			ca, err := NewSelfSignCAFromSigner(NewHTTPSigner(strings.Replace(server.URL, "://", "://:secret@", 1)), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := ca.GetCert("example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := x509.ParseCertificate(c.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(&issuer.RootCert)
+			if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/log"
+)
+
+// TrustedCA serves certificates already trusted by clients, e.g. issued by a public or corporate ca, no certificate is generated.
+// Certificates are loaded from a directory, each file with extension .pem, .crt, .cer or .key,
+// files with the same base name are combined, e.g. example.com.crt + example.com.key, or a single example.com.pem,
+// containing the certificate chain and the private key.
+type TrustedCA struct {
+	Dir string
+
+	certs    map[string]*tls.Certificate // exact or wildcard dns name, ip
+	rootCert *x509.Certificate
+}
+
+var trustedCAExts = []string{".pem", ".crt", ".cer", ".key"}
+
+// NewTrustedCA load certificates from dir, an error returned when no certificate found
+func NewTrustedCA(dir string) (CA, error) {
+	ca := &TrustedCA{
+		Dir:   dir,
+		certs: make(map[string]*tls.Certificate),
+	}
+	if err := ca.load(); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+func (ca *TrustedCA) load() error {
+	entries, err := os.ReadDir(ca.Dir)
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[string][]byte)
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if !entry.Type().IsRegular() || !containsString(trustedCAExts, ext) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(ca.Dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		groups[name] = append(append(groups[name], data...), '\n')
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data := groups[name]
+		if !bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+			// ca certificate to install on clients
+			if ca.rootCert == nil {
+				if certs, err := parseCertsPEM(data); err == nil {
+					ca.setRoot(certs)
+				}
+			}
+			continue
+		}
+		cert, err := tls.X509KeyPair(data, data)
+		if err != nil {
+			log.Warnf("trusted ca: load %v error: %v", name, err)
+			continue
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		cert.Leaf = leaf
+		ca.add(&cert)
+		log.Debugf("trusted ca: loaded %v", name)
+	}
+
+	if len(ca.certs) == 0 {
+		return fmt.Errorf("no certificate with private key found in %v", ca.Dir)
+	}
+	return nil
+}
+
+func parseCertsPEM(data []byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
+
+// setRoot use the self signed ca certificate in chain as root
+func (ca *TrustedCA) setRoot(chain []*x509.Certificate) {
+	for _, c := range chain {
+		if c.IsCA && bytes.Equal(c.RawSubject, c.RawIssuer) {
+			ca.rootCert = c
+			return
+		}
+	}
+}
+
+func (ca *TrustedCA) add(cert *tls.Certificate) {
+	names := append([]string{}, cert.Leaf.DNSNames...)
+	for _, ip := range cert.Leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+		names = append(names, cert.Leaf.Subject.CommonName)
+	}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		// the first loaded certificate wins
+		if _, ok := ca.certs[name]; !ok {
+			ca.certs[name] = cert
+		}
+	}
+
+	if ca.rootCert == nil && len(cert.Certificate) > 1 {
+		chain := make([]*x509.Certificate, 0, len(cert.Certificate)-1)
+		for _, der := range cert.Certificate[1:] {
+			if c, err := x509.ParseCertificate(der); err == nil {
+				chain = append(chain, c)
+			}
+		}
+		ca.setRoot(chain)
+	}
+}
+
+// GetRootCA returns nil when no self signed ca certificate found in dir
+func (ca *TrustedCA) GetRootCA() *x509.Certificate {
+	return ca.rootCert
+}
+
+func (ca *TrustedCA) GetCert(commonName string) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(commonName, "."))
+	if cert, ok := ca.certs[name]; ok {
+		return cert, nil
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := ca.certs["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("no trusted certificate for %v", commonName)
+}
+
+func (ca *TrustedCA) GetCertFor(hello *tls.ClientHelloInfo, upstreamCert *x509.Certificate) (*tls.Certificate, error) {
+	return ca.GetCert(hello.ServerName)
+}
@@ -0,0 +1,69 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrustedCA(t *testing.T) {
+	caApi, err := NewSelfSignCAMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := caApi.(*SelfSignCA)
+	c, err := issuer.IssueCert("example.com", []string{"*.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certPEM := new(bytes.Buffer)
+	for _, der := range c.Certificate {
+		pem.Encode(certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(c.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.RootCert.Raw})
+	for name, data := range map[string][]byte{
+		"example.com.crt": certPEM.Bytes(),
+		"example.com.key": keyPEM,
+		"ca.pem":          rootPEM,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ca, err := NewTrustedCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.GetRootCA().Equal(&issuer.RootCert) {
+		t.Fatal("root should be loaded from ca.pem")
+	}
+	for _, host := range []string{"example.com", "www.example.com", "WWW.Example.com."} {
+		got, err := ca.GetCert(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Certificate[0], c.Certificate[0]) {
+			t.Fatalf("%v: unexpected certificate", host)
+		}
+	}
+	for _, host := range []string{"example.org", "a.b.example.com"} {
+		if _, err := ca.GetCert(host); err == nil {
+			t.Fatalf("%v: expected error", host)
+		}
+	}
+
+	if _, err := NewTrustedCA(t.TempDir()); err == nil {
+		t.Fatal("expected error for empty dir")
+	}
+}
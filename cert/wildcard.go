@@ -0,0 +1,50 @@
+package cert
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// wildcardName returns *.parent covering name, the parent domain should be at least the registrable domain,
+// e.g. www.example.com -> *.example.com, example.com -> *.example.com, a.b.example.co.uk -> *.b.example.co.uk
+// ok is false when name is an ip, a single label or a public suffix
+func wildcardName(name string) (wildcard string, ok bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" || strings.HasPrefix(name, "*.") || net.ParseIP(name) != nil {
+		return "", false
+	}
+
+	base, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return "", false
+	}
+	if name == base {
+		return "*." + base, true
+	}
+
+	// wildcard only matches one label
+	parent := name[strings.Index(name, ".")+1:]
+	return "*." + parent, true
+}
+
+// leafName returns the name used as certificate cache key and common name
+func (ca *SelfSignCA) leafName(commonName string) string {
+	if !ca.Opts.WildcardLeafCerts {
+		return commonName
+	}
+	wildcard, ok := wildcardName(commonName)
+	if !ok || !ca.dnsNamePermitted(wildcard) || !ca.dnsNamePermitted(wildcard[2:]) {
+		return commonName
+	}
+	return wildcard
+}
+
+// wildcardParent *.example.com -> example.com
+func wildcardParent(name string) (string, bool) {
+	if strings.HasPrefix(name, "*.") {
+		return name[2:], true
+	}
+	return "", false
+}
@@ -6,6 +6,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/lqqyt2423/go-mitmproxy/cert"
 	"github.com/lqqyt2423/go-mitmproxy/log"
@@ -15,16 +17,60 @@ import (
 // 生成假的/用于测试的服务器证书
 
 type Config struct {
-	commonName string
+	commonName     string
+	certPath       string
+	keyType        string
+	caSubject      string
+	caValidityDays int
+	caKeyType      string
+	caPermitted    string
 }
 
 func loadConfig() *Config {
 	config := new(Config)
 	flag.StringVar(&config.commonName, "commonName", "", "server commonName")
+	flag.StringVar(&config.certPath, "cert_path", "", "path of generate cert files")
+	flag.StringVar(&config.keyType, "key_type", "", "key type of server certificate: rsa2048, rsa3072, ecdsa-p256, ecdsa-p384, ed25519. Default: ecdsa-p256")
+	flag.StringVar(&config.caSubject, "ca_subject", "", "subject of newly created CA, e.g. CN=mitmproxy,O=mitmproxy")
+	flag.IntVar(&config.caValidityDays, "ca_validity_days", 0, "validity days of newly created CA. Default: 3 years")
+	flag.StringVar(&config.caKeyType, "ca_key_type", "", "key type of newly created CA. Default: rsa2048")
+	flag.StringVar(&config.caPermitted, "ca_permitted_domains", "", "name constraints of newly created CA, comma separated permitted domains")
 	flag.Parse()
 	return config
 }
 
+func newCertOptions(config *Config) (*cert.Options, error) {
+	opts := cert.DefaultOptions()
+	keyType, err := cert.ParseKeyType(config.keyType)
+	if err != nil {
+		return nil, err
+	}
+	if keyType != "" {
+		opts.LeafKeyType = keyType
+	}
+	caKeyType, err := cert.ParseKeyType(config.caKeyType)
+	if err != nil {
+		return nil, err
+	}
+	if caKeyType != "" {
+		opts.CaKeyType = caKeyType
+	}
+	if config.caSubject != "" {
+		subject, err := cert.ParseSubject(config.caSubject)
+		if err != nil {
+			return nil, err
+		}
+		opts.CaSubject = subject
+	}
+	if config.caValidityDays > 0 {
+		opts.CaValidity = time.Hour * 24 * time.Duration(config.caValidityDays)
+	}
+	if config.caPermitted != "" {
+		opts.PermittedDNSDomains = strings.Split(config.caPermitted, ",")
+	}
+	return opts, nil
+}
+
 func main() {
 	logrus.SetLevel(logrus.InfoLevel)
 	logrus.SetReportCaller(false)
@@ -38,7 +84,11 @@ func main() {
 		log.Fatal("commonName required")
 	}
 
-	caApi, err := cert.NewSelfSignCA("")
+	opts, err := newCertOptions(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	caApi, err := cert.NewSelfSignCAWithOptions(config.certPath, opts)
 	if err != nil {
 		panic(err)
 	}
@@ -56,7 +106,7 @@ func main() {
 	}
 	os.Stdout.WriteString(fmt.Sprintf("\n%v-key.pem\n", config.commonName))
 
-	keyBytes, err := x509.MarshalPKCS8PrivateKey(&ca.PrivateKey)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+)
+
+// go-mitmproxy cert <command> [flags]
+
+const certUsage = `Usage: go-mitmproxy cert <command> [flags]
+
+Commands:
+  init      create ca in cert path if not exists
+  show      show ca details
+  export    export ca in pem, der, p12, cert or p12ca format
+  import    import ca from PKCS#12 or PEM (encrypted private key supported) file into cert path
+  rotate    backup current ca and create a new one
+  issue     issue a server certificate, e.g. issue -san example.com -san 127.0.0.1
+  verify    verify the certificate chain generated by running proxy, e.g. verify example.com
+  signer    serve ca in cert path as an external signer, for go-mitmproxy -ca signer:<url>
+
+Run 'go-mitmproxy cert <command> -h' for flags of the command.
+`
+
+func runCertCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, certUsage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func([]string){
+		"init":   certInit,
+		"show":   certShow,
+		"export": certExport,
+		"import": certImport,
+		"rotate": certRotate,
+		"issue":  certIssue,
+		"verify": certVerify,
+		"signer": certSigner,
+	}
+	if command, ok := commands[args[0]]; ok {
+		command(args[1:])
+		return
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, certUsage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown cert command %v\n\n%v", args[0], certUsage)
+		os.Exit(2)
+	}
+}
+
+// newCertFlagSet flags of cert path and ca options
+func newCertFlagSet(name string) (*flag.FlagSet, *Config) {
+	fs := flag.NewFlagSet("cert "+name, flag.ExitOnError)
+	config := new(Config)
+	fs.StringVar(&config.CertPath, "cert_path", "", "path of generate cert files")
+	registerCaFlags(fs, config)
+	return fs, config
+}
+
+func mustCertOptions(config *Config) *cert.Options {
+	certOpts, err := newCertOptions(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return certOpts
+}
+
+// mustLoadCA loads the existing ca in cert path, only init creates a new one
+func mustLoadCA(config *Config) *cert.SelfSignCA {
+	mustHaveCA(config)
+	return mustLoadOrCreateCA(config)
+}
+
+func mustHaveCA(config *Config) {
+	exists, err := cert.HasSelfSignCA(config.CertPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !exists {
+		path := config.CertPath
+		if path == "" {
+			path = "~/.mitmproxy"
+		}
+		log.Fatalf("no ca in cert path %v, create one by go-mitmproxy cert init or import one by go-mitmproxy cert import", path)
+	}
+}
+
+func mustLoadOrCreateCA(config *Config) *cert.SelfSignCA {
+	caApi, err := cert.NewSelfSignCAWithOptions(config.CertPath, mustCertOptions(config))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return caApi.(*cert.SelfSignCA)
+}
+
+func certInit(args []string) {
+	fs, config := newCertFlagSet("init")
+	fs.Parse(args)
+
+	exists, err := cert.HasSelfSignCA(config.CertPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ca := mustLoadOrCreateCA(config)
+	if exists {
+		fmt.Printf("ca already exists in %v, use rotate to replace it\n", ca.StorePath)
+	} else {
+		fmt.Printf("ca created in %v\n", ca.StorePath)
+	}
+	printCert(os.Stdout, &ca.RootCert)
+}
+
+func certShow(args []string) {
+	fs, config := newCertFlagSet("show")
+	fs.Parse(args)
+
+	ca := mustLoadCA(config)
+	fmt.Printf("Store Path: %v\n", ca.StorePath)
+	fmt.Println("Root:")
+	printCert(os.Stdout, &ca.RootCert)
+	for i, intermediate := range ca.Intermediates {
+		fmt.Printf("Intermediate %d:\n", i)
+		printCert(os.Stdout, intermediate)
+	}
+}
+
+func printCert(w io.Writer, c *x509.Certificate) {
+	sum := sha256.Sum256(c.Raw)
+	fmt.Fprintf(w, "  Subject:        %v\n", c.Subject)
+	fmt.Fprintf(w, "  Issuer:         %v\n", c.Issuer)
+	fmt.Fprintf(w, "  Serial Number:  %v\n", c.SerialNumber)
+	fmt.Fprintf(w, "  Not Before:     %v\n", c.NotBefore.Format(time.RFC3339))
+	fmt.Fprintf(w, "  Not After:      %v\n", c.NotAfter.Format(time.RFC3339))
+	fmt.Fprintf(w, "  Public Key:     %v\n", c.PublicKeyAlgorithm)
+	fmt.Fprintf(w, "  SHA256:         %v\n", strings.ToUpper(hex.EncodeToString(sum[:])))
+	if len(c.DNSNames) > 0 || len(c.IPAddresses) > 0 {
+		names := append([]string{}, c.DNSNames...)
+		for _, ip := range c.IPAddresses {
+			names = append(names, ip.String())
+		}
+		fmt.Fprintf(w, "  Alt Names:      %v\n", strings.Join(names, ", "))
+	}
+	if len(c.PermittedDNSDomains) > 0 {
+		fmt.Fprintf(w, "  Permitted:      %v\n", strings.Join(c.PermittedDNSDomains, ", "))
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func openOutput(filename string, private bool) (io.WriteCloser, error) {
+	if filename == "" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	mode := os.FileMode(0644)
+	if private {
+		mode = 0600
+	}
+	return os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+}
+
+func certExport(args []string) {
+	fs, config := newCertFlagSet("export")
+	format := fs.String("format", cert.FormatCert, "export format: "+strings.Join(cert.ExportFormats, ", "))
+	out := fs.String("out", "", "output filename, default stdout")
+	password := fs.String("password", "", "password to encrypt the exported private key or PKCS#12 file")
+	withKey := fs.Bool("key", false, "export the ca private key, for sharing one ca between machines")
+	fs.Parse(args)
+
+	ca := mustLoadCA(config)
+	w, err := openOutput(*out, *withKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+	if err := ca.Export(w, *format, *password, *withKey); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func certImport(args []string) {
+	fs, config := newCertFlagSet("import")
+	in := fs.String("in", "", "ca file to import, .p12/.pfx as PKCS#12, others as PEM")
+	password := fs.String("password", "", "password of the imported file")
+	fs.Parse(args)
+	if *in == "" {
+		log.Fatal("-in required")
+	}
+
+	ca, err := cert.ImportSelfSignCA(config.CertPath, *in, *password, mustCertOptions(config))
+	if err != nil {
+		log.Fatalf("import %v error: %v", *in, err)
+	}
+	fmt.Printf("imported ca %v into %v\n", ca.RootCert.Subject, ca.StorePath)
+}
+
+func certRotate(args []string) {
+	fs, config := newCertFlagSet("rotate")
+	fs.Parse(args)
+	mustHaveCA(config)
+
+	ca, backupDir, err := cert.RotateSelfSignCA(config.CertPath, mustCertOptions(config))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("old ca moved to %v, new ca created in %v\n", backupDir, ca.StorePath)
+	printCert(os.Stdout, &ca.RootCert)
+}
+
+func certIssue(args []string) {
+	fs, config := newCertFlagSet("issue")
+	var sans []string
+	fs.Var((*arrayValue)(&sans), "san", "subject alternative name, dns name or ip, can be repeated")
+	commonName := fs.String("cn", "", "common name. Default: the first san")
+	out := fs.String("out", "", "certificate chain output filename, default stdout")
+	keyOut := fs.String("key_out", "", "private key output filename, default appended to certificate output")
+	fs.Parse(args)
+	if len(sans) == 0 && *commonName == "" {
+		log.Fatal("-san or -cn required")
+	}
+
+	ca := mustLoadCA(config)
+	c, err := ca.IssueCert(*commonName, sans)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w, err := openOutput(*out, *keyOut == "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+	for _, der := range c.Certificate {
+		if err := pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(c.PrivateKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyWriter := w
+	if *keyOut != "" {
+		keyWriter, err = openOutput(*keyOut, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer keyWriter.Close()
+	}
+	if err := pem.Encode(keyWriter, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// certVerify connect to host through the proxy, verify the presented chain with ca in cert path
+func certVerify(args []string) {
+	fs, config := newCertFlagSet("verify")
+	proxyAddr := fs.String("proxy", "http://127.0.0.1:9080", "proxy url, go-mitmproxy should be running")
+	timeout := fs.Duration("timeout", time.Second*10, "timeout")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatal("host required, e.g. go-mitmproxy cert verify example.com")
+	}
+	host := fs.Arg(0)
+
+	proxyUrl, err := url.Parse(*proxyAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ca := mustLoadCA(config)
+	roots := x509.NewCertPool()
+	roots.AddCert(&ca.RootCert)
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	var chain []*x509.Certificate
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(proxyUrl),
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true, // verify in VerifyConnection to report the chain even if invalid
+				VerifyConnection: func(cs tls.ConnectionState) error {
+					chain = cs.PeerCertificates
+					return nil
+				},
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Head("https://" + host + "/")
+	if err != nil && len(chain) == 0 {
+		log.Fatalf("connect %v through %v error: %v", host, proxyUrl, err)
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	for i, c := range chain {
+		fmt.Printf("Certificate %d:\n", i)
+		printCert(os.Stdout, c)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = chain[0].Verify(x509.VerifyOptions{
+		DNSName:       hostname,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		fmt.Printf("verify failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("verify ok: %v is issued by %v\n", hostname, ca.RootCert.Subject)
+}
+
+// certSigner the private key only lives in this process, proxies sign leaf certificates through the http api
+func certSigner(args []string) {
+	fs, config := newCertFlagSet("signer")
+	addr := fs.String("addr", "127.0.0.1:9443", "signer listen addr")
+	password := fs.String("password", "", "basic auth password required by the signer, proxies use signer:http://:<password>@<addr>")
+	fs.Parse(args)
+
+	ca := mustLoadCA(config)
+	chain := append(append([]*x509.Certificate{}, ca.Intermediates...), &ca.RootCert)
+	handler := cert.NewSignerHandler(ca.PrivateKey, chain, *password)
+	log.Infof("signer of ca %v listen at %v", ca.RootCert.Subject, *addr)
+	log.Fatal(http.ListenAndServe(*addr, handler))
+}
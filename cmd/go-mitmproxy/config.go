@@ -26,19 +26,62 @@ func loadConfigFromCli() *Config {
 	flag.Var((*arrayValue)(&config.IgnoreHosts), "ignore_hosts", "a list of ignore hosts")
 	flag.Var((*arrayValue)(&config.AllowHosts), "allow_hosts", "a list of allow hosts")
 	flag.StringVar(&config.CertPath, "cert_path", "", "path of generate cert files")
+	flag.StringVar(&config.Ca, "ca", "", "ca backend: file - self signed ca in cert_path, memory - temporary self signed ca, env[:<name>] - PEM private key and certificates in environment variable, MITMPROXY_CA by default, trusted:<dir> - certificates in dir, signer:<url> - external signer. Default: file")
 	flag.IntVar(&config.Debug, "debug", 0, "debug mode: 1 - print debug log, 2 - show debug from")
 	flag.StringVar(&config.Dump, "dump", "", "dump filename")
 	flag.IntVar(&config.DumpLevel, "dump_level", 0, "dump level: 0 - header, 1 - header + body")
+	flag.StringVar(&config.DumpFormat, "dump_format", "", "dump format: text - for reading, json - JSON lines with full metadata and base64 bodies. Default: text")
+	flag.StringVar(&config.DumpFilter, "dump_filter", "", "only dump flows matched by the filter expression, e.g. '~d example.com & !~t image'")
+	flag.Var((*arrayValue)(&config.DumpRedact), "dump_redact", "a list of headers whose values are replaced by [REDACTED] in dump, e.g. Authorization")
+	flag.IntVar(&config.DumpMaxSize, "dump_max_size", 0, "rotate the dump file when it exceeds the size in MB, rotated files are compressed by gzip, 0 - never")
+	flag.StringVar(&config.DumpRotate, "dump_rotate", "", "rotate the dump file at the interval, e.g. 1h. Default: never")
 	flag.StringVar(&config.Upstream, "upstream", "", "upstream proxy")
 	flag.BoolVar(&config.UpstreamCert, "upstream_cert", true, "connect to upstream server to look up certificate details")
 	flag.StringVar(&config.MapRemote, "map_remote", "", "map remote config filename")
 	flag.StringVar(&config.MapLocal, "map_local", "", "map local config filename")
+	flag.StringVar(&config.ClientCerts, "client_certs", "", "client certificates config filename, for upstream mutual TLS")
+	flag.StringVar(&config.CaBundle, "ca_bundle", "", "extra trusted CA file or directory for upstream server certificates")
+	flag.StringVar(&config.CertPins, "cert_pins", "", "upstream certificate pins config filename")
+	flag.StringVar(&config.SslKeyLogFile, "ssl_key_log_file", "", "tls key log filename, for decrypting captures in Wireshark. Default: env SSLKEYLOGFILE")
+	flag.IntVar(&config.PcapConns, "pcap_conns", 0, "number of recent connections kept for pcapng export in web interface, at most 16MB of each connection is kept, 0 - disable")
+	flag.IntVar(&config.PcapSize, "pcap_size", 0, "max size in MB of bytes kept for pcapng export, the oldest connections are dropped when exceeded. Default: 256")
+	flag.IntVar(&config.StoreFlows, "store_flows", 0, "max number of finished flows kept for web interface and /api/flows. Default: 10000")
+	flag.IntVar(&config.StoreSize, "store_size", 0, "max size in MB of bodies of kept flows in memory. Default: 256")
+	flag.StringVar(&config.StoreSpill, "store_spill", "", "spill bodies exceeding store_size to the filename, instead of dropping the oldest flows")
+	flag.IntVar(&config.StoreSpillMax, "store_spill_size", 0, "max size in MB of bodies in store_spill file, the oldest flows are dropped when exceeded. Default: 2048")
+	flag.StringVar(&config.HistoryDb, "history_db", "", "store flows in the SQLite database filename, query by go-mitmproxy history or /api/history")
+	flag.StringVar(&config.HistoryMaxAge, "history_max_age", "", "delete flows in history database older than the duration, e.g. 72h. Default: keep forever")
+	flag.IntVar(&config.HistoryFlows, "history_max_flows", 0, "max number of flows in history database, the oldest are deleted, 0 - unlimited")
+	flag.StringVar(&config.Har, "har", "", "write flows to the HAR filename, recent flows can also be downloaded in web interface")
+	flag.StringVar(&config.SaveStream, "save_stream_file", "", "save flows with bodies to the filename, in mitmproxy format when the extension is .mitm, which can be read by -read_flows, -server_replay and replay-client")
+	flag.StringVar(&config.Warc, "warc", "", "write flows to the WARC filename, compressed when the extension is .gz")
+	flag.IntVar(&config.WarcMaxSize, "warc_max_size", 0, "max size in MB of a WARC file, a new file is started when exceeded. Default: 1024")
+	flag.StringVar(&config.ReadFlows, "read_flows", "", "read flows from the flow, mitmproxy or HAR filename and show them in web interface")
+	flag.Var((*arrayValue)(&config.ServerReplay), "server_replay", "a list of flow or HAR filenames, answer matched requests with recorded responses without contacting servers")
+	flag.StringVar(&config.ServerReplayUnmatched, "server_replay_unmatched", "", "server replay of unmatched requests: pass - send to server, 404 - respond 404, kill - close the connection. Default: pass")
+	flag.Var((*arrayValue)(&config.ServerReplayIgnoreParams), "server_replay_ignore_params", "server replay: a list of query params not matched")
+	flag.Var((*arrayValue)(&config.ServerReplayHeaders), "server_replay_headers", "server replay: a list of request headers matched")
+	flag.BoolVar(&config.ServerReplayIgnoreBody, "server_replay_ignore_body", false, "server replay: not match request body")
+	flag.IntVar(&config.LeafCertCache, "leaf_cert_cache", 0, "max number of generated server certificates cached in cert_path, 0 - disable")
+	flag.BoolVar(&config.WildcardCerts, "wildcard_certs", false, "issue wildcard server certificates like *.example.com, shared by subdomains")
+	registerCaFlags(flag.CommandLine, config)
+	flag.BoolVar(&config.CertPortal, "cert_portal", false, "serve certificate installation page at http://mitm.it through proxy and at the proxy address")
 	flag.StringVar(&config.filename, "f", "", "read config from the filename")
 	flag.Parse()
 
 	return config
 }
 
+// ca flags shared with cert subcommands
+func registerCaFlags(fs *flag.FlagSet, config *Config) {
+	fs.StringVar(&config.LeafKeyType, "leaf_key_type", "", "key type of generated server certificates: rsa2048, rsa3072, ecdsa-p256, ecdsa-p384, ed25519. Default: ecdsa-p256")
+	fs.StringVar(&config.CaSubject, "ca_subject", "", "subject of newly created CA, e.g. CN=mitmproxy,O=mitmproxy")
+	fs.IntVar(&config.CaValidityDays, "ca_validity_days", 0, "validity days of newly created CA. Default: 3 years")
+	fs.StringVar(&config.CaKeyType, "ca_key_type", "", "key type of newly created CA: rsa2048, rsa3072, ecdsa-p256, ecdsa-p384, ed25519. Default: rsa2048")
+	fs.Var((*arrayValue)(&config.CaPermittedDomains), "ca_permitted_domains", "name constraints of newly created CA, a list of permitted domains")
+	fs.StringVar(&config.CaPassword, "ca_password", "", "password of encrypted CA private key in cert_path")
+}
+
 func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	config := new(Config)
 	*config = *fileConfig
@@ -60,6 +103,9 @@ func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	if cliConfig.CertPath != "" {
 		config.CertPath = cliConfig.CertPath
 	}
+	if cliConfig.Ca != "" {
+		config.Ca = cliConfig.Ca
+	}
 	if cliConfig.Debug != 0 {
 		config.Debug = cliConfig.Debug
 	}
@@ -69,6 +115,21 @@ func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	if cliConfig.DumpLevel != 0 {
 		config.DumpLevel = cliConfig.DumpLevel
 	}
+	if cliConfig.DumpFormat != "" {
+		config.DumpFormat = cliConfig.DumpFormat
+	}
+	if cliConfig.DumpFilter != "" {
+		config.DumpFilter = cliConfig.DumpFilter
+	}
+	if len(cliConfig.DumpRedact) > 0 {
+		config.DumpRedact = cliConfig.DumpRedact
+	}
+	if cliConfig.DumpMaxSize != 0 {
+		config.DumpMaxSize = cliConfig.DumpMaxSize
+	}
+	if cliConfig.DumpRotate != "" {
+		config.DumpRotate = cliConfig.DumpRotate
+	}
 	if cliConfig.Upstream != "" {
 		config.Upstream = cliConfig.Upstream
 	}
@@ -81,6 +142,102 @@ func mergeConfigs(fileConfig, cliConfig *Config) *Config {
 	if cliConfig.MapLocal != "" {
 		config.MapLocal = cliConfig.MapLocal
 	}
+	if cliConfig.ClientCerts != "" {
+		config.ClientCerts = cliConfig.ClientCerts
+	}
+	if cliConfig.CaBundle != "" {
+		config.CaBundle = cliConfig.CaBundle
+	}
+	if cliConfig.CertPins != "" {
+		config.CertPins = cliConfig.CertPins
+	}
+	if cliConfig.SslKeyLogFile != "" {
+		config.SslKeyLogFile = cliConfig.SslKeyLogFile
+	}
+	if cliConfig.PcapConns != 0 {
+		config.PcapConns = cliConfig.PcapConns
+	}
+	if cliConfig.PcapSize != 0 {
+		config.PcapSize = cliConfig.PcapSize
+	}
+	if cliConfig.StoreFlows != 0 {
+		config.StoreFlows = cliConfig.StoreFlows
+	}
+	if cliConfig.StoreSize != 0 {
+		config.StoreSize = cliConfig.StoreSize
+	}
+	if cliConfig.StoreSpill != "" {
+		config.StoreSpill = cliConfig.StoreSpill
+	}
+	if cliConfig.StoreSpillMax != 0 {
+		config.StoreSpillMax = cliConfig.StoreSpillMax
+	}
+	if cliConfig.HistoryDb != "" {
+		config.HistoryDb = cliConfig.HistoryDb
+	}
+	if cliConfig.HistoryMaxAge != "" {
+		config.HistoryMaxAge = cliConfig.HistoryMaxAge
+	}
+	if cliConfig.HistoryFlows != 0 {
+		config.HistoryFlows = cliConfig.HistoryFlows
+	}
+	if cliConfig.Har != "" {
+		config.Har = cliConfig.Har
+	}
+	if cliConfig.SaveStream != "" {
+		config.SaveStream = cliConfig.SaveStream
+	}
+	if cliConfig.Warc != "" {
+		config.Warc = cliConfig.Warc
+	}
+	if cliConfig.WarcMaxSize != 0 {
+		config.WarcMaxSize = cliConfig.WarcMaxSize
+	}
+	if cliConfig.ReadFlows != "" {
+		config.ReadFlows = cliConfig.ReadFlows
+	}
+	if len(cliConfig.ServerReplay) > 0 {
+		config.ServerReplay = cliConfig.ServerReplay
+	}
+	if cliConfig.ServerReplayUnmatched != "" {
+		config.ServerReplayUnmatched = cliConfig.ServerReplayUnmatched
+	}
+	if len(cliConfig.ServerReplayIgnoreParams) > 0 {
+		config.ServerReplayIgnoreParams = cliConfig.ServerReplayIgnoreParams
+	}
+	if len(cliConfig.ServerReplayHeaders) > 0 {
+		config.ServerReplayHeaders = cliConfig.ServerReplayHeaders
+	}
+	if cliConfig.ServerReplayIgnoreBody {
+		config.ServerReplayIgnoreBody = cliConfig.ServerReplayIgnoreBody
+	}
+	if cliConfig.LeafKeyType != "" {
+		config.LeafKeyType = cliConfig.LeafKeyType
+	}
+	if cliConfig.CertPortal {
+		config.CertPortal = cliConfig.CertPortal
+	}
+	if cliConfig.LeafCertCache != 0 {
+		config.LeafCertCache = cliConfig.LeafCertCache
+	}
+	if cliConfig.WildcardCerts {
+		config.WildcardCerts = cliConfig.WildcardCerts
+	}
+	if cliConfig.CaSubject != "" {
+		config.CaSubject = cliConfig.CaSubject
+	}
+	if cliConfig.CaValidityDays != 0 {
+		config.CaValidityDays = cliConfig.CaValidityDays
+	}
+	if cliConfig.CaKeyType != "" {
+		config.CaKeyType = cliConfig.CaKeyType
+	}
+	if len(cliConfig.CaPermittedDomains) > 0 {
+		config.CaPermittedDomains = cliConfig.CaPermittedDomains
+	}
+	if cliConfig.CaPassword != "" {
+		config.CaPassword = cliConfig.CaPassword
+	}
 	return config
 }
 
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/addon"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy/export"
+)
+
+// go-mitmproxy export [flags] <file>...
+
+const exportUsage = `Usage: go-mitmproxy export [flags] <file>...
+
+Print the requests in flow files or HAR files as commands or code which send them again, e.g.
+
+  go-mitmproxy export -format curl -flow 0b2f2b9e-6d3c-4a53-9e0d-0c3b1a2f5c1e flows.mitm
+
+Flags:
+`
+
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), exportUsage)
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", export.FormatCurl, "output format: "+strings.Join(export.Formats, ", "))
+	flowIds := fs.String("flow", "", "comma separated flow ids, empty means all")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ids := make(map[string]bool)
+	for _, id := range strings.Split(*flowIds, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[id] = true
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	n := 0
+	for _, filename := range fs.Args() {
+		flows, err := addon.ReadFlowsFile(filename)
+		if err != nil {
+			log.Fatalf("load %v error: %v", filename, err)
+		}
+		for _, f := range flows {
+			if len(ids) > 0 && !ids[f.Id.String()] {
+				continue
+			}
+			data, err := export.Export(f.Request, *format)
+			if errors.Is(err, export.ErrBodyTruncated) {
+				log.Warnf("skip flow %v: %v", f.Id, err)
+				continue
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			if n > 0 {
+				w.WriteString("\n")
+			}
+			w.Write(data)
+			n++
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/flowdb"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// go-mitmproxy history [flags]
+
+const historyUsage = `Usage: go-mitmproxy history -db <file> [flags]
+
+Query flows stored by go-mitmproxy -history_db, the newest first, e.g. all 5xx from example.com yesterday:
+
+  go-mitmproxy history -db flows.db -host example.com -status 5xx -since yesterday -until today
+
+Flags:
+`
+
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), historyUsage)
+		fs.PrintDefaults()
+	}
+	filename := fs.String("db", "", "history database filename")
+	host := fs.String("host", "", "host without port")
+	path := fs.String("path", "", "path prefix")
+	method := fs.String("method", "", "request method")
+	status := fs.String("status", "", "response status: 500, 5xx or 500-599")
+	contentType := fs.String("content_type", "", "media type of response, prefix when ends with /, e.g. image/")
+	since := fs.String("since", "", "flows started at or after: RFC3339, 2006-01-02 15:04:05, 2006-01-02, today, yesterday or duration before now, e.g. 24h")
+	until := fs.String("until", "", "flows started before, same formats as since")
+	limit := fs.Int("limit", flowdb.DefaultQueryLimit, "max number of flows")
+	offset := fs.Int("offset", 0, "number of flows skipped")
+	asJson := fs.Bool("json", false, "print records as JSON lines")
+	out := fs.String("out", "", "write matched flows with bodies to the flow filename, in mitmproxy format when the extension is .mitm")
+	fs.Parse(args)
+	if *filename == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if _, err := os.Stat(*filename); err != nil {
+		log.Fatalf("open history database error: %v", err)
+	}
+
+	values := url.Values{}
+	for key, value := range map[string]string{
+		"host":         *host,
+		"path":         *path,
+		"method":       *method,
+		"status":       *status,
+		"content_type": *contentType,
+		"since":        *since,
+		"until":        *until,
+		"limit":        strconv.Itoa(*limit),
+		"offset":       strconv.Itoa(*offset),
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	q, err := flowdb.ParseQuery(values)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := flowdb.Open(*filename, nil)
+	if err != nil {
+		log.Fatalf("open history database error: %v", err)
+	}
+	defer db.Close()
+
+	if *out != "" {
+		n, err := historyExport(db, q, *out)
+		if err != nil {
+			log.Fatalf("export flows error: %v", err)
+		}
+		fmt.Printf("wrote %v flows to %v\n", n, *out)
+		return
+	}
+
+	records, err := db.Query(q)
+	if err != nil {
+		log.Fatalf("query history error: %v", err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if *asJson {
+			enc.Encode(r)
+			continue
+		}
+		status := "-"
+		if r.StatusCode > 0 {
+			status = strconv.Itoa(r.StatusCode)
+		}
+		size := strconv.Itoa(r.ResponseSize)
+		if r.ResponseTruncated {
+			size += "(truncated)"
+		}
+		fmt.Fprintf(w, "%v %v %v %v %v %v %v\n", r.StartTime.Format("2006-01-02 15:04:05.000"), r.Id, status, r.Method, r.URL, size, r.ContentType)
+	}
+}
+
+func historyExport(db *flowdb.DB, q *flowdb.Query, filename string) (int, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	buf := bufio.NewWriter(file)
+
+	var write func(f *proxy.Flow) error
+	if strings.ToLower(filepath.Ext(filename)) == ".mitm" {
+		write = proxy.NewMitmproxyFlowWriter(buf).Write
+	} else {
+		write = proxy.NewFlowWriter(buf).Write
+	}
+	n := 0
+	err = db.Each(q, func(f *proxy.Flow) error {
+		n++
+		return write(f)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, buf.Flush()
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	rawLog "log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/lqqyt2423/go-mitmproxy/addon"
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+	"github.com/lqqyt2423/go-mitmproxy/flowdb"
 	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
 	"github.com/lqqyt2423/go-mitmproxy/log"
 	"github.com/lqqyt2423/go-mitmproxy/proxy"
@@ -17,24 +22,83 @@ import (
 type Config struct {
 	version bool // show go-mitmproxy version
 
-	Addr         string   // proxy listen addr
-	WebAddr      string   // web interface listen addr
-	SslInsecure  bool     // not verify upstream server SSL/TLS certificates.
-	IgnoreHosts  []string // a list of ignore hosts
-	AllowHosts   []string // a list of allow hosts
-	CertPath     string   // path of generate cert files
-	Debug        int      // debug mode: 1 - print debug log, 2 - show debug from
-	Dump         string   // dump filename
-	DumpLevel    int      // dump level: 0 - header, 1 - header + body
-	Upstream     string   // upstream proxy
-	UpstreamCert bool     // Connect to upstream server to look up certificate details. Default: True
-	MapRemote    string   // map remote config filename
-	MapLocal     string   // map local config filename
+	Addr          string   // proxy listen addr
+	WebAddr       string   // web interface listen addr
+	SslInsecure   bool     // not verify upstream server SSL/TLS certificates.
+	IgnoreHosts   []string // a list of ignore hosts
+	AllowHosts    []string // a list of allow hosts
+	CertPath      string   // path of generate cert files
+	Debug         int      // debug mode: 1 - print debug log, 2 - show debug from
+	Dump          string   // dump filename
+	DumpLevel     int      // dump level: 0 - header, 1 - header + body
+	DumpFormat    string   // dump format: text or json. Default: text
+	DumpFilter    string   // filter expression of dumped flows
+	DumpRedact    []string // headers whose values are redacted in dump
+	DumpMaxSize   int      // rotate the dump file when it exceeds the size in MB, 0 - never
+	DumpRotate    string   // rotate the dump file at the interval, e.g. 1h
+	Upstream      string   // upstream proxy
+	UpstreamCert  bool     // Connect to upstream server to look up certificate details. Default: True
+	MapRemote     string   // map remote config filename
+	MapLocal      string   // map local config filename
+	ClientCerts   string   // client certificates config filename, for upstream mutual TLS
+	CaBundle      string   // extra trusted CA file or directory for upstream server certificates
+	CertPins      string   // upstream certificate pins config filename
+	SslKeyLogFile string   // tls key log filename, for decrypting captures in Wireshark
+	PcapConns     int      // number of recent connections kept for pcapng export, 0 - disable
+	PcapSize      int      // max size in MB of bytes kept for pcapng export. Default: 256
+	StoreFlows    int      // max number of finished flows kept for web interface. Default: 10000
+	StoreSize     int      // max size in MB of bodies of kept flows in memory. Default: 256
+	StoreSpill    string   // spill bodies exceeding StoreSize to the filename
+	StoreSpillMax int      // max size in MB of bodies in StoreSpill file. Default: 2048
+	HistoryDb     string   // SQLite database filename of flow history
+	HistoryMaxAge string   // delete flows in history older than the duration, e.g. 72h
+	HistoryFlows  int      // max number of flows in history, 0 - unlimited
+	Har           string   // HAR filename
+	SaveStream    string   // save flows with bodies to the filename
+	Warc          string   // WARC filename
+	WarcMaxSize   int      // max size in MB of a WARC file. Default: 1024
+	ReadFlows     string   // read flows from the filename and show them in web interface
+	LeafKeyType   string   // key type of generated server certificates
+	LeafCertCache int      // max number of generated server certificates cached in cert path, 0 - disable
+	WildcardCerts bool     // issue wildcard server certificates like *.example.com, shared by subdomains
+	Ca            string   // ca backend: file, memory, env[:<name>], trusted:<dir>, signer:<url>. Default: file
+	CertPortal    bool     // serve certificate installation page at http://mitm.it and the proxy address
+
+	// server replay
+	ServerReplay             []string // flow or HAR filenames of recorded flows
+	ServerReplayUnmatched    string   // unmatched requests: pass, 404 or kill. Default: pass
+	ServerReplayIgnoreParams []string // query params not matched
+	ServerReplayHeaders      []string // request headers matched
+	ServerReplayIgnoreBody   bool     // not match request body
+
+	// options of newly created CA
+	CaSubject          string   // CA subject, e.g. CN=mitmproxy,O=mitmproxy
+	CaValidityDays     int      // CA validity in days
+	CaKeyType          string   // CA key type
+	CaPermittedDomains []string // name constraints, CA can only sign these domains and their subdomains
+	CaPassword         string   // password of encrypted CA private key in cert path
 
 	filename string // read config from the filename
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "cert" {
+		runCertCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay-client" {
+		runReplayClient(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		runHistory(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExport(os.Args[2:])
+		return
+	}
+
 	config := loadConfig()
 
 	if config.Debug > 0 {
@@ -51,13 +115,43 @@ func main() {
 		FullTimestamp: true,
 	})
 
+	var clientCerts []*proxy.ClientCert
+	if config.ClientCerts != "" {
+		if err := helper.NewStructFromFile(config.ClientCerts, &clientCerts); err != nil {
+			log.Fatalf("load client certs error: %v", err)
+		}
+	}
+
+	var certPins []*proxy.CertPin
+	if config.CertPins != "" {
+		if err := helper.NewStructFromFile(config.CertPins, &certPins); err != nil {
+			log.Fatalf("load cert pins error: %v", err)
+		}
+	}
+
+	certOpts, err := newCertOptions(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	caFunc, err := newCaFunc(config, certOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	opts := &proxy.Options{
 		Debug:             config.Debug,
 		Addr:              config.Addr,
 		StreamLargeBodies: 1024 * 1024 * 5,
 		SslInsecure:       config.SslInsecure,
 		CaRootPath:        config.CertPath,
+		NewCaFunc:         caFunc,
+		CertOptions:       certOpts,
 		Upstream:          config.Upstream,
+		ClientCerts:       clientCerts,
+		CaBundle:          config.CaBundle,
+		CertPins:          certPins,
+		TlsKeyLogFile:     config.SslKeyLogFile,
 	}
 
 	p, err := proxy.NewProxy(opts)
@@ -89,7 +183,87 @@ func main() {
 	}
 
 	p.AddAddon(&proxy.LogAddon{})
-	p.AddAddon(web.NewWebAddon(config.WebAddr))
+	if config.CertPortal {
+		p.AddAddon(addon.NewCertPortal(p))
+	}
+	webAddon := web.NewWebAddon(config.WebAddr)
+	p.AddAddon(webAddon)
+
+	flowStore, err := addon.NewFlowStore(config.StoreSpill)
+	if err != nil {
+		log.Fatalf("open flow store spill file error: %v", err)
+	}
+	if config.StoreFlows > 0 {
+		flowStore.MaxFlows = config.StoreFlows
+	}
+	if config.StoreSize > 0 {
+		flowStore.MaxBytes = int64(config.StoreSize) * 1024 * 1024
+	}
+	if config.StoreSpillMax > 0 {
+		flowStore.MaxSpillBytes = int64(config.StoreSpillMax) * 1024 * 1024
+	}
+	p.AddAddon(flowStore)
+	webAddon.SetFlowStore(flowStore)
+
+	if config.PcapConns > 0 {
+		pcapExporter := addon.NewPcapExporter(config.PcapConns)
+		if config.PcapSize > 0 {
+			pcapExporter.MaxBytes = config.PcapSize * 1024 * 1024
+		}
+		p.AddAddon(pcapExporter)
+		webAddon.Handle("/export/pcapng", pcapExporter)
+	}
+
+	if config.HistoryDb != "" {
+		historyDb, err := openHistoryDb(config)
+		if err != nil {
+			log.Fatalf("open history database error: %v", err)
+		}
+		p.AddAddon(addon.NewFlowRecorder(func(f *proxy.Flow) {
+			if err := historyDb.Add(f); err != nil {
+				log.Errorf("history add flow error: %v", err)
+			}
+		}))
+		webAddon.Handle("/api/history", historyDb)
+		webAddon.Handle("/api/history/", historyDb)
+	}
+
+	if config.Har != "" {
+		harWriter, err := addon.NewHarWriter(config.Har)
+		if err != nil {
+			log.Fatalf("open har file error: %v", err)
+		}
+		p.AddAddon(harWriter)
+		webAddon.Handle("/export/har", harWriter)
+	}
+
+	if config.SaveStream != "" {
+		saveStream, err := addon.NewSaveStream(config.SaveStream)
+		if err != nil {
+			log.Fatalf("open save stream file error: %v", err)
+		}
+		p.AddAddon(saveStream)
+	}
+
+	if config.Warc != "" {
+		warcWriter, err := addon.NewWarcWriter(config.Warc)
+		if err != nil {
+			log.Fatalf("open warc file error: %v", err)
+		}
+		if config.WarcMaxSize > 0 {
+			warcWriter.MaxFileSize = int64(config.WarcMaxSize) * 1024 * 1024
+		}
+		p.AddAddon(warcWriter)
+	}
+
+	if config.ReadFlows != "" {
+		flows, err := addon.ReadFlowsFile(config.ReadFlows)
+		if err != nil {
+			log.Fatalf("read flows error: %v", err)
+		}
+		webAddon.AddFlows(flows)
+		log.Infof("read %v flows from %v", len(flows), config.ReadFlows)
+	}
 
 	if config.MapRemote != "" {
 		mapRemote, err := addon.NewMapRemoteFromFile(config.MapRemote)
@@ -110,9 +284,157 @@ func main() {
 	}
 
 	if config.Dump != "" {
-		dumper := addon.NewDumperWithFilename(config.Dump, config.DumpLevel)
+		dumper, err := newDumper(config)
+		if err != nil {
+			log.Fatalf("open dump file error: %v", err)
+		}
 		p.AddAddon(dumper)
+		// flush the last writes and wait for compressing of rotated files
+		p.RegisterOnShutdown(func() {
+			if err := dumper.Close(); err != nil {
+				log.Warnf("close dump file error: %v", err)
+			}
+		})
+	}
+
+	// last one, addons after it are skipped for replayed flows
+	if len(config.ServerReplay) > 0 {
+		serverReplay, err := newServerReplay(config)
+		if err != nil {
+			log.Fatalf("load server replay error: %v", err)
+		}
+		p.AddAddon(serverReplay)
 	}
 
 	p.Start()
 }
+
+func newDumper(config *Config) (*addon.Dumper, error) {
+	opts := &addon.DumperOptions{
+		Filename:      config.Dump,
+		Level:         config.DumpLevel,
+		Format:        config.DumpFormat,
+		Filter:        config.DumpFilter,
+		RedactHeaders: config.DumpRedact,
+		MaxSize:       int64(config.DumpMaxSize) * 1024 * 1024,
+	}
+	if config.DumpRotate != "" {
+		interval, err := time.ParseDuration(config.DumpRotate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dump rotate interval: %w", err)
+		}
+		opts.RotateInterval = interval
+	}
+	return addon.NewDumperWithOptions(opts)
+}
+
+func openHistoryDb(config *Config) (*flowdb.DB, error) {
+	opts := &flowdb.Options{MaxFlows: config.HistoryFlows}
+	if config.HistoryMaxAge != "" {
+		maxAge, err := time.ParseDuration(config.HistoryMaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid history max age: %w", err)
+		}
+		opts.MaxAge = maxAge
+	}
+	return flowdb.Open(config.HistoryDb, opts)
+}
+
+func newServerReplay(config *Config) (*addon.ServerReplay, error) {
+	serverReplay, err := addon.NewServerReplayFromFiles(config.ServerReplay)
+	if err != nil {
+		return nil, err
+	}
+	switch config.ServerReplayUnmatched {
+	case "":
+	case addon.ServerReplayPass, addon.ServerReplayNotFound, addon.ServerReplayKill:
+		serverReplay.Unmatched = config.ServerReplayUnmatched
+	default:
+		return nil, fmt.Errorf("invalid server_replay_unmatched %v", config.ServerReplayUnmatched)
+	}
+	serverReplay.IgnoreParams = config.ServerReplayIgnoreParams
+	serverReplay.MatchHeaders = config.ServerReplayHeaders
+	serverReplay.IgnoreBody = config.ServerReplayIgnoreBody
+	return serverReplay, nil
+}
+
+// defaultCaEnv environment variable of -ca env, password of encrypted private key is read from MITMPROXY_CA_PASSWORD
+const defaultCaEnv = "MITMPROXY_CA"
+
+// newCaFunc returns nil for the default file ca
+func newCaFunc(config *Config, certOpts *cert.Options) (func() (cert.CA, error), error) {
+	backend, arg, _ := strings.Cut(config.Ca, ":")
+	switch backend {
+	case "", "file":
+		return nil, nil
+	case "memory":
+		return func() (cert.CA, error) {
+			return cert.NewSelfSignCAMemoryWithOptions(certOpts)
+		}, nil
+	case "env":
+		name := arg
+		if name == "" {
+			name = defaultCaEnv
+		}
+		data := os.Getenv(name)
+		if data == "" {
+			return nil, fmt.Errorf("ca env requires PEM private key and certificates in environment variable %v", name)
+		}
+		password := os.Getenv(name + "_PASSWORD")
+		return func() (cert.CA, error) {
+			return cert.NewSelfSignCAFromPEM([]byte(data), password, certOpts)
+		}, nil
+	case "trusted":
+		if arg == "" {
+			return nil, errors.New("ca trusted requires a directory, e.g. trusted:/path/to/certs")
+		}
+		return func() (cert.CA, error) {
+			return cert.NewTrustedCA(arg)
+		}, nil
+	case "signer":
+		if arg == "" {
+			return nil, errors.New("ca signer requires an url, e.g. signer:http://127.0.0.1:9443")
+		}
+		return func() (cert.CA, error) {
+			return cert.NewSelfSignCAFromSigner(cert.NewHTTPSigner(arg), certOpts)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown ca backend %v", config.Ca)
+	}
+}
+
+func newCertOptions(config *Config) (*cert.Options, error) {
+	certOpts := cert.DefaultOptions()
+	certOpts.LeafCertCacheSize = config.LeafCertCache
+	certOpts.WildcardLeafCerts = config.WildcardCerts
+
+	leafKeyType, err := cert.ParseKeyType(config.LeafKeyType)
+	if err != nil {
+		return nil, err
+	}
+	if leafKeyType != "" {
+		certOpts.LeafKeyType = leafKeyType
+	}
+
+	caKeyType, err := cert.ParseKeyType(config.CaKeyType)
+	if err != nil {
+		return nil, err
+	}
+	if caKeyType != "" {
+		certOpts.CaKeyType = caKeyType
+	}
+
+	if config.CaSubject != "" {
+		subject, err := cert.ParseSubject(config.CaSubject)
+		if err != nil {
+			return nil, err
+		}
+		certOpts.CaSubject = subject
+	}
+	if config.CaValidityDays > 0 {
+		certOpts.CaValidity = time.Hour * 24 * time.Duration(config.CaValidityDays)
+	}
+	certOpts.PermittedDNSDomains = config.CaPermittedDomains
+	certOpts.CaPassword = config.CaPassword
+	return certOpts, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/addon"
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// go-mitmproxy replay-client [flags] <file>...
+
+const replayClientUsage = `Usage: go-mitmproxy replay-client [flags] <file>...
+
+Send the requests in flow files or HAR files again, through addons and upstream like flows from clients.
+
+Flags:
+`
+
+func runReplayClient(args []string) {
+	fs := flag.NewFlagSet("replay-client", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), replayClientUsage)
+		fs.PrintDefaults()
+	}
+	config := new(Config)
+	fs.BoolVar(&config.SslInsecure, "ssl_insecure", false, "not verify upstream server SSL/TLS certificates.")
+	fs.StringVar(&config.Upstream, "upstream", "", "upstream proxy")
+	fs.StringVar(&config.MapRemote, "map_remote", "", "map remote config filename")
+	fs.StringVar(&config.MapLocal, "map_local", "", "map local config filename")
+	out := fs.String("out", "", "write replayed flows to the HAR filename, for diffing runs")
+	concurrency := fs.Int("concurrency", 1, "number of requests in flight")
+	delay := fs.Duration("delay", 0, "interval between starting two requests, e.g. 100ms")
+	ordered := fs.Bool("ordered", true, "output flows in the order of requests instead of the order of completion")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	reqs := make([]*proxy.Request, 0)
+	for _, filename := range fs.Args() {
+		flows, err := addon.ReadFlowsFile(filename)
+		if err != nil {
+			log.Fatalf("load %v error: %v", filename, err)
+		}
+		for _, f := range flows {
+			reqs = append(reqs, f.Request)
+		}
+	}
+
+	p, err := proxy.NewProxy(&proxy.Options{
+		StreamLargeBodies: 1024 * 1024 * 5,
+		SslInsecure:       config.SslInsecure,
+		Upstream:          config.Upstream,
+		// no client connects to the replay client, the ca is never used
+		NewCaFunc: func() (cert.CA, error) {
+			return cert.NewSelfSignCAMemory()
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if config.MapRemote != "" {
+		mapRemote, err := addon.NewMapRemoteFromFile(config.MapRemote)
+		if err != nil {
+			log.Fatalf("load map remote error: %v", err)
+		}
+		p.AddAddon(mapRemote)
+	}
+	if config.MapLocal != "" {
+		mapLocal, err := addon.NewMapLocalFromFile(config.MapLocal)
+		if err != nil {
+			log.Fatalf("load map local error: %v", err)
+		}
+		p.AddAddon(mapLocal)
+	}
+	var harWriter *addon.HarWriter
+	if *out != "" {
+		harWriter, err = addon.NewHarWriter(*out)
+		if err != nil {
+			log.Fatalf("open har file error: %v", err)
+		}
+		harWriter.MaxEntries = 0
+		harWriter.WriteOnDone = false
+		p.AddAddon(harWriter)
+	}
+
+	start := time.Now()
+	failed := 0
+	opts := &proxy.ReplayOptions{
+		Concurrency: *concurrency,
+		Delay:       *delay,
+		Ordered:     *ordered,
+	}
+	p.Replay(reqs, opts, func(f *proxy.Flow) {
+		if harWriter != nil {
+			harWriter.AddFlow(f)
+		}
+		if f.Error != nil {
+			failed++
+			fmt.Printf("%v %v %v - %v\n", f.Response.StatusCode, f.Request.Method, f.Request.URL, f.Error)
+			return
+		}
+		fmt.Printf("%v %v %v %v\n", f.Response.StatusCode, f.Request.Method, f.Request.URL, len(f.Response.Body))
+	})
+	if harWriter != nil {
+		harWriter.Close()
+	}
+	fmt.Printf("replayed %v requests in %v, %v failed\n", len(reqs), time.Since(start).Round(time.Millisecond), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
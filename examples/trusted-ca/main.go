@@ -4,6 +4,7 @@ import (
 	"net"
 	"net/http"
 
+	"github.com/lqqyt2423/go-mitmproxy/cert"
 	"github.com/lqqyt2423/go-mitmproxy/log"
 	"github.com/lqqyt2423/go-mitmproxy/proxy"
 )
@@ -12,7 +13,10 @@ func main() {
 	opts := &proxy.Options{
 		Addr:              ":8081",
 		StreamLargeBodies: 1024 * 1024 * 5,
-		NewCaFunc:         NewTrustedCA, // use custom cert
+		NewCaFunc: func() (cert.CA, error) {
+			// certificates and private keys of your domains, e.g. your-domain.xx.com.crt + your-domain.xx.com.key
+			return cert.NewTrustedCA("certs")
+		},
 	}
 	p, err := proxy.NewProxy(opts)
 	if err != nil {
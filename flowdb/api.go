@@ -0,0 +1,176 @@
+package flowdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// REST api, query keys are described in ParseQuery:
+//
+//   GET    /api/history?host=example.com&status=5xx&since=yesterday&until=today   records matched, the newest first
+//   GET    /api/history/export?host=example.com                                  download matched flows as flow file
+//   GET    /api/history/{id}                                                     one flow with base64 bodies
+//   DELETE /api/history/{id}                                                     delete one flow
+
+func (d *DB) initMux() {
+	d.mux = http.NewServeMux()
+	d.mux.HandleFunc("GET /api/history", d.serveQuery)
+	d.mux.HandleFunc("GET /api/history/export", d.serveExport)
+	d.mux.HandleFunc("GET /api/history/{id}", d.serveGet)
+	d.mux.HandleFunc("DELETE /api/history/{id}", d.serveDelete)
+}
+
+func (d *DB) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	d.mux.ServeHTTP(res, req)
+}
+
+func (d *DB) serveQuery(res http.ResponseWriter, req *http.Request) {
+	q, err := ParseQuery(req.URL.Query())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	total, err := d.Count(q)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	records, err := d.Query(q)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(res, map[string]interface{}{
+		"total":  total,
+		"offset": q.Offset,
+		"flows":  records,
+	})
+}
+
+func (d *DB) serveExport(res http.ResponseWriter, req *http.Request) {
+	q, err := ParseQuery(req.URL.Query())
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res.Header().Set("Content-Type", "application/octet-stream")
+	res.Header().Set("Content-Disposition", `attachment; filename="go-mitmproxy-history.flows"`)
+	w := proxy.NewFlowWriter(res)
+	if err := d.Each(q, w.Write); err != nil {
+		log.Errorf("flowdb export error: %v", err)
+	}
+}
+
+type detailRequest struct {
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	Proto     string      `json:"proto"`
+	Header    http.Header `json:"header"`
+	Body      []byte      `json:"body"`
+	Size      int         `json:"size"` // real size, larger than body when truncated
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+type detailResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	Size       int         `json:"size"`
+	Truncated  bool        `json:"truncated,omitempty"`
+}
+
+// detail one flow with bodies
+type detail struct {
+	Id           uuid.UUID       `json:"id"`
+	Request      detailRequest   `json:"request"`
+	Response     *detailResponse `json:"response"`
+	StartTime    time.Time       `json:"startTime"`
+	ResponseTime time.Time       `json:"responseTime"`
+	EndTime      time.Time       `json:"endTime"`
+	Error        string          `json:"error,omitempty"`
+}
+
+func newDetail(f *proxy.Flow) *detail {
+	dt := &detail{
+		Id: f.Id,
+		Request: detailRequest{
+			Method:    f.Request.Method,
+			URL:       f.Request.URL.String(),
+			Proto:     f.Request.Proto,
+			Header:    f.Request.Header,
+			Body:      f.Request.Body,
+			Size:      len(f.Request.Body),
+			Truncated: f.Request.BodyTruncated,
+		},
+		StartTime:    f.StartTime,
+		ResponseTime: f.ResponseTime,
+		EndTime:      f.EndTime,
+	}
+	if f.Request.BodyTruncated {
+		dt.Request.Size = f.Request.BodySize
+	}
+	if f.Response != nil {
+		dt.Response = &detailResponse{
+			StatusCode: f.Response.StatusCode,
+			Header:     f.Response.Header,
+			Body:       f.Response.Body,
+			Size:       len(f.Response.Body),
+			Truncated:  f.Response.BodyTruncated,
+		}
+		if f.Response.BodyTruncated {
+			dt.Response.Size = f.Response.BodySize
+		}
+	}
+	if f.Error != nil {
+		dt.Error = f.Error.Error()
+	}
+	return dt
+}
+
+func (d *DB) serveGet(res http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		http.Error(res, "invalid flow id", http.StatusBadRequest)
+		return
+	}
+	f, err := d.Get(id)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if f == nil {
+		http.NotFound(res, req)
+		return
+	}
+	writeJSON(res, newDetail(f))
+}
+
+func (d *DB) serveDelete(res http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		http.Error(res, "invalid flow id", http.StatusBadRequest)
+		return
+	}
+	ok, err := d.Delete(id)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(res, req)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(res http.ResponseWriter, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(v); err != nil {
+		log.Errorf("flowdb write response error: %v", err)
+	}
+}
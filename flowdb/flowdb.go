@@ -0,0 +1,344 @@
+package flowdb
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	_ "modernc.org/sqlite" // pure go sqlite driver, no cgo
+)
+
+// flow history in an embedded SQLite database, for running the proxy for days and querying flows later.
+// Flows are stored as records of the go-mitmproxy flow file, with columns indexed for queries.
+
+const schema = `
+CREATE TABLE IF NOT EXISTS flows (
+	id                 TEXT PRIMARY KEY,
+	start_time         INTEGER NOT NULL,           -- unix microseconds
+	end_time           INTEGER NOT NULL,
+	method             TEXT NOT NULL,
+	scheme             TEXT NOT NULL,
+	host               TEXT NOT NULL,              -- lower case, without port
+	path               TEXT NOT NULL,
+	url                TEXT NOT NULL,
+	status             INTEGER NOT NULL,           -- 0 without response
+	content_type       TEXT NOT NULL,              -- media type of response, without parameters
+	request_size       INTEGER NOT NULL,           -- real size, larger than the stored body when truncated
+	response_size      INTEGER NOT NULL,
+	error              TEXT NOT NULL,
+	data               BLOB NOT NULL,              -- flow file record
+	request_truncated  INTEGER NOT NULL DEFAULT 0, -- 1 when the stored body is cut
+	response_truncated INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS flows_start_time ON flows (start_time);
+CREATE INDEX IF NOT EXISTS flows_host ON flows (host, start_time);
+CREATE INDEX IF NOT EXISTS flows_path ON flows (path);
+CREATE INDEX IF NOT EXISTS flows_status ON flows (status, start_time);
+CREATE INDEX IF NOT EXISTS flows_method ON flows (method, start_time);
+CREATE INDEX IF NOT EXISTS flows_content_type ON flows (content_type, start_time);
+`
+
+const recordColumns = "id, start_time, end_time, method, host, path, url, status, content_type, request_size, response_size, request_truncated, response_truncated, error"
+
+// columns added after the first schema, added to existing database files by Open
+var addedColumns = map[string]string{
+	"request_truncated":  "INTEGER NOT NULL DEFAULT 0",
+	"response_truncated": "INTEGER NOT NULL DEFAULT 0",
+}
+
+const defaultPruneInterval = time.Minute
+
+type Options struct {
+	MaxAge        time.Duration // flows started earlier are deleted, 0 - keep forever
+	MaxFlows      int           // the oldest flows are deleted when exceeded, 0 - unlimited
+	PruneInterval time.Duration // interval of deleting flows by MaxAge and MaxFlows. Default: 1 minute
+}
+
+type DB struct {
+	opts Options
+	db   *sql.DB
+	mux  *http.ServeMux
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Record indexed columns of a stored flow
+type Record struct {
+	Id                uuid.UUID `json:"id"`
+	StartTime         time.Time `json:"startTime"`
+	EndTime           time.Time `json:"endTime"`
+	Method            string    `json:"method"`
+	Host              string    `json:"host"`
+	Path              string    `json:"path"`
+	URL               string    `json:"url"`
+	StatusCode        int       `json:"statusCode"`
+	ContentType       string    `json:"contentType"`
+	RequestSize       int       `json:"requestSize"` // real size, larger than the stored body when truncated
+	ResponseSize      int       `json:"responseSize"`
+	RequestTruncated  bool      `json:"requestTruncated,omitempty"`
+	ResponseTruncated bool      `json:"responseTruncated,omitempty"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// Open opens or creates the database file, flows are deleted periodically by retention settings of opts, nil opts
+// means keep forever
+func Open(filename string, opts *Options) (*DB, error) {
+	db, err := sql.Open("sqlite", "file:"+filename+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows one writer
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("flowdb create schema: %w", err)
+	}
+	if err := addColumns(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("flowdb add columns: %w", err)
+	}
+
+	d := &DB{db: db, done: make(chan struct{})}
+	if opts != nil {
+		d.opts = *opts
+	}
+	if d.opts.PruneInterval <= 0 {
+		d.opts.PruneInterval = defaultPruneInterval
+	}
+	d.initMux()
+
+	if d.opts.MaxAge > 0 || d.opts.MaxFlows > 0 {
+		go d.pruneLoop()
+	}
+	return d, nil
+}
+
+// addColumns adds columns missing in database files created by earlier versions
+func addColumns(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('flows')`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for name, definition := range addedColumns {
+		if existing[name] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE flows ADD COLUMN ` + name + ` ` + definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DB) pruneLoop() {
+	ticker := time.NewTicker(d.opts.PruneInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := d.Prune(); err != nil {
+			log.Errorf("flowdb prune error: %v", err)
+		} else if n > 0 {
+			log.Debugf("flowdb pruned %v flows", n)
+		}
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *DB) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+	})
+	return d.db.Close()
+}
+
+// Add stores the finished flow, flows with the same id are replaced
+func (d *DB) Add(f *proxy.Flow) error {
+	data := new(bytes.Buffer)
+	if err := proxy.NewFlowWriter(data).Write(f); err != nil {
+		return err
+	}
+
+	r := newRecord(f)
+	_, err := d.db.Exec(`INSERT OR REPLACE INTO flows (`+recordColumns+`, scheme, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Id.String(), r.StartTime.UnixMicro(), r.EndTime.UnixMicro(), r.Method, r.Host, r.Path, r.URL, r.StatusCode,
+		r.ContentType, r.RequestSize, r.ResponseSize, r.RequestTruncated, r.ResponseTruncated, r.Error,
+		f.Request.URL.Scheme, data.Bytes())
+	return err
+}
+
+func newRecord(f *proxy.Flow) *Record {
+	r := &Record{
+		Id:          f.Id,
+		StartTime:   f.StartTime,
+		EndTime:     f.EndTime,
+		Method:      f.Request.Method,
+		Host:        strings.ToLower(f.Request.URL.Hostname()),
+		Path:        f.Request.URL.Path,
+		URL:         f.Request.URL.String(),
+		RequestSize: len(f.Request.Body),
+	}
+	if f.Request.BodyTruncated {
+		r.RequestSize = f.Request.BodySize
+		r.RequestTruncated = true
+	}
+	if f.Response != nil {
+		r.StatusCode = f.Response.StatusCode
+		r.ResponseSize = len(f.Response.Body)
+		if f.Response.BodyTruncated {
+			r.ResponseSize = f.Response.BodySize
+			r.ResponseTruncated = true
+		}
+		if contentType := f.Response.Header.Get("Content-Type"); contentType != "" {
+			if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+				r.ContentType = mediaType
+			} else {
+				r.ContentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+			}
+		}
+	}
+	if f.Error != nil {
+		r.Error = f.Error.Error()
+	}
+	return r
+}
+
+// Get returns the flow with bodies, nil if not found
+func (d *DB) Get(id uuid.UUID) (*proxy.Flow, error) {
+	var data []byte
+	err := d.db.QueryRow(`SELECT data FROM flows WHERE id = ?`, id.String()).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	flows, err := proxy.ReadFlows(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(flows) != 1 {
+		return nil, fmt.Errorf("flowdb: %v flows in record of %v", len(flows), id)
+	}
+	return flows[0], nil
+}
+
+// Query returns records matched, the newest first
+func (d *DB) Query(q *Query) ([]*Record, error) {
+	where, args := q.where()
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	args = append(args, limit, q.Offset)
+	rows, err := d.db.Query(`SELECT `+recordColumns+` FROM flows`+where+` ORDER BY start_time DESC, rowid DESC LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*Record, 0)
+	for rows.Next() {
+		r := new(Record)
+		var id string
+		var start, end int64
+		if err := rows.Scan(&id, &start, &end, &r.Method, &r.Host, &r.Path, &r.URL, &r.StatusCode, &r.ContentType,
+			&r.RequestSize, &r.ResponseSize, &r.RequestTruncated, &r.ResponseTruncated, &r.Error); err != nil {
+			return nil, err
+		}
+		if r.Id, err = uuid.Parse(id); err != nil {
+			return nil, err
+		}
+		r.StartTime = time.UnixMicro(start)
+		r.EndTime = time.UnixMicro(end)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// Each calls fn with flows matched by q, the newest first, stops at the first error
+func (d *DB) Each(q *Query, fn func(f *proxy.Flow) error) error {
+	records, err := d.Query(q)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		f, err := d.Get(r.Id)
+		if err != nil {
+			return err
+		}
+		// deleted after query
+		if f == nil {
+			continue
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Count returns number of flows matched, Limit and Offset of q are ignored
+func (d *DB) Count(q *Query) (int, error) {
+	where, args := q.where()
+	var n int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM flows`+where, args...).Scan(&n)
+	return n, err
+}
+
+// Delete removes the flow, returns false if not found
+func (d *DB) Delete(id uuid.UUID) (bool, error) {
+	res, err := d.db.Exec(`DELETE FROM flows WHERE id = ?`, id.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Prune deletes flows by MaxAge and MaxFlows of options, returns number of flows deleted
+func (d *DB) Prune() (int64, error) {
+	var deleted int64
+	if d.opts.MaxAge > 0 {
+		res, err := d.db.Exec(`DELETE FROM flows WHERE start_time < ?`, time.Now().Add(-d.opts.MaxAge).UnixMicro())
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	if d.opts.MaxFlows > 0 {
+		res, err := d.db.Exec(`DELETE FROM flows WHERE rowid IN (SELECT rowid FROM flows ORDER BY start_time DESC LIMIT -1 OFFSET ?)`, d.opts.MaxFlows)
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
@@ -0,0 +1,177 @@
+package flowdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func newTestFlow(rawurl string, status int, contentType string, start time.Time) *proxy.Flow {
+	u, _ := url.Parse(rawurl)
+	return &proxy.Flow{
+		Id:        uuid.New(),
+		Request:   &proxy.Request{Method: "GET", URL: u, Proto: "HTTP/1.1", Header: make(http.Header)},
+		Response:  &proxy.Response{StatusCode: status, Header: http.Header{"Content-Type": {contentType}}, Body: []byte("body")},
+		StartTime: start,
+		EndTime:   start.Add(time.Millisecond),
+	}
+}
+
+func handleError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "flows.db"), nil)
+	handleError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	yesterday := now.Add(-time.Hour * 24)
+	flows := []*proxy.Flow{
+		newTestFlow("https://Example.com:8443/api/users?id=1", 500, "application/json; charset=utf-8", yesterday),
+		newTestFlow("https://example.com/api/orders", 503, "text/html", yesterday.Add(time.Minute)),
+		newTestFlow("https://example.com/static/a.png", 200, "image/png", yesterday.Add(time.Minute*2)),
+		newTestFlow("https://other.com/api/users", 502, "application/json", yesterday.Add(time.Second*30)),
+		newTestFlow("https://example.com/api/users", 500, "application/json", now),
+	}
+	flows[2].Response.BodyTruncated = true
+	flows[2].Response.BodySize = 1000
+	for _, f := range flows {
+		handleError(t, db.Add(f))
+	}
+
+	for _, c := range []struct {
+		query    string
+		expected []*proxy.Flow
+	}{
+		{"host=example.com&status=5xx&since=" + url.QueryEscape(yesterday.Add(-time.Hour).Format(time.RFC3339)) + "&until=1h", []*proxy.Flow{flows[1], flows[0]}},
+		{"path=/api/&method=get&content_type=application/json", []*proxy.Flow{flows[4], flows[3], flows[0]}},
+		{"content_type=image/", []*proxy.Flow{flows[2]}},
+		{"status=500-502&limit=1&offset=1", []*proxy.Flow{flows[3]}},
+	} {
+		values, _ := url.ParseQuery(c.query)
+		q, err := ParseQuery(values)
+		handleError(t, err)
+		records, err := db.Query(q)
+		handleError(t, err)
+		if len(records) != len(c.expected) {
+			t.Fatalf("%v: expected %v records, but got %v", c.query, len(c.expected), len(records))
+		}
+		for i, r := range records {
+			if r.Id != c.expected[i].Id {
+				t.Fatalf("%v: unexpected record %v %v", c.query, i, r.URL)
+			}
+		}
+	}
+
+	// real size of truncated body
+	records, err := db.Query(&Query{ContentType: "image/"})
+	handleError(t, err)
+	if len(records) != 1 || records[0].ResponseSize != 1000 || !records[0].ResponseTruncated || records[0].RequestTruncated {
+		t.Fatalf("unexpected truncated record %+v", records)
+	}
+
+	f, err := db.Get(flows[0].Id)
+	handleError(t, err)
+	if f == nil || f.Request.URL.String() != flows[0].Request.URL.String() || string(f.Response.Body) != "body" || f.StartTime.UnixMicro() != yesterday.UnixMicro() {
+		t.Fatalf("unexpected flow %+v", f)
+	}
+
+	// api
+	res := httptest.NewRecorder()
+	db.ServeHTTP(res, httptest.NewRequest("GET", "/api/history?host=other.com", nil))
+	var result struct {
+		Total int
+		Flows []*Record
+	}
+	handleError(t, json.Unmarshal(res.Body.Bytes(), &result))
+	if result.Total != 1 || len(result.Flows) != 1 || result.Flows[0].Host != "other.com" || result.Flows[0].ContentType != "application/json" {
+		t.Fatalf("unexpected query result %s", res.Body.Bytes())
+	}
+	res = httptest.NewRecorder()
+	db.ServeHTTP(res, httptest.NewRequest("GET", "/api/history/export?status=200", nil))
+	exported, err := proxy.ReadFlows(res.Body)
+	handleError(t, err)
+	if len(exported) != 1 || exported[0].Id != flows[2].Id {
+		t.Fatalf("unexpected exported flows %v", exported)
+	}
+	res = httptest.NewRecorder()
+	db.ServeHTTP(res, httptest.NewRequest("DELETE", "/api/history/"+flows[2].Id.String(), nil))
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, but got %v", res.Code)
+	}
+	res = httptest.NewRecorder()
+	db.ServeHTTP(res, httptest.NewRequest("GET", "/api/history/"+flows[2].Id.String(), nil))
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, but got %v", res.Code)
+	}
+
+	// retention
+	db.opts.MaxAge = time.Hour
+	n, err := db.Prune()
+	handleError(t, err)
+	if n != 3 {
+		t.Fatalf("expected 3 flows pruned, but got %v", n)
+	}
+}
+
+func TestAddColumns(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "flows.db")
+	old, err := sql.Open("sqlite", "file:"+filename)
+	handleError(t, err)
+	_, err = old.Exec(`CREATE TABLE flows (id TEXT PRIMARY KEY, start_time INTEGER NOT NULL, end_time INTEGER NOT NULL,
+		method TEXT NOT NULL, scheme TEXT NOT NULL, host TEXT NOT NULL, path TEXT NOT NULL, url TEXT NOT NULL,
+		status INTEGER NOT NULL, content_type TEXT NOT NULL, request_size INTEGER NOT NULL, response_size INTEGER NOT NULL,
+		error TEXT NOT NULL, data BLOB NOT NULL)`)
+	handleError(t, err)
+	old.Close()
+
+	db, err := Open(filename, nil)
+	handleError(t, err)
+	defer db.Close()
+	handleError(t, db.Add(newTestFlow("https://example.com/", 200, "text/plain", time.Now())))
+	records, err := db.Query(&Query{})
+	handleError(t, err)
+	if len(records) != 1 || records[0].ResponseSize != 4 || records[0].ResponseTruncated {
+		t.Fatalf("unexpected records %+v", records)
+	}
+}
+
+func TestParseStatusAndTime(t *testing.T) {
+	for s, expected := range map[string][2]int{"404": {404, 404}, "5xx": {500, 599}, "200-299": {200, 299}} {
+		min, max, err := ParseStatus(s)
+		handleError(t, err)
+		if min != expected[0] || max != expected[1] {
+			t.Fatalf("%v: unexpected status range %v - %v", s, min, max)
+		}
+	}
+	if _, _, err := ParseStatus("6xx"); err == nil {
+		t.Fatal("6xx should be invalid")
+	}
+
+	now := time.Date(2024, 5, 2, 10, 30, 0, 0, time.Local)
+	for s, expected := range map[string]time.Time{
+		"yesterday":           time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local),
+		"2h":                  now.Add(-time.Hour * 2),
+		"2024-04-30":          time.Date(2024, 4, 30, 0, 0, 0, 0, time.Local),
+		"2024-04-30 08:00:00": time.Date(2024, 4, 30, 8, 0, 0, 0, time.Local),
+	} {
+		parsed, err := ParseTime(s, now)
+		handleError(t, err)
+		if !parsed.Equal(expected) {
+			t.Fatalf("%v: expected %v, but got %v", s, expected, parsed)
+		}
+	}
+}
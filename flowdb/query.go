@@ -0,0 +1,166 @@
+package flowdb
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 10000
+)
+
+// Query conditions of flows, empty fields match all
+type Query struct {
+	Host        string    // host without port, case insensitive
+	Path        string    // path prefix
+	Method      string    // request method, case insensitive
+	StatusMin   int       // response status at least, e.g. 500
+	StatusMax   int       // response status at most, e.g. 599
+	ContentType string    // media type of response, prefix when ends with /, e.g. image/
+	Since       time.Time // flows started at or after
+	Until       time.Time // flows started before
+	Limit       int       // Default: 100
+	Offset      int
+}
+
+// maxText sorts after all strings starting with a prefix
+const maxText = "\U0010FFFF"
+
+func (q *Query) where() (string, []interface{}) {
+	conds := make([]string, 0)
+	args := make([]interface{}, 0)
+	if q.Host != "" {
+		conds = append(conds, "host = ?")
+		args = append(args, strings.ToLower(q.Host))
+	}
+	if q.Path != "" {
+		conds = append(conds, "path >= ? AND path < ?")
+		args = append(args, q.Path, q.Path+maxText)
+	}
+	if q.Method != "" {
+		conds = append(conds, "method = ?")
+		args = append(args, strings.ToUpper(q.Method))
+	}
+	if q.StatusMin > 0 {
+		conds = append(conds, "status >= ?")
+		args = append(args, q.StatusMin)
+	}
+	if q.StatusMax > 0 {
+		conds = append(conds, "status <= ?")
+		args = append(args, q.StatusMax)
+	}
+	if q.ContentType != "" {
+		contentType := strings.ToLower(q.ContentType)
+		if strings.HasSuffix(contentType, "/") {
+			conds = append(conds, "content_type >= ? AND content_type < ?")
+			args = append(args, contentType, contentType+maxText)
+		} else {
+			conds = append(conds, "content_type = ?")
+			args = append(args, contentType)
+		}
+	}
+	if !q.Since.IsZero() {
+		conds = append(conds, "start_time >= ?")
+		args = append(args, q.Since.UnixMicro())
+	}
+	if !q.Until.IsZero() {
+		conds = append(conds, "start_time < ?")
+		args = append(args, q.Until.UnixMicro())
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// ParseQuery parses query of values, keys: host, path, method, status, content_type, since, until, limit, offset.
+// See ParseStatus and ParseTime for formats of status, since and until.
+func ParseQuery(values url.Values) (*Query, error) {
+	q := &Query{
+		Host:        values.Get("host"),
+		Path:        values.Get("path"),
+		Method:      values.Get("method"),
+		ContentType: values.Get("content_type"),
+	}
+	var err error
+	if v := values.Get("status"); v != "" {
+		if q.StatusMin, q.StatusMax, err = ParseStatus(v); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	if v := values.Get("since"); v != "" {
+		if q.Since, err = ParseTime(v, now); err != nil {
+			return nil, err
+		}
+	}
+	if v := values.Get("until"); v != "" {
+		if q.Until, err = ParseTime(v, now); err != nil {
+			return nil, err
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > MaxQueryLimit {
+			return nil, fmt.Errorf("limit should be 1 - %v", MaxQueryLimit)
+		}
+	}
+	if v := values.Get("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			return nil, fmt.Errorf("invalid offset %v", v)
+		}
+	}
+	return q, nil
+}
+
+// ParseStatus parses status range: 500, 5xx or 500-599
+func ParseStatus(s string) (min int, max int, err error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+		min = int(s[0]-'0') * 100
+		return min, min + 99, nil
+	}
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		min, err1 := strconv.Atoi(from)
+		max, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || min > max {
+			return 0, 0, fmt.Errorf("invalid status range %v", s)
+		}
+		return min, max, nil
+	}
+	status, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status %v", s)
+	}
+	return status, status, nil
+}
+
+// ParseTime parses time in local timezone: RFC3339, 2006-01-02 15:04:05, 2006-01-02, today, yesterday,
+// or duration before now, e.g. 24h
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch s {
+	case "now":
+		return now, nil
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %v", s)
+}
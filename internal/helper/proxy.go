@@ -17,7 +17,7 @@ import (
 
 // GetProxyConn connect proxy
 // ref: http/transport.go dialConn func
-func GetProxyConn(ctx context.Context, proxyUrl *url.URL, address string, sslInsecure bool) (net.Conn, error) {
+func GetProxyConn(ctx context.Context, proxyUrl *url.URL, address string, tlsConfig *tls.Config) (net.Conn, error) {
 	var conn net.Conn
 	if proxyUrl.Scheme == "socks5" {
 		//检测socks5认证信息
@@ -48,11 +48,12 @@ func GetProxyConn(ctx context.Context, proxyUrl *url.URL, address string, sslIns
 		}
 		// 如果代理URL是HTTPS，则进行TLS握手
 		if proxyUrl.Scheme == "https" {
-			tlsConfig := &tls.Config{
-				ServerName:         proxyUrl.Hostname(), // 设置TLS握手的服务器名称
-				InsecureSkipVerify: sslInsecure,
-				// 可以在这里添加其他TLS配置
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{}
+			} else {
+				tlsConfig = tlsConfig.Clone()
 			}
+			tlsConfig.ServerName = proxyUrl.Hostname() // 设置TLS握手的服务器名称
 			// 包装原始连接为TLS连接
 			tlsConn := tls.Client(conn, tlsConfig)
 			// 执行TLS握手
@@ -12,20 +12,24 @@ import (
 var tlsKeyLogWriter io.Writer
 var tlsKeyLogOnce sync.Once
 
+// GetTlsKeyLogWriter 由环境变量 SSLKEYLOGFILE 指定的全局 key log writer
 func GetTlsKeyLogWriter() io.Writer {
 	tlsKeyLogOnce.Do(func() {
 		logfile := os.Getenv("SSLKEYLOGFILE")
 		if logfile == "" {
 			return
 		}
-
-		writer, err := os.OpenFile(logfile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		writer, err := NewTlsKeyLogWriter(logfile)
 		if err != nil {
 			log.Debugf("getTlsKeyLogWriter OpenFile error: %v", err)
 			return
 		}
-
 		tlsKeyLogWriter = writer
 	})
 	return tlsKeyLogWriter
 }
+
+// NewTlsKeyLogWriter 以 NSS key log 格式追加写入文件
+func NewTlsKeyLogWriter(filename string) (io.Writer, error) {
+	return os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+}
@@ -0,0 +1,100 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// reference
+// https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html
+
+const (
+	blockTypeSectionHeader     uint32 = 0x0A0D0D0A
+	blockTypeInterface         uint32 = 0x00000001
+	blockTypeEnhancedPacket    uint32 = 0x00000006
+	blockTypeDecryptionSecrets uint32 = 0x0000000A
+
+	byteOrderMagic uint32 = 0x1A2B3C4D
+
+	// LinkTypeRaw raw IPv4 or IPv6 packets, no link layer header
+	LinkTypeRaw uint16 = 101
+
+	// SecretsTypeTLSKeyLog NSS key log format
+	SecretsTypeTLSKeyLog uint32 = 0x544c534b
+)
+
+var le = binary.LittleEndian
+
+// Writer writes blocks of one pcapng section
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+// NewWriter writes the section header block, then returns the Writer
+func NewWriter(w io.Writer) (*Writer, error) {
+	pw := &Writer{w: w}
+	body := make([]byte, 16)
+	le.PutUint32(body[0:], byteOrderMagic)
+	le.PutUint16(body[4:], 1) // major version
+	le.PutUint16(body[6:], 0) // minor version
+	le.PutUint64(body[8:], 0xFFFFFFFFFFFFFFFF)
+	pw.writeBlock(blockTypeSectionHeader, body)
+	return pw, pw.err
+}
+
+// WriteInterface writes an interface description block, interface ids start from 0 in written order
+func (pw *Writer) WriteInterface(linkType uint16) error {
+	body := make([]byte, 8)
+	le.PutUint16(body[0:], linkType)
+	le.PutUint32(body[4:], 0) // no snap length limit
+	pw.writeBlock(blockTypeInterface, body)
+	return pw.err
+}
+
+// WriteDecryptionSecrets writes a decryption secrets block, should be written before the packets it decrypts
+func (pw *Writer) WriteDecryptionSecrets(secretsType uint32, secrets []byte) error {
+	body := make([]byte, 8, 8+len(secrets)+3)
+	le.PutUint32(body[0:], secretsType)
+	le.PutUint32(body[4:], uint32(len(secrets)))
+	body = append(body, secrets...)
+	pw.writeBlock(blockTypeDecryptionSecrets, pad(body))
+	return pw.err
+}
+
+// WritePacket writes an enhanced packet block, timestamp in microseconds
+func (pw *Writer) WritePacket(interfaceId uint32, ts time.Time, data []byte) error {
+	body := make([]byte, 20, 20+len(data)+3)
+	micros := uint64(ts.UnixMicro())
+	le.PutUint32(body[0:], interfaceId)
+	le.PutUint32(body[4:], uint32(micros>>32))
+	le.PutUint32(body[8:], uint32(micros))
+	le.PutUint32(body[12:], uint32(len(data)))
+	le.PutUint32(body[16:], uint32(len(data)))
+	body = append(body, data...)
+	pw.writeBlock(blockTypeEnhancedPacket, pad(body))
+	return pw.err
+}
+
+// block: type 4 byte + total length 4 byte + body + total length 4 byte
+func (pw *Writer) writeBlock(blockType uint32, body []byte) {
+	if pw.err != nil {
+		return
+	}
+	total := uint32(12 + len(body))
+	buf := make([]byte, 0, total)
+	buf = le.AppendUint32(buf, blockType)
+	buf = le.AppendUint32(buf, total)
+	buf = append(buf, body...)
+	buf = le.AppendUint32(buf, total)
+	_, pw.err = pw.w.Write(buf)
+}
+
+// pad to 32 bits
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
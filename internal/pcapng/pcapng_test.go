@@ -0,0 +1,86 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	pw, err := NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.WriteDecryptionSecrets(SecretsTypeTLSKeyLog, []byte("CLIENT_RANDOM 00 11\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.WriteInterface(LinkTypeRaw); err != nil {
+		t.Fatal(err)
+	}
+	stream := NewTCPStream(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443})
+	for _, p := range stream.Handshake() {
+		if err := pw.WritePacket(0, time.Now(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	types := make([]uint32, 0)
+	data := buf.Bytes()
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("invalid block, left %d bytes", len(data))
+		}
+		blockType := binary.LittleEndian.Uint32(data[0:])
+		total := binary.LittleEndian.Uint32(data[4:])
+		if total%4 != 0 || int(total) > len(data) {
+			t.Fatalf("invalid block total length %d", total)
+		}
+		if binary.LittleEndian.Uint32(data[total-4:]) != total {
+			t.Fatal("trailing block total length should equal")
+		}
+		types = append(types, blockType)
+		data = data[total:]
+	}
+	want := []uint32{blockTypeSectionHeader, blockTypeDecryptionSecrets, blockTypeInterface, blockTypeEnhancedPacket, blockTypeEnhancedPacket, blockTypeEnhancedPacket}
+	if len(types) != len(want) {
+		t.Fatalf("expected %v blocks, but got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("expected %v blocks, but got %v", want, types)
+		}
+	}
+}
+
+func TestTCPStream(t *testing.T) {
+	client := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 50000}
+	server := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 443}
+	stream := NewTCPStream(client, server)
+	stream.Handshake()
+
+	packets := stream.Data(true, make([]byte, maxSegmentSize+10))
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 segments, but got %d", len(packets))
+	}
+	p := packets[0]
+	if p[0]>>4 != 4 {
+		t.Fatal("should be ipv4 packet")
+	}
+	if checksum(p[:20], 0) != 0 {
+		t.Fatal("ipv4 header checksum invalid")
+	}
+	if binary.BigEndian.Uint32(p[24:]) != 1001 {
+		t.Fatalf("expected seq 1001, but got %d", binary.BigEndian.Uint32(p[24:]))
+	}
+	if binary.BigEndian.Uint32(packets[1][24:]) != 1001+maxSegmentSize {
+		t.Fatal("seq of second segment should follow the first")
+	}
+
+	v6 := NewTCPStream(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 50000}, server)
+	if v6.Handshake()[0][0]>>4 != 6 {
+		t.Fatal("should be ipv6 packet")
+	}
+}
@@ -0,0 +1,161 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	tcpFin = 0x01
+	tcpSyn = 0x02
+	tcpPsh = 0x08
+	tcpAck = 0x10
+
+	maxSegmentSize = 16 * 1024
+)
+
+var be = binary.BigEndian
+
+// TCPStream synthesizes raw IP packets of one tcp connection from the bytes seen on both sides
+type TCPStream struct {
+	addrs [2]*net.TCPAddr // 0: client, 1: server
+	seqs  [2]uint32       // next sequence number sent by each side
+	v6    bool
+	ipId  uint16
+}
+
+// NewTCPStream client and server should not be nil
+func NewTCPStream(client, server *net.TCPAddr) *TCPStream {
+	s := &TCPStream{
+		addrs: [2]*net.TCPAddr{client, server},
+		seqs:  [2]uint32{1000, 5000},
+	}
+	s.v6 = client.IP.To4() == nil || server.IP.To4() == nil
+	return s
+}
+
+// Handshake returns the three way handshake packets
+func (s *TCPStream) Handshake() [][]byte {
+	packets := make([][]byte, 0, 3)
+	packets = append(packets, s.segment(0, tcpSyn, nil))
+	s.seqs[0]++
+	packets = append(packets, s.segment(1, tcpSyn|tcpAck, nil))
+	s.seqs[1]++
+	packets = append(packets, s.segment(0, tcpAck, nil))
+	return packets
+}
+
+// Data returns packets carrying payload, fromClient indicates the direction
+func (s *TCPStream) Data(fromClient bool, payload []byte) [][]byte {
+	side := s.side(fromClient)
+	packets := make([][]byte, 0, len(payload)/maxSegmentSize+1)
+	for len(payload) > 0 {
+		n := len(payload)
+		if n > maxSegmentSize {
+			n = maxSegmentSize
+		}
+		packets = append(packets, s.segment(side, tcpPsh|tcpAck, payload[:n]))
+		s.seqs[side] += uint32(n)
+		payload = payload[n:]
+	}
+	return packets
+}
+
+// Close returns the FIN packet of one side and the ack of the other side
+func (s *TCPStream) Close(fromClient bool) [][]byte {
+	side := s.side(fromClient)
+	packets := make([][]byte, 0, 2)
+	packets = append(packets, s.segment(side, tcpFin|tcpAck, nil))
+	s.seqs[side]++
+	packets = append(packets, s.segment(1-side, tcpAck, nil))
+	return packets
+}
+
+func (s *TCPStream) side(fromClient bool) int {
+	if fromClient {
+		return 0
+	}
+	return 1
+}
+
+func (s *TCPStream) segment(side int, flags byte, payload []byte) []byte {
+	src, dst := s.addrs[side], s.addrs[1-side]
+
+	tcp := make([]byte, 20, 20+len(payload))
+	be.PutUint16(tcp[0:], uint16(src.Port))
+	be.PutUint16(tcp[2:], uint16(dst.Port))
+	be.PutUint32(tcp[4:], s.seqs[side])
+	if flags&tcpAck != 0 {
+		be.PutUint32(tcp[8:], s.seqs[1-side])
+	}
+	tcp[12] = 5 << 4 // data offset
+	tcp[13] = flags
+	be.PutUint16(tcp[14:], 65535) // window
+	tcp = append(tcp, payload...)
+
+	if s.v6 {
+		return s.ipv6Packet(src.IP.To16(), dst.IP.To16(), tcp)
+	}
+	return s.ipv4Packet(src.IP.To4(), dst.IP.To4(), tcp)
+}
+
+func (s *TCPStream) ipv4Packet(src, dst net.IP, tcp []byte) []byte {
+	s.ipId++
+	ip := make([]byte, 20, 20+len(tcp))
+	ip[0] = 0x45 // version 4, header length 20
+	be.PutUint16(ip[2:], uint16(20+len(tcp)))
+	be.PutUint16(ip[4:], s.ipId)
+	be.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64                   // ttl
+	ip[9] = 6                    // tcp
+	copy(ip[12:16], src)
+	copy(ip[16:20], dst)
+	be.PutUint16(ip[10:], checksum(ip, 0))
+
+	pseudo := make([]byte, 0, 12)
+	pseudo = append(pseudo, src...)
+	pseudo = append(pseudo, dst...)
+	pseudo = append(pseudo, 0, 6)
+	pseudo = be.AppendUint16(pseudo, uint16(len(tcp)))
+	be.PutUint16(tcp[16:], checksum(tcp, sum(pseudo)))
+
+	return append(ip, tcp...)
+}
+
+func (s *TCPStream) ipv6Packet(src, dst net.IP, tcp []byte) []byte {
+	ip := make([]byte, 40, 40+len(tcp))
+	ip[0] = 0x60 // version 6
+	be.PutUint16(ip[4:], uint16(len(tcp)))
+	ip[6] = 6  // next header: tcp
+	ip[7] = 64 // hop limit
+	copy(ip[8:24], src)
+	copy(ip[24:40], dst)
+
+	pseudo := make([]byte, 0, 40)
+	pseudo = append(pseudo, src...)
+	pseudo = append(pseudo, dst...)
+	pseudo = be.AppendUint32(pseudo, uint32(len(tcp)))
+	pseudo = append(pseudo, 0, 0, 0, 6)
+	be.PutUint16(tcp[16:], checksum(tcp, sum(pseudo)))
+
+	return append(ip, tcp...)
+}
+
+func sum(b []byte) uint32 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(be.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	return s
+}
+
+func checksum(b []byte, initial uint32) uint16 {
+	s := initial + sum(b)
+	for s > 0xffff {
+		s = (s >> 16) + (s & 0xffff)
+	}
+	return ^uint16(s)
+}
@@ -0,0 +1,252 @@
+package tnetstring
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// tnetstring as used by mitmproxy flow files
+// reference
+// https://tnetstrings.info/
+// https://github.com/mitmproxy/mitmproxy/blob/main/mitmproxy/io/tnetstring.py
+//
+// value:  length ":" payload type
+// types:  "," bytes, ";" unicode string, "#" int, "^" float, "!" bool, "~" null, "]" list, "}" dict
+//
+// go values:
+//   []byte, string, int64, float64, bool, nil, []interface{}, map[string]interface{}
+// dict keys are decoded to string, both bytes and unicode string keys are accepted
+
+const (
+	maxLengthDigits = 10
+	maxLength       = 1<<31 - 1
+)
+
+var ErrInvalid = errors.New("invalid tnetstring")
+
+// Marshal encodes v, accepted types: []byte, string, integers, float32, float64, bool, nil, []interface{}, [][]byte,
+// []string, map[string]interface{}. Dict keys are encoded as unicode strings in sorted order.
+func Marshal(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := encode(buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("0:~")
+	case []byte:
+		writeItem(buf, v, ',')
+	case string:
+		writeItem(buf, []byte(v), ';')
+	case bool:
+		writeItem(buf, []byte(strconv.FormatBool(v)), '!')
+	case int:
+		writeItem(buf, []byte(strconv.FormatInt(int64(v), 10)), '#')
+	case int64:
+		writeItem(buf, []byte(strconv.FormatInt(v, 10)), '#')
+	case int32:
+		writeItem(buf, []byte(strconv.FormatInt(int64(v), 10)), '#')
+	case uint16:
+		writeItem(buf, []byte(strconv.FormatUint(uint64(v), 10)), '#')
+	case uint32:
+		writeItem(buf, []byte(strconv.FormatUint(uint64(v), 10)), '#')
+	case float32:
+		return encode(buf, float64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("tnetstring: unsupported float %v", v)
+		}
+		writeItem(buf, []byte(strconv.FormatFloat(v, 'f', -1, 64)), '^')
+	case []interface{}:
+		return encodeList(buf, len(v), func(i int) interface{} { return v[i] })
+	case [][]byte:
+		return encodeList(buf, len(v), func(i int) interface{} { return v[i] })
+	case []string:
+		return encodeList(buf, len(v), func(i int) interface{} { return v[i] })
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		payload := new(bytes.Buffer)
+		for _, k := range keys {
+			writeItem(payload, []byte(k), ';')
+			if err := encode(payload, v[k]); err != nil {
+				return err
+			}
+		}
+		writeItem(buf, payload.Bytes(), '}')
+	default:
+		return fmt.Errorf("tnetstring: unsupported type %T", v)
+	}
+	return nil
+}
+
+func encodeList(buf *bytes.Buffer, n int, item func(i int) interface{}) error {
+	payload := new(bytes.Buffer)
+	for i := 0; i < n; i++ {
+		if err := encode(payload, item(i)); err != nil {
+			return err
+		}
+	}
+	writeItem(buf, payload.Bytes(), ']')
+	return nil
+}
+
+func writeItem(buf *bytes.Buffer, payload []byte, typ byte) {
+	buf.WriteString(strconv.Itoa(len(payload)))
+	buf.WriteByte(':')
+	buf.Write(payload)
+	buf.WriteByte(typ)
+}
+
+// Unmarshal decodes exactly one value in data
+func Unmarshal(data []byte) (interface{}, error) {
+	v, rest, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalid)
+	}
+	return v, nil
+}
+
+// parse decodes the first value in data, returns the value and the remaining data
+func parse(data []byte) (interface{}, []byte, error) {
+	i := bytes.IndexByte(data, ':')
+	if i <= 0 || i > maxLengthDigits {
+		return nil, nil, fmt.Errorf("%w: bad length prefix", ErrInvalid)
+	}
+	n, err := strconv.Atoi(string(data[:i]))
+	if err != nil || n < 0 || n > maxLength || len(data)-i-2 < n {
+		return nil, nil, fmt.Errorf("%w: bad length %q", ErrInvalid, data[:i])
+	}
+	payload := data[i+1 : i+1+n]
+	v, err := parsePayload(payload, data[i+1+n])
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, data[i+2+n:], nil
+}
+
+func parsePayload(payload []byte, typ byte) (interface{}, error) {
+	switch typ {
+	case ',':
+		return payload, nil
+	case ';':
+		return string(payload), nil
+	case '#':
+		n, err := strconv.ParseInt(string(payload), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad int %q", ErrInvalid, payload)
+		}
+		return n, nil
+	case '^':
+		f, err := strconv.ParseFloat(string(payload), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad float %q", ErrInvalid, payload)
+		}
+		return f, nil
+	case '!':
+		switch string(payload) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%w: bad bool %q", ErrInvalid, payload)
+	case '~':
+		if len(payload) != 0 {
+			return nil, fmt.Errorf("%w: bad null", ErrInvalid)
+		}
+		return nil, nil
+	case ']':
+		list := make([]interface{}, 0)
+		for len(payload) > 0 {
+			v, rest, err := parse(payload)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			payload = rest
+		}
+		return list, nil
+	case '}':
+		dict := make(map[string]interface{})
+		for len(payload) > 0 {
+			k, rest, err := parse(payload)
+			if err != nil {
+				return nil, err
+			}
+			var key string
+			switch k := k.(type) {
+			case string:
+				key = k
+			case []byte:
+				key = string(k)
+			default:
+				return nil, fmt.Errorf("%w: dict key of type %T", ErrInvalid, k)
+			}
+			if len(rest) == 0 {
+				return nil, fmt.Errorf("%w: dict key %q without value", ErrInvalid, key)
+			}
+			v, rest, err := parse(rest)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = v
+			payload = rest
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalid, typ)
+	}
+}
+
+// Decoder reads a stream of values, e.g. flows of a mitmproxy flow file
+type Decoder struct {
+	r *bufio.Reader
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode returns the next value, io.EOF at the end of stream
+func (d *Decoder) Decode() (interface{}, error) {
+	prefix, err := d.r.ReadSlice(':')
+	if err != nil {
+		if err == io.EOF && len(prefix) == 0 {
+			return nil, io.EOF
+		}
+		if err == bufio.ErrBufferFull || err == io.EOF {
+			return nil, fmt.Errorf("%w: bad length prefix", ErrInvalid)
+		}
+		return nil, err
+	}
+	if len(prefix) < 2 || len(prefix) > maxLengthDigits+1 {
+		return nil, fmt.Errorf("%w: bad length prefix", ErrInvalid)
+	}
+	n, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+	if err != nil || n < 0 || n > maxLength {
+		return nil, fmt.Errorf("%w: bad length %q", ErrInvalid, prefix)
+	}
+
+	data := make([]byte, n+1)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, io.ErrUnexpectedEOF)
+	}
+	return parsePayload(data[:n], data[n])
+}
@@ -0,0 +1,88 @@
+package tnetstring
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, "0:~"},
+		{[]byte("hello"), "5:hello,"},
+		{"héllo", "6:héllo;"},
+		{true, "4:true!"},
+		{int64(-12), "3:-12#"},
+		{1.5, "3:1.5^"},
+		{[]interface{}{int64(1), "a"}, "8:1:1#1:a;]"},
+		{map[string]interface{}{"b": nil, "a": []byte("")}, "14:1:a;0:,1:b;0:~}"},
+	}
+	for _, c := range cases {
+		data, err := Marshal(c.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.want {
+			t.Fatalf("Marshal(%#v) = %q, want %q", c.v, data, c.want)
+		}
+		v, err := Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, c.v) {
+			t.Fatalf("Unmarshal(%q) = %#v, want %#v", data, v, c.v)
+		}
+	}
+
+	if _, err := Marshal(struct{}{}); err == nil {
+		t.Fatal("unsupported type should error")
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, data := range []string{"", "5:abc,", "3:abc", "3:abc?", "1:a,x", "x:a,", "2:1:}", "4:1:a#}", "5:maybe!"} {
+		if _, err := Unmarshal([]byte(data)); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("Unmarshal(%q) should be invalid, but got %v", data, err)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	buf := new(bytes.Buffer)
+	values := []interface{}{
+		map[string]interface{}{"id": "1", "content": []byte{0, 1, 2}},
+		map[string]interface{}{"id": "2", "list": []interface{}{nil, false, 0.25}},
+	}
+	for _, v := range values {
+		data, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(data)
+	}
+	// bytes dict keys written by old mitmproxy
+	buf.WriteString("9:2:id,1:3;}")
+
+	d := NewDecoder(buf)
+	for _, want := range append(values, map[string]interface{}{"id": "3"}) {
+		v, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, want) {
+			t.Fatalf("Decode() = %#v, want %#v", v, want)
+		}
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Fatalf("expected io.EOF, but got %v", err)
+	}
+
+	if _, err := NewDecoder(bytes.NewReader([]byte("10:abc,"))).Decode(); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("truncated stream should be invalid, but got %v", err)
+	}
+}
@@ -39,6 +39,32 @@ type Addon interface {
 	AccessProxyServer(req *http.Request, res http.ResponseWriter)
 }
 
+// RawDataAddon is an optional interface of Addon, observes the raw bytes read from or written to connections.
+// data is only valid during the call, copy it if need to keep.
+type RawDataAddon interface {
+	// Bytes of the client connection. fromClient is true when read from client, false when written to client.
+	ClientData(connCtx *ConnContext, data []byte, fromClient bool)
+
+	// Bytes of the server connection. fromServer is true when read from server, false when written to server.
+	ServerData(connCtx *ConnContext, data []byte, fromServer bool)
+}
+
+func (proxy *Proxy) fireClientData(connCtx *ConnContext, data []byte, fromClient bool) {
+	for _, addon := range proxy.Addons {
+		if rawAddon, ok := addon.(RawDataAddon); ok {
+			rawAddon.ClientData(connCtx, data, fromClient)
+		}
+	}
+}
+
+func (proxy *Proxy) fireServerData(connCtx *ConnContext, data []byte, fromServer bool) {
+	for _, addon := range proxy.Addons {
+		if rawAddon, ok := addon.(RawDataAddon); ok {
+			rawAddon.ServerData(connCtx, data, fromServer)
+		}
+	}
+}
+
 // BaseAddon do nothing
 type BaseAddon struct{}
 
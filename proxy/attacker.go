@@ -3,10 +3,12 @@ package proxy
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/lqqyt2423/go-mitmproxy/cert"
 	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
@@ -55,13 +57,11 @@ func newAttacker(proxy *Proxy) (*attacker, error) {
 		ca:    ca,
 		client: &http.Client{
 			Transport: &http.Transport{
-				Proxy:              proxy.realUpstreamProxy(),
+				// dial through upstream proxy by ourselves, so the tls handshake always knows the dialed host
+				DialContext:        proxy.dialSharedClient,
+				DialTLSContext:     proxy.dialSharedClientTls,
 				ForceAttemptHTTP2:  true,
 				DisableCompression: true, // To get the original response from the server, set Transport.DisableCompression to true.
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: proxy.Opts.SslInsecure,
-					KeyLogWriter:       helper.GetTlsKeyLogWriter(),
-				},
 			},
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				// 禁止自动重定向
@@ -93,7 +93,7 @@ func newCa(opts *Options) (cert.CA, error) {
 	if newCaFunc != nil {
 		return newCaFunc()
 	}
-	return cert.NewSelfSignCA(opts.CaRootPath)
+	return cert.NewSelfSignCAWithOptions(opts.CaRootPath, opts.CertOptions)
 }
 
 func (a *attacker) start() error {
@@ -142,7 +142,7 @@ func (a *attacker) serveConn(clientTlsConn *tls.Conn, connCtx *ConnContext) {
 func (a *attacker) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	if strings.EqualFold(req.Header.Get("Connection"), "Upgrade") && strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
 		// wss
-		defaultWebSocket.wss(res, req)
+		defaultWebSocket.wss(res, req, a.proxy.newUpstreamTlsConfig(addressHostname(req.Host)))
 		return
 	}
 
@@ -202,14 +202,13 @@ func (a *attacker) serverTlsHandshake(ctx context.Context, connCtx *ConnContext)
 	clientHello := connCtx.ClientConn.clientHello
 	serverConn := connCtx.ServerConn
 
-	serverTlsConfig := &tls.Config{
-		InsecureSkipVerify: proxy.Opts.SslInsecure,
-		KeyLogWriter:       helper.GetTlsKeyLogWriter(),
-		ServerName:         clientHello.ServerName,
-		NextProtos:         clientHello.SupportedProtos,
-		// CurvePreferences:   clientHello.SupportedCurves, // todo: 如果打开会出错
-		CipherSuites: clientHello.CipherSuites,
-	}
+	serverTlsConfig := proxy.newUpstreamTlsConfig(addressHostname(serverConn.Address))
+	serverTlsConfig.ServerName = clientHello.ServerName
+	serverTlsConfig.NextProtos = clientHello.SupportedProtos
+	// serverTlsConfig.CurvePreferences = clientHello.SupportedCurves // todo: 如果打开会出错
+	serverTlsConfig.CipherSuites = clientHello.CipherSuites
+	serverTlsConfig.GetClientCertificate = proxy.getClientCertificateFn(serverConn.Address, serverConn) // mTLS
+	serverTlsConfig.KeyLogWriter = proxy.newConnKeyLogWriter(connCtx)
 	if len(clientHello.SupportedVersions) > 0 {
 		minVersion := clientHello.SupportedVersions[0]
 		maxVersion := clientHello.SupportedVersions[0]
@@ -227,6 +226,9 @@ func (a *attacker) serverTlsHandshake(ctx context.Context, connCtx *ConnContext)
 	serverTlsConn := tls.Client(serverConn.Conn, serverTlsConfig)
 	serverConn.tlsConn = serverTlsConn
 	if err := serverTlsConn.HandshakeContext(ctx); err != nil {
+		if certErr := newUpstreamCertError(serverConn.Address, err); certErr != nil {
+			return certErr
+		}
 		return err
 	}
 	serverTlsState := serverTlsConn.ConnectionState()
@@ -291,7 +293,20 @@ func (a *attacker) httpsDial(ctx context.Context, req *http.Request) (net.Conn,
 	return serverConn.Conn, nil
 }
 
-func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Conn) {
+// certClientHello fill ServerName with the connect host when client sends no SNI, e.g. connect by ip
+func certClientHello(chi *tls.ClientHelloInfo, host string) *tls.ClientHelloInfo {
+	if chi.ServerName != "" {
+		return chi
+	}
+	hello := *chi
+	hello.ServerName = host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hello.ServerName = h
+	}
+	return &hello
+}
+
+func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Conn, f *Flow) {
 	connCtx := cconn.(*wrapClientConn).connCtx
 	var clientHello *tls.ClientHelloInfo
 	clientHelloChan := make(chan *tls.ClientHelloInfo)
@@ -305,6 +320,7 @@ func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Co
 		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
 			clientHelloChan <- chi
 			nextProtos := make([]string, 0)
+			var upstreamCert *x509.Certificate
 
 			// wait server handshake finish
 			select {
@@ -314,9 +330,12 @@ func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Co
 				if serverTlsState.NegotiatedProtocol != "" {
 					nextProtos = append([]string{serverTlsState.NegotiatedProtocol}, nextProtos...)
 				}
+				if len(serverTlsState.PeerCertificates) > 0 {
+					upstreamCert = serverTlsState.PeerCertificates[0]
+				}
 			}
 
-			c, err := a.ca.GetCert(chi.ServerName)
+			c, err := a.ca.GetCertFor(certClientHello(chi, f.Request.URL.Host), upstreamCert)
 			if err != nil {
 				return nil, err
 			}
@@ -324,6 +343,7 @@ func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Co
 				SessionTicketsDisabled: true,
 				Certificates:           []tls.Certificate{*c},
 				NextProtos:             nextProtos,
+				KeyLogWriter:           a.proxy.newConnKeyLogWriter(connCtx),
 			}, nil
 
 		},
@@ -348,6 +368,7 @@ func (a *attacker) httpsTlsDial(ctx context.Context, cconn net.Conn, conn net.Co
 	connCtx.ClientConn.clientHello = clientHello
 
 	if err := a.serverTlsHandshake(ctx, connCtx); err != nil {
+		f.Error = err
 		cconn.Close()
 		conn.Close()
 		errChan2 <- err
@@ -376,7 +397,7 @@ func (a *attacker) httpsLazyAttack(ctx context.Context, cconn net.Conn, req *htt
 		SessionTicketsDisabled: true, // 设置此值为 true ，确保每次都会调用下面的 GetConfigForClient 方法
 		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
 			connCtx.ClientConn.clientHello = chi
-			c, err := a.ca.GetCert(chi.ServerName)
+			c, err := a.ca.GetCertFor(certClientHello(chi, req.Host), nil)
 			if err != nil {
 				return nil, err
 			}
@@ -384,6 +405,7 @@ func (a *attacker) httpsLazyAttack(ctx context.Context, cconn net.Conn, req *htt
 				SessionTicketsDisabled: true,
 				Certificates:           []tls.Certificate{*c},
 				NextProtos:             []string{"http/1.1"}, // only support http/1.1
+				KeyLogWriter:           a.proxy.newConnKeyLogWriter(connCtx),
 			}, nil
 		},
 	})
@@ -475,6 +497,7 @@ func (a *attacker) execute(f *Flow) {
 		if f.ConnContext.ServerConn == nil && f.ConnContext.dialFn != nil {
 			if err := f.ConnContext.dialFn(req.Context()); err != nil {
 				log.Error(err)
+				f.Error = err
 				f.Response = &Response{StatusCode: 502}
 				return
 			}
@@ -483,6 +506,10 @@ func (a *attacker) execute(f *Flow) {
 	}
 	if err != nil {
 		logErr(err)
+		if certErr := newUpstreamCertError(f.Request.URL.Host, err); certErr != nil {
+			err = certErr
+		}
+		f.Error = err
 		f.Response = &Response{StatusCode: 502}
 		return
 	}
@@ -493,6 +520,7 @@ func (a *attacker) execute(f *Flow) {
 	}
 
 	//construct response object
+	f.ResponseTime = time.Now()
 	f.Response = &Response{
 		StatusCode: proxyRes.StatusCode,
 		Header:     proxyRes.Header,
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ClientCert client certificate presented to upstream servers which request one (mutual TLS)
+type ClientCert struct {
+	Hosts    []string // host patterns, same syntax as ignore_hosts, e.g. *.example.com:443
+	CertFile string   // certificate file in PEM format, or PKCS#12 bundle ends with .p12/.pfx
+	KeyFile  string   // private key file in PEM format, empty when CertFile contains the key
+	Password string   // password of PKCS#12 bundle
+
+	certificate *tls.Certificate
+}
+
+func (c *ClientCert) load() error {
+	if len(c.Hosts) == 0 {
+		return fmt.Errorf("client cert %v: no hosts", c.CertFile)
+	}
+	if c.CertFile == "" {
+		return errors.New("client cert: empty CertFile")
+	}
+
+	if isPKCS12File(c.CertFile) {
+		data, err := os.ReadFile(c.CertFile)
+		if err != nil {
+			return err
+		}
+		key, leaf, caCerts, err := pkcs12.DecodeChain(data, c.Password)
+		if err != nil {
+			return fmt.Errorf("client cert %v: %w", c.CertFile, err)
+		}
+		certificate := &tls.Certificate{
+			Certificate: [][]byte{leaf.Raw},
+			PrivateKey:  key,
+			Leaf:        leaf,
+		}
+		for _, caCert := range caCerts {
+			certificate.Certificate = append(certificate.Certificate, caCert.Raw)
+		}
+		c.certificate = certificate
+		return nil
+	}
+
+	keyFile := c.KeyFile
+	if keyFile == "" {
+		keyFile = c.CertFile
+	}
+	certificate, err := tls.LoadX509KeyPair(c.CertFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("client cert %v: %w", c.CertFile, err)
+	}
+	if certificate.Leaf == nil {
+		certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("client cert %v: %w", c.CertFile, err)
+		}
+	}
+	c.certificate = &certificate
+	return nil
+}
+
+// Certificate returns the loaded certificate
+func (c *ClientCert) Certificate() *tls.Certificate {
+	return c.certificate
+}
+
+func (c *ClientCert) match(address string) bool {
+	return helper.MatchHost(address, c.Hosts)
+}
+
+func isPKCS12File(filename string) bool {
+	lower := strings.ToLower(filename)
+	return strings.HasSuffix(lower, ".p12") || strings.HasSuffix(lower, ".pfx")
+}
+
+func loadClientCerts(certs []*ClientCert) error {
+	for _, c := range certs {
+		if err := c.load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find the first client cert which matches the address
+func (proxy *Proxy) findClientCert(address string) *ClientCert {
+	for _, c := range proxy.Opts.ClientCerts {
+		if c.match(address) {
+			return c
+		}
+	}
+	return nil
+}
+
+// client certificate callback for the tls connection to address
+func (proxy *Proxy) getClientCertificateFn(address string, serverConn *ServerConn) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		c := proxy.findClientCert(address)
+		if c == nil {
+			// send no certificate, let the server decide
+			return &tls.Certificate{}, nil
+		}
+		if err := info.SupportsCertificate(c.certificate); err != nil {
+			log.Warnf("client cert %v may not be accepted by %v: %v", c.CertFile, address, err)
+		}
+		if serverConn != nil {
+			serverConn.ClientCert = c
+		}
+		log.Debugf("present client cert %v to %v", c.CertFile, address)
+		return c.certificate, nil
+	}
+}
+
+// client certificate callback for the shared http client, the address comes from the proxy request.
+// The presented certificate is recorded on the ServerConn of the request when it is of the same address.
+func (proxy *Proxy) getClientCertificateFromCtx(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	req, ok := info.Context().Value(proxyReqCtxKey).(*http.Request)
+	if !ok {
+		return &tls.Certificate{}, nil
+	}
+	address := helper.CanonicalAddr(req.URL)
+	var serverConn *ServerConn
+	if connCtx, ok := req.Context().Value(connContextKey).(*ConnContext); ok && connCtx.ServerConn != nil && connCtx.ServerConn.Address == address {
+		serverConn = connCtx.ServerConn
+	}
+	return proxy.getClientCertificateFn(address, serverConn)(info)
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+)
+
+type testClientCertAddon struct {
+	BaseAddon
+}
+
+func (a *testClientCertAddon) Response(f *Flow) {
+	certFile := "null"
+	if f.ConnContext.ServerConn != nil && f.ConnContext.ServerConn.ClientCert != nil {
+		certFile = filepath.Base(f.ConnContext.ServerConn.ClientCert.CertFile)
+	}
+	f.Response.Header.Add("client-cert", certFile)
+}
+
+// send requests with the shared http client of attacker, forget the client cert presented when dialing server
+type testSeparateClientAddon struct {
+	BaseAddon
+}
+
+func (a *testSeparateClientAddon) Request(f *Flow) {
+	f.UseSeparateClient = true
+	if f.ConnContext.ServerConn != nil {
+		f.ConnContext.ServerConn.ClientCert = nil
+	}
+}
+
+func writeTestClientCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	ca, err := cert.NewSelfSignCAMemory()
+	handleError(t, err)
+	c, err := ca.GetCert("client")
+	handleError(t, err)
+
+	certFile := filepath.Join(dir, "client-cert.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Certificate[0]})
+	handleError(t, os.WriteFile(certFile, certPem, 0600))
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(c.PrivateKey)
+	handleError(t, err)
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	handleError(t, os.WriteFile(keyFile, keyPem, 0600))
+	return certFile, keyFile
+}
+
+func TestClientCert(t *testing.T) {
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29089",
+	}
+	helper.init(t)
+	helper.server.TLSConfig.ClientAuth = tls.RequireAnyClientCert
+	httpsEndpoint := helper.httpsEndpoint
+	testProxy := helper.testProxy
+	testProxy.AddAddon(&testClientCertAddon{})
+	getProxyClient := helper.getProxyClient
+
+	certFile, keyFile := writeTestClientCert(t, t.TempDir())
+	clientCerts := []*ClientCert{
+		{Hosts: []string{"localhost"}, CertFile: certFile, KeyFile: keyFile},
+	}
+	handleError(t, loadClientCerts(clientCerts))
+	testProxy.Opts.ClientCerts = clientCerts
+
+	defer helper.tlsPlainLn.Close()
+	go helper.server.ServeTLS(helper.tlsPlainLn, "", "")
+	go testProxy.Start()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	t.Run("should present client cert", func(t *testing.T) {
+		resp, body := testGetResponse(t, httpsEndpoint, getProxyClient())
+		if string(body) != "ok" {
+			t.Fatalf("expected %s, but got %s", "ok", body)
+		}
+		if resp.Header.Get("client-cert") != "client-cert.pem" {
+			t.Fatalf("expected %s, but got %s", "client-cert.pem", resp.Header.Get("client-cert"))
+		}
+	})
+
+	t.Run("should record client cert of shared client", func(t *testing.T) {
+		separate := &testSeparateClientAddon{}
+		testProxy.AddAddon(separate)
+		defer func() { testProxy.Addons = testProxy.Addons[:len(testProxy.Addons)-1] }()
+		resp, body := testGetResponse(t, httpsEndpoint, getProxyClient())
+		if string(body) != "ok" {
+			t.Fatalf("expected %s, but got %s", "ok", body)
+		}
+		if resp.Header.Get("client-cert") != "client-cert.pem" {
+			t.Fatalf("expected %s, but got %s", "client-cert.pem", resp.Header.Get("client-cert"))
+		}
+	})
+
+	t.Run("should fail without matched client cert", func(t *testing.T) {
+		clientCerts[0].Hosts = []string{"example.com"}
+		defer func() { clientCerts[0].Hosts = []string{"localhost"} }()
+		_, err := getProxyClient().Get(httpsEndpoint)
+		if err == nil {
+			t.Fatal("should have error")
+		}
+	})
+}
@@ -2,10 +2,16 @@ package proxy
 
 import (
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/atomic"
@@ -44,6 +50,8 @@ type ServerConn struct {
 	Address string
 	Conn    net.Conn //*wrapServerConn
 
+	ClientCert *ClientCert // client certificate presented to server, nil if server not request or not matched
+
 	client   *http.Client
 	tlsConn  *tls.Conn
 	tlsState *tls.ConnectionState
@@ -64,9 +72,61 @@ func (c *ServerConn) MarshalJSON() ([]byte, error) {
 		peername = c.Conn.RemoteAddr().String()
 	}
 	m["peername"] = peername
+	if c.ClientCert != nil {
+		m["clientCert"] = c.ClientCert.CertFile
+	}
+	if c.tlsState != nil {
+		m["tls"] = tlsStateToMap(c.tlsState)
+	}
 	return json.Marshal(m)
 }
 
+func tlsStateToMap(state *tls.ConnectionState) map[string]interface{} {
+	m := make(map[string]interface{})
+	m["version"] = tls.VersionName(state.Version)
+	m["cipherSuite"] = tls.CipherSuiteName(state.CipherSuite)
+	m["alpn"] = state.NegotiatedProtocol
+	m["sni"] = state.ServerName
+	m["ocspResponse"] = state.OCSPResponse // base64 encoded by json
+	certs := make([]map[string]interface{}, 0, len(state.PeerCertificates))
+	for _, cert := range state.PeerCertificates {
+		certs = append(certs, certToMap(cert))
+	}
+	m["certificates"] = certs
+	return m
+}
+
+func certToMap(cert *x509.Certificate) map[string]interface{} {
+	sha1Sum := sha1.Sum(cert.Raw)
+	sha256Sum := sha256.Sum256(cert.Raw)
+	ips := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	uris := make([]string, 0, len(cert.URIs))
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	m := make(map[string]interface{})
+	m["subject"] = cert.Subject.String()
+	m["issuer"] = cert.Issuer.String()
+	m["serialNumber"] = cert.SerialNumber.String()
+	m["notBefore"] = cert.NotBefore.Format(time.RFC3339)
+	m["notAfter"] = cert.NotAfter.Format(time.RFC3339)
+	m["dnsNames"] = cert.DNSNames
+	m["ipAddresses"] = ips
+	m["emailAddresses"] = cert.EmailAddresses
+	m["uris"] = uris
+	m["isCA"] = cert.IsCA
+	m["signatureAlgorithm"] = cert.SignatureAlgorithm.String()
+	m["publicKeyAlgorithm"] = cert.PublicKeyAlgorithm.String()
+	m["sha1"] = hex.EncodeToString(sha1Sum[:])
+	m["sha256"] = hex.EncodeToString(sha256Sum[:])
+	m["spki"] = SPKIHash(cert)
+	return m
+}
+
 func (c *ServerConn) TlsState() *tls.ConnectionState {
 	return c.tlsState
 }
@@ -84,6 +144,9 @@ type ConnContext struct {
 	proxy              *Proxy
 	closeAfterResponse bool                        // after http response, http server will close the connection
 	dialFn             func(context.Context) error // when begin request, if there no ServerConn, use this func to dial
+
+	tlsKeyLog   []string // NSS key log lines of client-facing and server-facing tls sessions
+	tlsKeyLogMu sync.Mutex
 }
 
 func newConnContext(c net.Conn, proxy *Proxy) *ConnContext {
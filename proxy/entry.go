@@ -63,7 +63,19 @@ func (c *wrapClientConn) Peek(n int) ([]byte, error) {
 }
 
 func (c *wrapClientConn) Read(data []byte) (int, error) {
-	return c.r.Read(data)
+	n, err := c.r.Read(data)
+	if n > 0 {
+		c.proxy.fireClientData(c.connCtx, data[:n], true)
+	}
+	return n, err
+}
+
+func (c *wrapClientConn) Write(data []byte) (int, error) {
+	n, err := c.Conn.Write(data)
+	if n > 0 {
+		c.proxy.fireClientData(c.connCtx, data[:n], false)
+	}
+	return n, err
 }
 
 func (c *wrapClientConn) Close() error {
@@ -114,6 +126,22 @@ type wrapServerConn struct {
 	closeErr error
 }
 
+func (c *wrapServerConn) Read(data []byte) (int, error) {
+	n, err := c.Conn.Read(data)
+	if n > 0 {
+		c.proxy.fireServerData(c.connCtx, data[:n], true)
+	}
+	return n, err
+}
+
+func (c *wrapServerConn) Write(data []byte) (int, error) {
+	n, err := c.Conn.Write(data)
+	if n > 0 {
+		c.proxy.fireServerData(c.connCtx, data[:n], false)
+	}
+	return n, err
+}
+
 func (c *wrapServerConn) Close() error {
 	c.closeMu.Lock()
 	defer c.closeMu.Unlock()
@@ -329,7 +357,7 @@ func (e *entry) httpsDialFirstAttack(res http.ResponseWriter, req *http.Request,
 
 	// is tls
 	f.ConnContext.ClientConn.Tls = true
-	proxy.attacker.httpsTlsDial(req.Context(), cconn, conn)
+	proxy.attacker.httpsTlsDial(req.Context(), cconn, conn, f)
 }
 
 func (e *entry) httpsDialLazyAttack(res http.ResponseWriter, req *http.Request, f *Flow) {
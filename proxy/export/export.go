@@ -0,0 +1,346 @@
+// Package export renders a captured request as a command or code snippet which sends the same request again.
+package export
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+const (
+	FormatCurl   = "curl"   // curl command
+	FormatHttpie = "httpie" // httpie command
+	FormatRaw    = "raw"    // raw HTTP/1.1 message
+	FormatGo     = "go"     // go program using net/http
+	FormatPython = "python" // python script using requests
+)
+
+// Formats all supported formats
+var Formats = []string{FormatCurl, FormatHttpie, FormatRaw, FormatGo, FormatPython}
+
+// ErrBodyTruncated the request body is cut, the snippet would send a different request
+var ErrBodyTruncated = errors.New("export: request body truncated")
+
+// Export renders req in format, ErrBodyTruncated when the body of req is truncated
+func Export(req *proxy.Request, format string) ([]byte, error) {
+	if req.BodyTruncated {
+		return nil, fmt.Errorf("%w, %v bytes kept of %v bytes", ErrBodyTruncated, len(req.Body), req.BodySize)
+	}
+	switch format {
+	case FormatCurl:
+		return []byte(Curl(req)), nil
+	case FormatHttpie:
+		return []byte(Httpie(req)), nil
+	case FormatRaw:
+		return Raw(req), nil
+	case FormatGo:
+		return []byte(Go(req)), nil
+	case FormatPython:
+		return []byte(Python(req)), nil
+	}
+	return nil, fmt.Errorf("export: unknown format %q, supported: %v", format, strings.Join(Formats, ", "))
+}
+
+// skipped headers, computed by clients from URL and body
+var skipHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Proxy-Connection":  true,
+	"Keep-Alive":        true,
+}
+
+// headers returns headers of req sorted by name, Host is kept only when it differs from the host of URL
+func headers(req *proxy.Request) [][2]string {
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([][2]string, 0, len(names))
+	for _, name := range names {
+		if skipHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		for _, value := range req.Header[name] {
+			if http.CanonicalHeaderKey(name) == "Host" && value == req.URL.Host {
+				continue
+			}
+			result = append(result, [2]string{name, value})
+		}
+	}
+	return result
+}
+
+// method returns method of req, GET when empty
+func method(req *proxy.Request) string {
+	if req.Method == "" {
+		return http.MethodGet
+	}
+	return req.Method
+}
+
+// Curl renders req as curl command for POSIX shell, URL globbing of curl is turned off by -g.
+// Bodies which are not plain text, see pipeBody, are piped from base64 -d.
+func Curl(req *proxy.Request) string {
+	first := "curl -g"
+	m := method(req)
+	if m == http.MethodHead {
+		first += " --head"
+	} else if m != http.MethodGet || len(req.Body) > 0 {
+		first += " -X " + shellQuote(m)
+	}
+	lines := []string{first + " " + shellQuote(req.URL.String())}
+	for _, h := range headers(req) {
+		if h[1] == "" {
+			// curl removes header given as "Name:", "Name;" sends it empty
+			lines = append(lines, "-H "+shellQuote(h[0]+";"))
+		} else {
+			lines = append(lines, "-H "+shellQuote(h[0]+": "+h[1]))
+		}
+	}
+
+	prefix := ""
+	if len(req.Body) > 0 {
+		if req.Header.Get("Content-Type") == "" {
+			// not send default application/x-www-form-urlencoded of curl
+			lines = append(lines, "-H "+shellQuote("Content-Type:"))
+		}
+		if pipeBody(req.Body) {
+			prefix = base64Pipe(req.Body)
+			lines = append(lines, "--data-binary @-")
+		} else {
+			// not read file when body starts with @
+			lines = append(lines, "--data-raw "+shellQuote(string(req.Body)))
+		}
+	}
+	return prefix + strings.Join(lines, " \\\n  ") + "\n"
+}
+
+// Httpie renders req as httpie command for POSIX shell, --raw requires httpie 3.0 or later.
+// Bodies which are not plain text, see pipeBody, are piped from base64 -d.
+func Httpie(req *proxy.Request) string {
+	lines := []string{"http " + shellQuote(method(req)) + " " + shellQuote(req.URL.String())}
+	for _, h := range headers(req) {
+		value := h[1]
+		switch {
+		case value == "":
+			// header with empty value
+			lines = append(lines, shellQuote(h[0]+";"))
+			continue
+		case strings.HasPrefix(value, "=") || strings.HasPrefix(value, "@"):
+			// escaped, Name:=value is json field
+			value = "\\" + value
+		}
+		lines = append(lines, shellQuote(h[0]+":"+value))
+	}
+
+	prefix := ""
+	if len(req.Body) > 0 {
+		if pipeBody(req.Body) {
+			prefix = base64Pipe(req.Body)
+		} else {
+			lines = append(lines, "--raw "+shellQuote(string(req.Body)))
+		}
+	}
+	return prefix + strings.Join(lines, " \\\n  ") + "\n"
+}
+
+// pipeBody reports whether body is piped from base64 -d instead of quoted in the command line,
+// which is when body is invalid UTF-8 or has control characters other than newline and tab, e.g. CRLF of multipart,
+// as they can not be kept by copy and paste in terminals, and NUL can not be passed in arguments at all
+func pipeBody(body []byte) bool {
+	if !utf8.Valid(body) {
+		return true
+	}
+	for _, c := range body {
+		if c < 0x20 && c != '\n' && c != '\t' || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// base64Pipe returns the command writing body to stdin of next command
+func base64Pipe(body []byte) string {
+	return "printf %s " + shellQuote(base64.StdEncoding.EncodeToString(body)) + " | base64 -d | "
+}
+
+// Raw renders req as HTTP/1.1 message, with Host header and Content-Length of body
+func Raw(req *proxy.Request) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%v %v HTTP/1.1\r\n", method(req), req.URL.RequestURI())
+	host := req.URL.Host
+	if h := req.Header.Get("Host"); h != "" {
+		host = h
+	}
+	fmt.Fprintf(buf, "Host: %v\r\n", host)
+	for _, h := range headers(req) {
+		if http.CanonicalHeaderKey(h[0]) == "Host" {
+			continue
+		}
+		fmt.Fprintf(buf, "%v: %v\r\n", h[0], h[1])
+	}
+	if len(req.Body) > 0 {
+		fmt.Fprintf(buf, "Content-Length: %v\r\n", len(req.Body))
+	}
+	buf.WriteString("\r\n")
+	buf.Write(req.Body)
+	return buf.Bytes()
+}
+
+// Go renders req as go program sending it with net/http and printing the response
+func Go(req *proxy.Request) string {
+	buf := new(strings.Builder)
+	buf.WriteString("package main\n\nimport (\n\t\"fmt\"\n\t\"io\"\n\t\"net/http\"\n")
+	if len(req.Body) > 0 {
+		buf.WriteString("\t\"strings\"\n")
+	}
+	buf.WriteString(")\n\nfunc main() {\n")
+
+	body := "nil"
+	if len(req.Body) > 0 {
+		fmt.Fprintf(buf, "\tbody := strings.NewReader(%v)\n", strconv.Quote(string(req.Body)))
+		body = "body"
+	}
+	fmt.Fprintf(buf, "\treq, err := http.NewRequest(%v, %v, %v)\n", strconv.Quote(method(req)), strconv.Quote(req.URL.String()), body)
+	buf.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	for _, h := range headers(req) {
+		if http.CanonicalHeaderKey(h[0]) == "Host" {
+			fmt.Fprintf(buf, "\treq.Host = %v\n", strconv.Quote(h[1]))
+			continue
+		}
+		fmt.Fprintf(buf, "\treq.Header.Add(%v, %v)\n", strconv.Quote(h[0]), strconv.Quote(h[1]))
+	}
+	buf.WriteString(`
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(resp.Status)
+	fmt.Println(string(respBody))
+}
+`)
+	return buf.String()
+}
+
+// Python renders req as python script sending it with requests and printing the response.
+// Repeated headers are joined with comma, as dict keys are unique.
+func Python(req *proxy.Request) string {
+	buf := new(strings.Builder)
+	buf.WriteString("import requests\n\n")
+	fmt.Fprintf(buf, "url = %v\n", pythonString(req.URL.String()))
+
+	hs := headers(req)
+	joined := make([][2]string, 0, len(hs))
+	index := make(map[string]int)
+	for _, h := range hs {
+		key := http.CanonicalHeaderKey(h[0])
+		if i, ok := index[key]; ok {
+			sep := ", "
+			if key == "Cookie" {
+				sep = "; "
+			}
+			joined[i][1] += sep + h[1]
+			continue
+		}
+		index[key] = len(joined)
+		joined = append(joined, h)
+	}
+	buf.WriteString("headers = {\n")
+	for _, h := range joined {
+		fmt.Fprintf(buf, "    %v: %v,\n", pythonString(h[0]), pythonString(h[1]))
+	}
+	buf.WriteString("}\n")
+
+	data := ""
+	if len(req.Body) > 0 {
+		fmt.Fprintf(buf, "data = %v\n", pythonBytes(req.Body))
+		data = ", data=data"
+	}
+	fmt.Fprintf(buf, "\nresponse = requests.request(%v, url, headers=headers%v)\n", pythonString(method(req)), data)
+	buf.WriteString("print(response.status_code)\nprint(response.text)\n")
+	return buf.String()
+}
+
+// shellQuote quotes s as one word of POSIX shell, s should not contain NUL
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-_./:=@%+,", c) >= 0) {
+			return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+		}
+	}
+	return s
+}
+
+// pythonString returns python str literal of s, invalid UTF-8 bytes are replaced
+func pythonString(s string) string {
+	buf := new(strings.Builder)
+	buf.WriteByte('\'')
+	for _, r := range s {
+		writePythonChar(buf, r)
+	}
+	buf.WriteByte('\'')
+	return buf.String()
+}
+
+// pythonBytes returns python str literal of ASCII text, otherwise bytes literal, requests sends str as latin-1
+func pythonBytes(b []byte) string {
+	ascii := true
+	for _, c := range b {
+		if c >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return pythonString(string(b))
+	}
+	buf := new(strings.Builder)
+	buf.WriteString("b'")
+	for _, c := range b {
+		if c >= 0x80 {
+			fmt.Fprintf(buf, "\\x%02x", c)
+		} else {
+			writePythonChar(buf, rune(c))
+		}
+	}
+	buf.WriteByte('\'')
+	return buf.String()
+}
+
+func writePythonChar(buf *strings.Builder, r rune) {
+	switch {
+	case r == '\\' || r == '\'':
+		buf.WriteByte('\\')
+		buf.WriteRune(r)
+	case r == '\n':
+		buf.WriteString(`\n`)
+	case r == '\r':
+		buf.WriteString(`\r`)
+	case r == '\t':
+		buf.WriteString(`\t`)
+	case r < 0x20 || r == 0x7f:
+		fmt.Fprintf(buf, "\\x%02x", r)
+	default:
+		buf.WriteRune(r)
+	}
+}
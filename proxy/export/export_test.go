@@ -0,0 +1,197 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"go/parser"
+	"go/token"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+var testBodies = map[string][]byte{
+	"empty":     nil,
+	"quote":     []byte(`{"name":"it's \"ok\"","cmd":"$(rm -rf /) ` + "`id`" + ` \\ !"}`),
+	"at":        []byte("@/etc/passwd"),
+	"multipart": []byte("--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nv'1\r\n--xyz\r\nContent-Disposition: form-data; name=\"f\"; filename=\"b.txt\"\r\nContent-Type: text/plain\r\n\r\nline1\nline2\t\x01\r\n--xyz--\r\n"),
+	"utf8":      []byte("héllo 世界\n"),
+	"binary":    {0x00, 0xff, 0xfe, '\'', '\n', 0x7f, 0x80},
+	"latin1":    {'a', 0xe9, '\r', '\n'},
+}
+
+func newTestRequest(rawurl string, body []byte) *proxy.Request {
+	u, _ := url.Parse(rawurl)
+	header := http.Header{
+		"X-Quote":  {`a'b "c" $HOME`},
+		"X-Empty":  {""},
+		"X-Equal":  {"=x"},
+		"Cookie":   {"a=1", "b=2"},
+		"Accept":   {"*/*"},
+		"Host":     {u.Host},
+		"X-Multi":  {"1", "2"},
+		"X-Length": {"ignored"},
+	}
+	if bytes.HasPrefix(body, []byte("--xyz")) {
+		header.Set("Content-Type", "multipart/form-data; boundary=xyz")
+	}
+	return &proxy.Request{Method: "POST", URL: u, Proto: "HTTP/1.1", Header: header, Body: body}
+}
+
+func TestCurl(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl not found")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	for name, body := range testBodies {
+		req := newTestRequest(server.URL+"/path?q=a%20b&x='y'&ids[]=1&g={a,b}", body)
+		out, err := exec.Command("sh", "-c", strings.TrimSuffix(Curl(req), "\n")+" -s -o /dev/null").CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %v %s\n%v", name, err, out, Curl(req))
+		}
+		if received.Method != "POST" || received.URL.RequestURI() != req.URL.RequestURI() || !bytes.Equal(receivedBody, body) {
+			t.Fatalf("%v: unexpected request %v %v %q", name, received.Method, received.URL, receivedBody)
+		}
+		for _, key := range []string{"X-Quote", "X-Empty", "X-Equal", "X-Multi", "Cookie"} {
+			if strings.Join(received.Header.Values(key), ",") != strings.Join(req.Header.Values(key), ",") {
+				t.Fatalf("%v: unexpected header %v: %q", name, key, received.Header.Values(key))
+			}
+		}
+		if _, ok := received.Header["X-Empty"]; !ok {
+			t.Fatalf("%v: empty header should be sent", name)
+		}
+		if len(body) > 0 && received.Header.Get("Content-Type") != req.Header.Get("Content-Type") {
+			t.Fatalf("%v: unexpected content type %q", name, received.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	for name, body := range testBodies {
+		if bytes.IndexByte(body, 0) >= 0 {
+			continue
+		}
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(string(body))).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, body) {
+			t.Fatalf("%v: expected %q, but got %q", name, body, out)
+		}
+	}
+	if shellQuote("https://example.com/a") != "https://example.com/a" || shellQuote("a b") != "'a b'" {
+		t.Fatal("unexpected quoting of plain words")
+	}
+}
+
+func TestHttpie(t *testing.T) {
+	req := newTestRequest("https://example.com/a", testBodies["quote"])
+	cmd := Httpie(req)
+	for _, expected := range []string{"http POST https://example.com/a \\\n", "\n  --raw '{", "X-Empty;", `'X-Equal:\=x'`, "X-Multi:1 \\\n  X-Multi:2"} {
+		if !strings.Contains(cmd, expected) {
+			t.Fatalf("expected %q in\n%v", expected, cmd)
+		}
+	}
+	if strings.Contains(cmd, "X-Length") == false || strings.Contains(cmd, "Host:") {
+		t.Fatalf("unexpected headers in\n%v", cmd)
+	}
+	if cmd := Httpie(newTestRequest("https://example.com/a", testBodies["binary"])); !strings.HasPrefix(cmd, "printf %s ") || !strings.Contains(cmd, "| base64 -d | http") {
+		t.Fatalf("binary body should be piped\n%v", cmd)
+	}
+	if cmd := Httpie(newTestRequest("https://example.com/a", testBodies["multipart"])); !strings.HasPrefix(cmd, "printf %s ") {
+		t.Fatalf("body with CRLF should be piped\n%v", cmd)
+	}
+	if cmd := Httpie(newTestRequest("https://example.com/a", testBodies["utf8"])); strings.HasPrefix(cmd, "printf %s ") {
+		t.Fatalf("utf8 text body should be quoted\n%v", cmd)
+	}
+}
+
+func TestExportTruncated(t *testing.T) {
+	req := newTestRequest("https://example.com/a", []byte("hello"))
+	if _, err := Export(req, FormatCurl); err != nil {
+		t.Fatal(err)
+	}
+	req.BodyTruncated, req.BodySize = true, 100
+	for _, format := range Formats {
+		if _, err := Export(req, format); !errors.Is(err, ErrBodyTruncated) {
+			t.Fatalf("%v: expected ErrBodyTruncated, but got %v", format, err)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	for name, body := range testBodies {
+		req := newTestRequest("https://example.com/a?b=1", body)
+		r, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(Raw(req))))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		got, _ := io.ReadAll(r.Body)
+		if r.Host != "example.com" || r.RequestURI != "/a?b=1" || !bytes.Equal(got, body) || r.Header.Get("X-Quote") != req.Header.Get("X-Quote") {
+			t.Fatalf("%v: unexpected request %+v %q", name, r, got)
+		}
+	}
+}
+
+func TestGo(t *testing.T) {
+	for name, body := range testBodies {
+		req := newTestRequest("https://example.com/a", body)
+		req.Header.Set("Host", "virtual.example.com")
+		code := Go(req)
+		if _, err := parser.ParseFile(token.NewFileSet(), "main.go", code, 0); err != nil {
+			t.Fatalf("%v: %v\n%v", name, err, code)
+		}
+		if !strings.Contains(code, `req.Host = "virtual.example.com"`) || strings.Contains(code, `"strings"`) != (len(body) > 0) {
+			t.Fatalf("%v: unexpected code\n%v", name, code)
+		}
+	}
+}
+
+func TestPython(t *testing.T) {
+	req := newTestRequest("https://example.com/a", nil)
+	code := Python(req)
+	for _, expected := range []string{"url = 'https://example.com/a'", "'Cookie': 'a=1; b=2',", "'X-Multi': '1, 2',", `'X-Quote': 'a\'b "c" $HOME',`, "requests.request('POST', url, headers=headers)"} {
+		if !strings.Contains(code, expected) {
+			t.Fatalf("expected %q in\n%v", expected, code)
+		}
+	}
+
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not found")
+	}
+	for name, body := range testBodies {
+		if len(body) == 0 {
+			continue
+		}
+		literal := pythonBytes(body)
+		script := "import sys\nv = " + literal + "\nsys.stdout.buffer.write(v if isinstance(v, bytes) else v.encode('latin-1'))\n"
+		out, err := exec.Command("python3", "-c", script).Output()
+		if err != nil {
+			t.Fatalf("%v: %v\n%v", name, err, script)
+		}
+		if !bytes.Equal(out, body) {
+			t.Fatalf("%v: expected %q, but got %q", name, body, out)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+// Package filter matches flows by expressions in the syntax of mitmproxy filters, e.g.
+//
+//	~d example.com & ~c 5.. & !~t image
+//
+// Supported:
+//
+//	~all        all flows
+//	~u regex    url
+//	~d regex    host of url
+//	~m regex    request method
+//	~c regex    response status code, matched in whole, e.g. 404 or 5..
+//	~h regex    request or response header, matched against lines of "Name: value"
+//	~hq regex   request header
+//	~hs regex   response header
+//	~t regex    content type of request or response
+//	~tq regex   content type of request
+//	~ts regex   content type of response
+//	~b regex    request or response body
+//	~bq regex   request body
+//	~bs regex   response body, decoded by Content-Encoding
+//	~q          flow without response
+//	~s          flow with response
+//	~e          flow with error
+//	!           not
+//	&           and, also implied between adjacent expressions
+//	|           or
+//	( )         grouping
+//
+// A bare word is matched against url. Regexes are case insensitive, and can be quoted by ' or ".
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// Filter a parsed filter expression
+type Filter struct {
+	expr string
+	root node
+	body bool // has body operators
+}
+
+// Parse parses the filter expression
+func Parse(expr string) (*Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("filter: empty expression")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("filter: unexpected %q in %q", p.tokens[p.pos].value, expr)
+	}
+	return &Filter{expr: expr, root: root, body: p.body}, nil
+}
+
+// MustParse is like Parse but panics if the expression can not be parsed
+func MustParse(expr string) *Filter {
+	f, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Match reports whether the flow matches the filter
+func (f *Filter) Match(flow *proxy.Flow) bool {
+	return f.root.match(flow)
+}
+
+// UsesBody reports whether the filter has ~b, ~bq or ~bs, so bodies should be read before matching
+func (f *Filter) UsesBody() bool {
+	return f.body
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type node interface {
+	match(f *proxy.Flow) bool
+}
+
+type notNode struct{ n node }
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type funcNode func(f *proxy.Flow) bool
+
+func (n notNode) match(f *proxy.Flow) bool  { return !n.n.match(f) }
+func (n andNode) match(f *proxy.Flow) bool  { return n.left.match(f) && n.right.match(f) }
+func (n orNode) match(f *proxy.Flow) bool   { return n.left.match(f) || n.right.match(f) }
+func (n funcNode) match(f *proxy.Flow) bool { return n(f) }
+
+// operators without argument
+var simpleOperators = map[string]funcNode{
+	"~all": func(f *proxy.Flow) bool { return true },
+	"~q":   func(f *proxy.Flow) bool { return f.Response == nil },
+	"~s":   func(f *proxy.Flow) bool { return f.Response != nil },
+	"~e":   func(f *proxy.Flow) bool { return f.Error != nil },
+}
+
+// operators matching bodies
+var bodyOperators = map[string]bool{"~b": true, "~bq": true, "~bs": true}
+
+// operators with regex argument
+var regexOperators = map[string]func(re *regexp.Regexp) funcNode{
+	"~u": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool { return re.MatchString(f.Request.URL.String()) }
+	},
+	"~d": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool { return re.MatchString(f.Request.URL.Hostname()) }
+	},
+	"~m": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool { return re.MatchString(f.Request.Method) }
+	},
+	"~c": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool {
+			return f.Response != nil && re.MatchString(strconv.Itoa(f.Response.StatusCode))
+		}
+	},
+	"~h": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool {
+			return matchHeader(re, f.Request.Header) || matchHeader(re, responseHeader(f))
+		}
+	},
+	"~hq": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool { return matchHeader(re, f.Request.Header) }
+	},
+	"~hs": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool { return matchHeader(re, responseHeader(f)) }
+	},
+	"~t": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool {
+			return re.MatchString(f.Request.Header.Get("Content-Type")) || re.MatchString(responseHeader(f).Get("Content-Type"))
+		}
+	},
+	"~tq": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool { return re.MatchString(f.Request.Header.Get("Content-Type")) }
+	},
+	"~ts": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool { return re.MatchString(responseHeader(f).Get("Content-Type")) }
+	},
+	"~b": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool { return re.Match(f.Request.Body) || re.Match(responseBody(f)) }
+	},
+	"~bq": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool { return re.Match(f.Request.Body) }
+	},
+	"~bs": func(re *regexp.Regexp) funcNode {
+		return func(f *proxy.Flow) bool { return re.Match(responseBody(f)) }
+	},
+}
+
+func responseHeader(f *proxy.Flow) http.Header {
+	if f.Response == nil {
+		return http.Header{}
+	}
+	return f.Response.Header
+}
+
+func responseBody(f *proxy.Flow) []byte {
+	if f.Response == nil || len(f.Response.Body) == 0 {
+		return nil
+	}
+	body, err := f.Response.DecodedBody()
+	if err != nil {
+		return f.Response.Body
+	}
+	return body
+}
+
+func matchHeader(re *regexp.Regexp, header http.Header) bool {
+	buf := new(bytes.Buffer)
+	for name, values := range header {
+		for _, value := range values {
+			buf.Reset()
+			buf.WriteString(name)
+			buf.WriteString(": ")
+			buf.WriteString(value)
+			if re.Match(buf.Bytes()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type token struct {
+	value  string
+	quoted bool // quoted strings are never operators
+}
+
+func tokenize(expr string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '!' || c == '&' || c == '|':
+			tokens = append(tokens, token{value: string(c)})
+			i++
+		case c == '"' || c == '\'':
+			// backslash escapes the quote and itself
+			buf := new(strings.Builder)
+			i++
+			for i < len(expr) && expr[i] != c {
+				if expr[i] == '\\' && i+1 < len(expr) && (expr[i+1] == c || expr[i+1] == '\\') {
+					i++
+				}
+				buf.WriteByte(expr[i])
+				i++
+			}
+			if i >= len(expr) {
+				return nil, fmt.Errorf("filter: unterminated quote %c", c)
+			}
+			i++
+			tokens = append(tokens, token{value: buf.String(), quoted: true})
+		default:
+			start := i
+			for i < len(expr) && strings.IndexByte(" \t\n\r()&|", expr[i]) < 0 {
+				i++
+			}
+			tokens = append(tokens, token{value: expr[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	body   bool // parsed body operators
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) isOperator(value string) bool {
+	t, ok := p.peek()
+	return ok && !t.quoted && t.value == value
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("|") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || !t.quoted && (t.value == "|" || t.value == ")") {
+			return left, nil
+		}
+		if p.isOperator("&") {
+			p.pos++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isOperator("!") {
+		p.pos++
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *parser) parseTerm() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("filter: unexpected end of expression")
+	}
+	p.pos++
+
+	if t.quoted {
+		return newRegexNode("~u", t.value)
+	}
+	switch t.value {
+	case "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOperator(")") {
+			return nil, fmt.Errorf("filter: missing )")
+		}
+		p.pos++
+		return n, nil
+	case ")", "&", "|":
+		return nil, fmt.Errorf("filter: unexpected %q", t.value)
+	}
+
+	if n, ok := simpleOperators[t.value]; ok {
+		return n, nil
+	}
+	if _, ok := regexOperators[t.value]; ok {
+		arg, ok := p.peek()
+		if !ok || !arg.quoted && len(arg.value) == 1 && strings.Contains("()!&|", arg.value) {
+			return nil, fmt.Errorf("filter: %v requires an argument", t.value)
+		}
+		p.pos++
+		if bodyOperators[t.value] {
+			p.body = true
+		}
+		return newRegexNode(t.value, arg.value)
+	}
+	if strings.HasPrefix(t.value, "~") {
+		return nil, fmt.Errorf("filter: unknown operator %v", t.value)
+	}
+	return newRegexNode("~u", t.value)
+}
+
+func newRegexNode(op string, expr string) (node, error) {
+	if op == "~c" {
+		expr = "^(?:" + expr + ")$"
+	}
+	re, err := regexp.Compile("(?i)" + expr)
+	if err != nil {
+		return nil, fmt.Errorf("filter: invalid regex of %v: %w", op, err)
+	}
+	return regexOperators[op](re), nil
+}
@@ -0,0 +1,99 @@
+package filter
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+func newTestFlow(rawurl string, status int, contentType string, body string) *proxy.Flow {
+	u, _ := url.Parse(rawurl)
+	f := &proxy.Flow{
+		Request: &proxy.Request{Method: "POST", URL: u, Proto: "HTTP/1.1", Header: http.Header{"Authorization": {"Bearer abc"}}, Body: []byte(body)},
+	}
+	if status > 0 {
+		f.Response = &proxy.Response{StatusCode: status, Header: http.Header{"Content-Type": {contentType}}, Body: []byte("response " + body)}
+	}
+	return f
+}
+
+func TestFilter(t *testing.T) {
+	api := newTestFlow("https://api.example.com/v1/users?id=1", 500, "application/json", `{"name":"a"}`)
+	image := newTestFlow("https://cdn.example.org/a.png", 200, "image/png", "")
+	pending := newTestFlow("http://example.net/", 0, "", "")
+	failed := newTestFlow("http://example.net/fail", 502, "text/html", "")
+	failed.Error = errors.New("upstream error")
+	flows := []*proxy.Flow{api, image, pending, failed}
+
+	cases := []struct {
+		expr    string
+		matched []*proxy.Flow
+	}{
+		{"~all", flows},
+		{"~d example.com", []*proxy.Flow{api}},
+		{"~d example.com & ~c 5..", []*proxy.Flow{api}},
+		{"~c 50", nil},
+		{"~c 50.", []*proxy.Flow{api, failed}},
+		{"~c 5.. & !~e", []*proxy.Flow{api}},
+		{"~t image | ~q", []*proxy.Flow{image, pending}},
+		{"!(~t image | ~q)", []*proxy.Flow{api, failed}},
+		{"~s !~t image", []*proxy.Flow{api, failed}},
+		{"~m post ~u /v1/", []*proxy.Flow{api}},
+		{"~hq 'authorization: bearer'", flows},
+		{"~hs authorization", nil},
+		{"~h 'content-type: image/'", []*proxy.Flow{image}},
+		{`~bq '"name"'`, []*proxy.Flow{api}},
+		{"~bs response", []*proxy.Flow{api, image, failed}},
+		{"~ts json", []*proxy.Flow{api}},
+		{"~tq json", nil},
+		{"a.png", []*proxy.Flow{image}},
+		{`"example\.net/fail"`, []*proxy.Flow{failed}},
+		{"~e", []*proxy.Flow{failed}},
+	}
+	for _, c := range cases {
+		f, err := Parse(c.expr)
+		if err != nil {
+			t.Fatalf("%v: %v", c.expr, err)
+		}
+		matched := make([]*proxy.Flow, 0)
+		for _, flow := range flows {
+			if f.Match(flow) {
+				matched = append(matched, flow)
+			}
+		}
+		if len(matched) != len(c.matched) {
+			t.Fatalf("%v: expected %v flows, but got %v", c.expr, len(c.matched), len(matched))
+		}
+		for i := range matched {
+			if matched[i] != c.matched[i] {
+				t.Fatalf("%v: unexpected flow %v", c.expr, matched[i].Request.URL)
+			}
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, expr := range []string{"", "~x", "~d", "~d &", "(~q", "~q )", "~u [", "~q |", "& ~q", "~d 'example.com", `~b "a\"`} {
+		if _, err := Parse(expr); err == nil {
+			t.Fatalf("%q: expected error", expr)
+		}
+	}
+}
+
+func TestUsesBody(t *testing.T) {
+	for expr, expected := range map[string]bool{
+		"~d example.com":         false,
+		"'~b'":                   false,
+		"~u ~b":                  false,
+		"~d a & !(~q | ~bs err)": true,
+		"~bq token":              true,
+		"~b x":                   true,
+	} {
+		if MustParse(expr).UsesBody() != expected {
+			t.Fatalf("%q: expected %v", expr, expected)
+		}
+	}
+}
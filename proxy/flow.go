@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -18,6 +19,9 @@ type Request struct {
 	Header http.Header
 	Body   []byte
 
+	BodyTruncated bool // Body is cut from the original body, e.g. read from a flow file saved with max body size
+	BodySize      int  // size of the original body when BodyTruncated
+
 	raw *http.Request
 }
 
@@ -99,6 +103,9 @@ type Response struct {
 	Body       []byte      `json:"-"`
 	BodyReader io.Reader
 
+	BodyTruncated bool `json:"-"` // Body is cut from the original body, e.g. read from a flow file saved with max body size
+	BodySize      int  `json:"-"` // size of the original body when BodyTruncated
+
 	close bool // connection close
 }
 
@@ -109,14 +116,21 @@ type Flow struct {
 	Request     *Request
 	Response    *Response
 
-	UseSeparateClient bool // use separate http client to send http request
-	done              chan struct{}
+	UseSeparateClient bool  // use separate http client to send http request
+	Error             error // upstream error, *UpstreamCertError when upstream certificate verify failed
+
+	StartTime    time.Time // request received from client
+	ResponseTime time.Time // response header received from server, zero when not from server
+	EndTime      time.Time // response written to client
+
+	done chan struct{}
 }
 
 func newFlow() *Flow {
 	return &Flow{
-		Id:   uuid.New(),
-		done: make(chan struct{}),
+		Id:        uuid.New(),
+		StartTime: time.Now(),
+		done:      make(chan struct{}),
 	}
 }
 
@@ -125,6 +139,7 @@ func (f *Flow) Done() <-chan struct{} {
 }
 
 func (f *Flow) finish() {
+	f.EndTime = time.Now()
 	close(f.done)
 }
 
@@ -133,5 +148,12 @@ func (f *Flow) MarshalJSON() ([]byte, error) {
 	j["id"] = f.Id
 	j["request"] = f.Request
 	j["response"] = f.Response
+	if f.Error != nil {
+		if _, ok := f.Error.(json.Marshaler); ok {
+			j["error"] = f.Error
+		} else {
+			j["error"] = map[string]string{"message": f.Error.Error()}
+		}
+	}
 	return json.Marshal(j)
 }
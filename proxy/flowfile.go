@@ -0,0 +1,414 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// flow file, full flows saved to disk and read back, e.g. for replay, diffing and sharing captures
+//
+// file:   magic 7 bytes "GMPFLOW" + version 1 byte + records
+// record: record len 4 byte + meta len 4 byte + meta json (flowRecord) + request body + response body
+// lengths are big endian, body sizes are in meta, bodySize is the length in record, size is of the original body
+// which is larger when the body is truncated
+
+const (
+	FlowFileMagic   = "GMPFLOW"
+	FlowFileVersion = 1
+
+	maxFlowRecordSize = 1 << 30
+)
+
+var ErrFlowFileFormat = errors.New("not a go-mitmproxy flow file")
+
+type flowRecord struct {
+	Id           uuid.UUID             `json:"id"`
+	StartTime    time.Time             `json:"startTime"`
+	ResponseTime time.Time             `json:"responseTime"`
+	EndTime      time.Time             `json:"endTime"`
+	Request      *flowRecordRequest    `json:"request"`
+	Response     *flowRecordResponse   `json:"response,omitempty"`
+	Error        string                `json:"error,omitempty"`
+	ClientConn   *flowRecordClientConn `json:"clientConn,omitempty"`
+	ServerConn   *flowRecordServerConn `json:"serverConn,omitempty"`
+}
+
+type flowRecordRequest struct {
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	Proto     string      `json:"proto"`
+	Header    http.Header `json:"header"`
+	BodySize  int         `json:"bodySize"`
+	Size      int         `json:"size"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+type flowRecordResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	BodySize   int         `json:"bodySize"`
+	Size       int         `json:"size"`
+	Truncated  bool        `json:"truncated,omitempty"`
+}
+
+type flowRecordClientConn struct {
+	Id                 uuid.UUID `json:"id"`
+	Address            string    `json:"address"`
+	LocalAddress       string    `json:"localAddress"`
+	Tls                bool      `json:"tls"`
+	NegotiatedProtocol string    `json:"negotiatedProtocol,omitempty"`
+}
+
+type flowRecordServerConn struct {
+	Id          uuid.UUID      `json:"id"`
+	Address     string         `json:"address"`
+	PeerAddress string         `json:"peerAddress,omitempty"`
+	Tls         *flowRecordTls `json:"tls,omitempty"`
+}
+
+type flowRecordTls struct {
+	Version            uint16   `json:"version"`
+	CipherSuite        uint16   `json:"cipherSuite"`
+	ServerName         string   `json:"serverName,omitempty"`
+	NegotiatedProtocol string   `json:"negotiatedProtocol,omitempty"`
+	Certificates       [][]byte `json:"certificates,omitempty"` // DER, peer certificates
+}
+
+// FlowWriter writes flows to w in the flow file format, safe for concurrent use
+type FlowWriter struct {
+	w             io.Writer
+	headerWritten bool
+	mu            sync.Mutex
+}
+
+func NewFlowWriter(w io.Writer) *FlowWriter {
+	return &FlowWriter{w: w}
+}
+
+// Write writes f with f.Request.Body and f.Response.Body as bodies, BodyReader is not read.
+// BodyTruncated and BodySize are kept, so readers know the bodies are not complete.
+func (fw *FlowWriter) Write(f *Flow) error {
+	record := newFlowRecord(f)
+	var reqBody, respBody []byte
+	if f.Request != nil {
+		reqBody = f.Request.Body
+	}
+	if f.Response != nil {
+		respBody = f.Response.Body
+	}
+
+	meta, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	size := 4 + len(meta) + len(reqBody) + len(respBody)
+	if size > maxFlowRecordSize {
+		return fmt.Errorf("flow record too large: %v", size)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 4+size))
+	_ = binary.Write(buf, binary.BigEndian, uint32(size))
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(meta)))
+	buf.Write(meta)
+	buf.Write(reqBody)
+	buf.Write(respBody)
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if !fw.headerWritten {
+		if _, err := fw.w.Write(append([]byte(FlowFileMagic), FlowFileVersion)); err != nil {
+			return err
+		}
+		fw.headerWritten = true
+	}
+	_, err = fw.w.Write(buf.Bytes())
+	return err
+}
+
+func newFlowRecord(f *Flow) *flowRecord {
+	record := &flowRecord{
+		Id:           f.Id,
+		StartTime:    f.StartTime,
+		ResponseTime: f.ResponseTime,
+		EndTime:      f.EndTime,
+	}
+	if f.Request != nil {
+		record.Request = &flowRecordRequest{
+			Method:    f.Request.Method,
+			Proto:     f.Request.Proto,
+			Header:    f.Request.Header,
+			BodySize:  len(f.Request.Body),
+			Size:      len(f.Request.Body),
+			Truncated: f.Request.BodyTruncated,
+		}
+		if f.Request.BodyTruncated {
+			record.Request.Size = f.Request.BodySize
+		}
+		if f.Request.URL != nil {
+			record.Request.URL = f.Request.URL.String()
+		}
+	}
+	if f.Response != nil {
+		record.Response = &flowRecordResponse{
+			StatusCode: f.Response.StatusCode,
+			Header:     f.Response.Header,
+			BodySize:   len(f.Response.Body),
+			Size:       len(f.Response.Body),
+			Truncated:  f.Response.BodyTruncated,
+		}
+		if f.Response.BodyTruncated {
+			record.Response.Size = f.Response.BodySize
+		}
+	}
+	if f.Error != nil {
+		record.Error = f.Error.Error()
+	}
+
+	if f.ConnContext == nil {
+		return record
+	}
+	if c := f.ConnContext.ClientConn; c != nil {
+		record.ClientConn = &flowRecordClientConn{
+			Id:                 c.Id,
+			Tls:                c.Tls,
+			NegotiatedProtocol: c.NegotiatedProtocol,
+		}
+		if c.Conn != nil {
+			record.ClientConn.Address = c.Conn.RemoteAddr().String()
+			record.ClientConn.LocalAddress = c.Conn.LocalAddr().String()
+		}
+	}
+	if c := f.ConnContext.ServerConn; c != nil {
+		record.ServerConn = &flowRecordServerConn{
+			Id:      c.Id,
+			Address: c.Address,
+		}
+		if c.Conn != nil {
+			record.ServerConn.PeerAddress = c.Conn.RemoteAddr().String()
+		}
+		if state := c.tlsState; state != nil {
+			recordTls := &flowRecordTls{
+				Version:            state.Version,
+				CipherSuite:        state.CipherSuite,
+				ServerName:         state.ServerName,
+				NegotiatedProtocol: state.NegotiatedProtocol,
+			}
+			for _, cert := range state.PeerCertificates {
+				recordTls.Certificates = append(recordTls.Certificates, cert.Raw)
+			}
+			record.ServerConn.Tls = recordTls
+		}
+	}
+	return record
+}
+
+// flowFileAddr address of connections read from flow files
+type flowFileAddr string
+
+func (flowFileAddr) Network() string  { return "tcp" }
+func (a flowFileAddr) String() string { return string(a) }
+
+// flowFileConn connection read from flow files, nothing to read or write
+type flowFileConn struct {
+	replayConn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *flowFileConn) LocalAddr() net.Addr  { return c.local }
+func (c *flowFileConn) RemoteAddr() net.Addr { return c.remote }
+
+// FlowReader reads flows written by FlowWriter
+type FlowReader struct {
+	r          *bufio.Reader
+	headerRead bool
+	conns      flowFileConns
+}
+
+func NewFlowReader(r io.Reader) *FlowReader {
+	return &FlowReader{
+		r:     bufio.NewReader(r),
+		conns: make(flowFileConns),
+	}
+}
+
+// Read returns the next flow, which is finished, io.EOF at the end of file
+func (fr *FlowReader) Read() (*Flow, error) {
+	if !fr.headerRead {
+		header := make([]byte, len(FlowFileMagic)+1)
+		if _, err := io.ReadFull(fr.r, header); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, ErrFlowFileFormat
+		}
+		if string(header[:len(FlowFileMagic)]) != FlowFileMagic {
+			return nil, ErrFlowFileFormat
+		}
+		if version := header[len(FlowFileMagic)]; version != FlowFileVersion {
+			return nil, fmt.Errorf("unsupported flow file version %v", version)
+		}
+		fr.headerRead = true
+	}
+
+	var size uint32
+	if err := binary.Read(fr.r, binary.BigEndian, &size); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("read flow record: %w", err)
+	}
+	if size < 4 || size > maxFlowRecordSize {
+		return nil, fmt.Errorf("invalid flow record size %v", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(fr.r, data); err != nil {
+		return nil, fmt.Errorf("read flow record: %w", io.ErrUnexpectedEOF)
+	}
+
+	metaLen := int(binary.BigEndian.Uint32(data[:4]))
+	if 4+metaLen > len(data) {
+		return nil, errors.New("invalid flow record meta size")
+	}
+	record := new(flowRecord)
+	if err := json.Unmarshal(data[4:4+metaLen], record); err != nil {
+		return nil, fmt.Errorf("decode flow record: %w", err)
+	}
+	if record.Request == nil {
+		return nil, errors.New("flow record without request")
+	}
+	bodies := data[4+metaLen:]
+	respBodySize := 0
+	if record.Response != nil {
+		respBodySize = record.Response.BodySize
+	}
+	if record.Request.BodySize < 0 || respBodySize < 0 || record.Request.BodySize+respBodySize != len(bodies) {
+		return nil, errors.New("invalid flow record body size")
+	}
+
+	return fr.conns.newFlow(record, bodies[:record.Request.BodySize], bodies[record.Request.BodySize:])
+}
+
+// flowFileConns flows of the same client connection share ConnContext
+type flowFileConns map[uuid.UUID]*ConnContext
+
+// newFlow returns the finished flow of record
+func (conns flowFileConns) newFlow(record *flowRecord, reqBody []byte, respBody []byte) (*Flow, error) {
+	u, err := url.Parse(record.Request.URL)
+	if err != nil {
+		return nil, fmt.Errorf("flow record url: %w", err)
+	}
+	f := newFlow()
+	f.Id = record.Id
+	f.StartTime = record.StartTime
+	f.ResponseTime = record.ResponseTime
+	f.Request = &Request{
+		Method: record.Request.Method,
+		URL:    u,
+		Proto:  record.Request.Proto,
+		Header: record.Request.Header,
+	}
+	if f.Request.Header == nil {
+		f.Request.Header = make(http.Header)
+	}
+	if len(reqBody) > 0 {
+		f.Request.Body = reqBody
+	}
+	if record.Request.Truncated {
+		f.Request.BodyTruncated = true
+		f.Request.BodySize = record.Request.Size
+	}
+	if record.Response != nil {
+		f.Response = &Response{
+			StatusCode:    record.Response.StatusCode,
+			Header:        record.Response.Header,
+			Body:          respBody,
+			BodyTruncated: record.Response.Truncated,
+		}
+		if record.Response.Truncated {
+			f.Response.BodySize = record.Response.Size
+		}
+		if f.Response.Header == nil {
+			f.Response.Header = make(http.Header)
+		}
+	}
+	if record.Error != "" {
+		f.Error = errors.New(record.Error)
+	}
+	f.ConnContext = conns.connContext(record, u)
+	f.ConnContext.FlowCount.Add(1)
+	f.finish()
+	f.EndTime = record.EndTime // set by finish
+	return f, nil
+}
+
+func (conns flowFileConns) connContext(record *flowRecord, u *url.URL) *ConnContext {
+	rc := record.ClientConn
+	if rc == nil {
+		rc = &flowRecordClientConn{Id: uuid.New(), Tls: u.Scheme == "https"}
+	}
+	connCtx, ok := conns[rc.Id]
+	if !ok {
+		connCtx = newConnContext(&flowFileConn{local: flowFileAddr(rc.LocalAddress), remote: flowFileAddr(rc.Address)}, nil)
+		connCtx.ClientConn.Id = rc.Id
+		connCtx.ClientConn.Tls = rc.Tls
+		connCtx.ClientConn.NegotiatedProtocol = rc.NegotiatedProtocol
+		connCtx.Intercept = true
+		conns[rc.Id] = connCtx
+	}
+
+	if rs := record.ServerConn; rs != nil && (connCtx.ServerConn == nil || connCtx.ServerConn.Id != rs.Id) {
+		serverConn := newServerConn()
+		serverConn.Id = rs.Id
+		serverConn.Address = rs.Address
+		if rs.PeerAddress != "" {
+			serverConn.Conn = &flowFileConn{local: flowFileAddr(""), remote: flowFileAddr(rs.PeerAddress)}
+		}
+		if rt := rs.Tls; rt != nil {
+			state := &tls.ConnectionState{
+				Version:            rt.Version,
+				CipherSuite:        rt.CipherSuite,
+				ServerName:         rt.ServerName,
+				NegotiatedProtocol: rt.NegotiatedProtocol,
+				HandshakeComplete:  true,
+			}
+			for _, der := range rt.Certificates {
+				if cert, err := x509.ParseCertificate(der); err == nil {
+					state.PeerCertificates = append(state.PeerCertificates, cert)
+				}
+			}
+			serverConn.tlsState = state
+		}
+		connCtx.ServerConn = serverConn
+	}
+	return connCtx
+}
+
+// ReadFlows reads all flows from r in the flow file format
+func ReadFlows(r io.Reader) ([]*Flow, error) {
+	fr := NewFlowReader(r)
+	flows := make([]*Flow, 0)
+	for {
+		f, err := fr.Read()
+		if err == io.EOF {
+			return flows, nil
+		}
+		if err != nil {
+			return flows, err
+		}
+		flows = append(flows, f)
+	}
+}
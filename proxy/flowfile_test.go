@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+)
+
+func TestFlowFile(t *testing.T) {
+	ca, err := cert.NewSelfSignCAMemory()
+	handleError(t, err)
+	serverCert, err := ca.GetCert("example.com")
+	handleError(t, err)
+	leaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+	handleError(t, err)
+
+	connCtx := newConnContext(&flowFileConn{local: flowFileAddr("127.0.0.1:9080"), remote: flowFileAddr("127.0.0.1:50000")}, nil)
+	connCtx.ClientConn.Tls = true
+	connCtx.ServerConn = newServerConn()
+	connCtx.ServerConn.Address = "example.com:443"
+	connCtx.ServerConn.tlsState = &tls.ConnectionState{
+		Version:          tls.VersionTLS13,
+		CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+		ServerName:       "example.com",
+		PeerCertificates: []*x509.Certificate{leaf},
+	}
+
+	flows := make([]*Flow, 0)
+	for i, path := range []string{"/upload", "/error"} {
+		u, _ := url.Parse("https://example.com" + path + "?a=1")
+		f := newFlow()
+		f.ConnContext = connCtx
+		f.Request = &Request{Method: "POST", URL: u, Proto: "HTTP/1.1", Header: http.Header{"Content-Type": {"application/octet-stream"}}, Body: []byte{0, 1, 2}}
+		if i == 0 {
+			f.Response = &Response{StatusCode: 201, Header: http.Header{"Set-Cookie": {"a=1", "b=2"}}, Body: []byte("created"), BodyTruncated: true, BodySize: 100}
+		} else {
+			f.Error = errors.New("connection refused")
+		}
+		f.finish()
+		flows = append(flows, f)
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewFlowWriter(buf)
+	for _, f := range flows {
+		handleError(t, w.Write(f))
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(FlowFileMagic)) {
+		t.Fatal("flow file should start with magic")
+	}
+
+	readFlows, err := ReadFlows(buf)
+	handleError(t, err)
+	if len(readFlows) != 2 {
+		t.Fatalf("expected 2 flows, but got %v", len(readFlows))
+	}
+	for i, f := range readFlows {
+		want := flows[i]
+		select {
+		case <-f.Done():
+		default:
+			t.Fatal("flow read should be done")
+		}
+		if f.Id != want.Id || !f.StartTime.Equal(want.StartTime) || !f.EndTime.Equal(want.EndTime) {
+			t.Fatalf("unexpected flow %v %v %v", f.Id, f.StartTime, f.EndTime)
+		}
+		if f.Request.Method != "POST" || f.Request.URL.String() != want.Request.URL.String() || !bytes.Equal(f.Request.Body, []byte{0, 1, 2}) || f.Request.Header.Get("Content-Type") != "application/octet-stream" {
+			t.Fatalf("unexpected request %+v", f.Request)
+		}
+		if f.ConnContext.Id() != connCtx.Id() || !f.ConnContext.ClientConn.Tls || f.ConnContext.ClientConn.Conn.RemoteAddr().String() != "127.0.0.1:50000" {
+			t.Fatalf("unexpected client conn %+v", f.ConnContext.ClientConn)
+		}
+		serverConn := f.ConnContext.ServerConn
+		if serverConn.Id != connCtx.ServerConn.Id || serverConn.Address != "example.com:443" {
+			t.Fatalf("unexpected server conn %+v", serverConn)
+		}
+		if state := serverConn.TlsState(); state == nil || state.Version != tls.VersionTLS13 || len(state.PeerCertificates) != 1 || !state.PeerCertificates[0].Equal(leaf) {
+			t.Fatalf("unexpected server tls state %+v", state)
+		}
+	}
+	if readFlows[0].ConnContext != readFlows[1].ConnContext || readFlows[0].ConnContext.FlowCount.Load() != 2 {
+		t.Fatal("flows of the same connection should share ConnContext")
+	}
+	if resp := readFlows[0].Response; resp.StatusCode != 201 || string(resp.Body) != "created" || len(resp.Header.Values("Set-Cookie")) != 2 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if resp := readFlows[0].Response; !resp.BodyTruncated || resp.BodySize != 100 || readFlows[0].Request.BodyTruncated {
+		t.Fatalf("truncated body should be kept, but got response %v %v, request %v", resp.BodyTruncated, resp.BodySize, readFlows[0].Request.BodyTruncated)
+	}
+	if readFlows[1].Response != nil || readFlows[1].Error == nil || readFlows[1].Error.Error() != "connection refused" {
+		t.Fatalf("unexpected error flow %+v %v", readFlows[1].Response, readFlows[1].Error)
+	}
+}
+
+func TestFlowFileInvalid(t *testing.T) {
+	if flows, err := ReadFlows(strings.NewReader("")); err != nil || len(flows) != 0 {
+		t.Fatalf("empty file should have no flows, but got %v", err)
+	}
+	if _, err := ReadFlows(strings.NewReader(`{"log": {}}`)); !errors.Is(err, ErrFlowFileFormat) {
+		t.Fatalf("expected ErrFlowFileFormat, but got %v", err)
+	}
+
+	u, _ := url.Parse("http://example.com/")
+	f := newFlow()
+	f.Request = &Request{Method: "GET", URL: u, Proto: "HTTP/1.1", Header: make(http.Header)}
+	buf := new(bytes.Buffer)
+	handleError(t, NewFlowWriter(buf).Write(f))
+	data := buf.Bytes()
+	if _, err := ReadFlows(bytes.NewReader(data[:len(data)-1])); err == nil || err == io.EOF {
+		t.Fatalf("truncated file should error, but got %v", err)
+	}
+}
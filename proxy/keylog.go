@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"io"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	"github.com/lqqyt2423/go-mitmproxy/log"
+)
+
+// open key log file from options, fallback to SSLKEYLOGFILE env
+func newTlsKeyLogWriter(opts *Options) (io.Writer, error) {
+	if opts.TlsKeyLogFile == "" {
+		return helper.GetTlsKeyLogWriter(), nil
+	}
+	return helper.NewTlsKeyLogWriter(opts.TlsKeyLogFile)
+}
+
+// record key log lines of one connection, both client-facing and server-facing sessions
+type connKeyLogWriter struct {
+	connCtx *ConnContext
+	out     io.Writer
+}
+
+func (w *connKeyLogWriter) Write(p []byte) (int, error) {
+	w.connCtx.appendTlsKeyLog(string(p))
+	if w.out != nil {
+		if _, err := w.out.Write(p); err != nil {
+			log.Debugf("write tls key log error: %v", err)
+		}
+	}
+	return len(p), nil
+}
+
+func (proxy *Proxy) newConnKeyLogWriter(connCtx *ConnContext) io.Writer {
+	return &connKeyLogWriter{
+		connCtx: connCtx,
+		out:     proxy.tlsKeyLogWriter,
+	}
+}
+
+func (connCtx *ConnContext) appendTlsKeyLog(line string) {
+	connCtx.tlsKeyLogMu.Lock()
+	defer connCtx.tlsKeyLogMu.Unlock()
+	connCtx.tlsKeyLog = append(connCtx.tlsKeyLog, strings.TrimRight(line, "\n"))
+}
+
+// TlsKeyLog returns the NSS key log lines of the connection, without trailing newline.
+// It can be used to decrypt a capture of this connection, e.g. in Wireshark.
+// Sessions of the client shared by flows, e.g. rewritten host and replay, are logged to the connection of the
+// flow which dialed them, not to connections of later flows reusing the pooled session.
+func (connCtx *ConnContext) TlsKeyLog() []string {
+	connCtx.tlsKeyLogMu.Lock()
+	defer connCtx.tlsKeyLogMu.Unlock()
+	lines := make([]string, len(connCtx.tlsKeyLog))
+	copy(lines, connCtx.tlsKeyLog)
+	return lines
+}
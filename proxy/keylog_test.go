@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testKeyLogAddon struct {
+	BaseAddon
+}
+
+func (a *testKeyLogAddon) Response(f *Flow) {
+	f.Response.Header.Add("key-log-lines", strconv.Itoa(len(f.ConnContext.TlsKeyLog())))
+}
+
+func TestTlsKeyLog(t *testing.T) {
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29091",
+	}
+	helper.init(t)
+	httpsEndpoint := helper.httpsEndpoint
+	testProxy := helper.testProxy
+	testProxy.AddAddon(&testKeyLogAddon{})
+	getProxyClient := helper.getProxyClient
+
+	keyLogFile := filepath.Join(t.TempDir(), "keylog.txt")
+	writer, err := newTlsKeyLogWriter(&Options{TlsKeyLogFile: keyLogFile})
+	handleError(t, err)
+	testProxy.tlsKeyLogWriter = writer
+
+	defer helper.tlsPlainLn.Close()
+	go helper.server.Serve(helper.tlsLn)
+	go testProxy.Start()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	resp, _ := testGetResponse(t, httpsEndpoint, getProxyClient())
+	lines, _ := strconv.Atoi(resp.Header.Get("key-log-lines"))
+	// tls 1.3 logs at least 4 secrets for each session, client-facing and server-facing
+	if lines < 8 {
+		t.Fatalf("expected at least 8 key log lines, but got %d", lines)
+	}
+
+	// replay has no client-facing session, secrets of the shared client are logged to the replay connection
+	req, err := http.NewRequest("GET", httpsEndpoint, nil)
+	handleError(t, err)
+	f := testProxy.ReplayRequest(newRequest(req))
+	if f.Error != nil {
+		t.Fatal(f.Error)
+	}
+	if lines := len(f.ConnContext.TlsKeyLog()); lines < 4 {
+		t.Fatalf("expected at least 4 key log lines of replay, but got %d", lines)
+	}
+
+	content, err := os.ReadFile(keyLogFile)
+	handleError(t, err)
+	if !strings.Contains(string(content), "CLIENT_HANDSHAKE_TRAFFIC_SECRET") {
+		t.Fatalf("key log file should contain secrets, but got %s", content)
+	}
+}
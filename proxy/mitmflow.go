@@ -0,0 +1,524 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/internal/tnetstring"
+)
+
+// flow files of python mitmproxy, written by mitmproxy -w or saved in mitmweb, a stream of tnetstring dicts.
+// Only http flows are mapped, other flows like tcp or dns are skipped when read.
+// reference
+// https://github.com/mitmproxy/mitmproxy/blob/main/mitmproxy/io/io.py
+// https://github.com/mitmproxy/mitmproxy/blob/main/mitmproxy/io/compat.py
+
+// MitmproxyFlowFormatVersion version of written flows, mitmproxy 10
+const MitmproxyFlowFormatVersion = 20
+
+var errMitmproxyFlow = errors.New("invalid mitmproxy flow")
+
+// tls versions named by openssl, as in mitmproxy
+var mitmproxyTlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLSv1",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+// MitmproxyFlowWriter writes flows in the mitmproxy flow format, safe for concurrent use
+type MitmproxyFlowWriter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func NewMitmproxyFlowWriter(w io.Writer) *MitmproxyFlowWriter {
+	return &MitmproxyFlowWriter{w: w}
+}
+
+// Write writes f with f.Request.Body and f.Response.Body as bodies, BodyReader is not read
+func (mw *MitmproxyFlowWriter) Write(f *Flow) error {
+	var reqBody, respBody []byte
+	if f.Request != nil {
+		reqBody = f.Request.Body
+	}
+	if f.Response != nil {
+		respBody = f.Response.Body
+	}
+	state, err := mitmproxyFlowState(newFlowRecord(f), reqBody, respBody)
+	if err != nil {
+		return err
+	}
+	data, err := tnetstring.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	_, err = mw.w.Write(data)
+	return err
+}
+
+// MitmproxyFlowReader reads http flows of mitmproxy flow files
+type MitmproxyFlowReader struct {
+	d     *tnetstring.Decoder
+	conns flowFileConns
+}
+
+func NewMitmproxyFlowReader(r io.Reader) *MitmproxyFlowReader {
+	return &MitmproxyFlowReader{
+		d:     tnetstring.NewDecoder(r),
+		conns: make(flowFileConns),
+	}
+}
+
+// Read returns the next http flow, which is finished, io.EOF at the end of file
+func (mr *MitmproxyFlowReader) Read() (*Flow, error) {
+	for {
+		v, err := mr.d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		state, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", errMitmproxyFlow, v)
+		}
+		if typ := mitmproxyString(state["type"]); typ != "http" {
+			continue
+		}
+		record, reqBody, respBody, err := newMitmproxyFlowRecord(state)
+		if err != nil {
+			return nil, err
+		}
+		return mr.conns.newFlow(record, reqBody, respBody)
+	}
+}
+
+// ReadMitmproxyFlows reads all http flows from r in the mitmproxy flow format
+func ReadMitmproxyFlows(r io.Reader) ([]*Flow, error) {
+	mr := NewMitmproxyFlowReader(r)
+	flows := make([]*Flow, 0)
+	for {
+		f, err := mr.Read()
+		if err == io.EOF {
+			return flows, nil
+		}
+		if err != nil {
+			return flows, err
+		}
+		flows = append(flows, f)
+	}
+}
+
+// flow record to mitmproxy
+
+func mitmproxyFlowState(record *flowRecord, reqBody []byte, respBody []byte) (map[string]interface{}, error) {
+	if record.Request == nil {
+		return nil, errors.New("flow without request")
+	}
+	u, err := url.Parse(record.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	start := record.StartTime
+	if start.IsZero() {
+		start = time.Now()
+	}
+	responseStart := record.ResponseTime
+	if responseStart.IsZero() {
+		responseStart = start
+	}
+	end := record.EndTime
+	if end.IsZero() {
+		end = responseStart
+	}
+
+	port, _ := strconv.Atoi(u.Port())
+	if port == 0 {
+		port = 80
+		if u.Scheme == "https" {
+			port = 443
+		}
+	}
+	proto := record.Request.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	request := map[string]interface{}{
+		"host":            u.Hostname(),
+		"port":            port,
+		"method":          []byte(record.Request.Method),
+		"scheme":          []byte(u.Scheme),
+		"authority":       []byte{},
+		"path":            []byte(u.RequestURI()),
+		"http_version":    []byte(proto),
+		"headers":         mitmproxyHeaders(record.Request.Header),
+		"content":         mitmproxyContent(reqBody),
+		"trailers":        nil,
+		"timestamp_start": mitmproxyTimestamp(start),
+		"timestamp_end":   mitmproxyTimestamp(start),
+	}
+
+	var response interface{}
+	if r := record.Response; r != nil {
+		response = map[string]interface{}{
+			"http_version":    []byte(proto),
+			"status_code":     r.StatusCode,
+			"reason":          []byte(http.StatusText(r.StatusCode)),
+			"headers":         mitmproxyHeaders(r.Header),
+			"content":         mitmproxyContent(respBody),
+			"trailers":        nil,
+			"timestamp_start": mitmproxyTimestamp(responseStart),
+			"timestamp_end":   mitmproxyTimestamp(end),
+		}
+	}
+
+	var flowErr interface{}
+	if record.Error != "" {
+		flowErr = map[string]interface{}{
+			"msg":       record.Error,
+			"timestamp": mitmproxyTimestamp(end),
+		}
+	}
+
+	clientConn := mitmproxyConn(uuid.New().String(), start, end)
+	clientConn["mitmcert"] = nil
+	clientConn["proxy_mode"] = "regular"
+	if c := record.ClientConn; c != nil {
+		clientConn["id"] = c.Id.String()
+		clientConn["peername"] = mitmproxyAddress(c.Address)
+		clientConn["sockname"] = mitmproxyAddress(c.LocalAddress)
+		clientConn["tls"] = c.Tls
+		if c.NegotiatedProtocol != "" {
+			clientConn["alpn"] = []byte(c.NegotiatedProtocol)
+		}
+		if c.Tls {
+			clientConn["timestamp_tls_setup"] = mitmproxyTimestamp(start)
+		}
+	}
+
+	serverConn := mitmproxyConn(uuid.New().String(), start, end)
+	serverConn["address"] = nil
+	serverConn["timestamp_tcp_setup"] = nil
+	serverConn["via"] = nil
+	if c := record.ServerConn; c != nil {
+		serverConn["id"] = c.Id.String()
+		serverConn["address"] = mitmproxyAddress(c.Address)
+		serverConn["timestamp_tcp_setup"] = mitmproxyTimestamp(start)
+		if c.PeerAddress != "" {
+			serverConn["peername"] = mitmproxyAddress(c.PeerAddress)
+		}
+		if t := c.Tls; t != nil {
+			serverConn["tls"] = true
+			serverConn["timestamp_tls_setup"] = mitmproxyTimestamp(start)
+			if name, ok := mitmproxyTlsVersions[t.Version]; ok {
+				serverConn["tls_version"] = name
+			}
+			if t.CipherSuite != 0 {
+				serverConn["cipher"] = tls.CipherSuiteName(t.CipherSuite)
+			}
+			if t.ServerName != "" {
+				serverConn["sni"] = t.ServerName
+			}
+			if t.NegotiatedProtocol != "" {
+				serverConn["alpn"] = []byte(t.NegotiatedProtocol)
+			}
+			certs := make([]interface{}, 0, len(t.Certificates))
+			for _, der := range t.Certificates {
+				certs = append(certs, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+			}
+			serverConn["certificate_list"] = certs
+		}
+	}
+
+	return map[string]interface{}{
+		"version":           MitmproxyFlowFormatVersion,
+		"type":              "http",
+		"id":                record.Id.String(),
+		"error":             flowErr,
+		"client_conn":       clientConn,
+		"server_conn":       serverConn,
+		"intercepted":       false,
+		"is_replay":         nil,
+		"marked":            "",
+		"metadata":          map[string]interface{}{},
+		"comment":           "",
+		"timestamp_created": mitmproxyTimestamp(start),
+		"request":           request,
+		"response":          response,
+		"websocket":         nil,
+	}, nil
+}
+
+// mitmproxyConn fields shared by client and server connections
+func mitmproxyConn(id string, start time.Time, end time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                  id,
+		"peername":            nil,
+		"sockname":            nil,
+		"transport_protocol":  "tcp",
+		"error":               nil,
+		"tls":                 false,
+		"certificate_list":    []interface{}{},
+		"alpn":                nil,
+		"alpn_offers":         []interface{}{},
+		"cipher":              nil,
+		"cipher_list":         []interface{}{},
+		"tls_version":         nil,
+		"sni":                 nil,
+		"timestamp_start":     mitmproxyTimestamp(start),
+		"timestamp_end":       mitmproxyTimestamp(end),
+		"timestamp_tls_setup": nil,
+	}
+}
+
+func mitmproxyTimestamp(t time.Time) float64 {
+	return float64(t.UnixMicro()) / 1e6
+}
+
+// mitmproxyAddress (host, port)
+func mitmproxyAddress(addr string) interface{} {
+	if addr == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []interface{}{addr, 0}
+	}
+	p, _ := strconv.Atoi(port)
+	return []interface{}{host, p}
+}
+
+// mitmproxyHeaders list of (name, value), in order of names
+func mitmproxyHeaders(header http.Header) []interface{} {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]interface{}, 0, len(header))
+	for _, name := range names {
+		for _, value := range header[name] {
+			fields = append(fields, []interface{}{[]byte(name), []byte(value)})
+		}
+	}
+	return fields
+}
+
+func mitmproxyContent(body []byte) []byte {
+	if body == nil {
+		return []byte{}
+	}
+	return body
+}
+
+// mitmproxy to flow record
+
+func newMitmproxyFlowRecord(state map[string]interface{}) (record *flowRecord, reqBody []byte, respBody []byte, err error) {
+	request, ok := state["request"].(map[string]interface{})
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("%w: no request", errMitmproxyFlow)
+	}
+
+	scheme := mitmproxyString(request["scheme"])
+	host := mitmproxyString(request["host"])
+	port := mitmproxyInt(request["port"])
+	path := mitmproxyString(request["path"])
+	if scheme == "" || host == "" {
+		return nil, nil, nil, fmt.Errorf("%w: no scheme or host of request", errMitmproxyFlow)
+	}
+	if (scheme == "http" && port != 80) || (scheme == "https" && port != 443) {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+		host = "[" + host + "]"
+	}
+	if path == "" || path[0] != '/' {
+		path = "/" + path
+	}
+
+	record = &flowRecord{
+		Id:        mitmproxyId(state["id"]),
+		StartTime: mitmproxyTime(request["timestamp_start"]),
+		EndTime:   mitmproxyTime(request["timestamp_end"]),
+		Request: &flowRecordRequest{
+			Method: mitmproxyString(request["method"]),
+			URL:    scheme + "://" + host + path,
+			Proto:  mitmproxyString(request["http_version"]),
+			Header: mitmproxyHeader(request["headers"]),
+		},
+	}
+	reqBody = mitmproxyBytes(request["content"])
+	record.Request.BodySize = len(reqBody)
+
+	if response, ok := state["response"].(map[string]interface{}); ok {
+		record.Response = &flowRecordResponse{
+			StatusCode: mitmproxyInt(response["status_code"]),
+			Header:     mitmproxyHeader(response["headers"]),
+		}
+		respBody = mitmproxyBytes(response["content"])
+		record.Response.BodySize = len(respBody)
+		record.ResponseTime = mitmproxyTime(response["timestamp_start"])
+		if end := mitmproxyTime(response["timestamp_end"]); !end.IsZero() {
+			record.EndTime = end
+		}
+	}
+
+	if flowErr, ok := state["error"].(map[string]interface{}); ok {
+		record.Error = mitmproxyString(flowErr["msg"])
+	}
+
+	if c, ok := state["client_conn"].(map[string]interface{}); ok {
+		record.ClientConn = &flowRecordClientConn{
+			Id:                 mitmproxyId(c["id"]),
+			Address:            mitmproxyAddressString(c["peername"], c["address"]),
+			LocalAddress:       mitmproxyAddressString(c["sockname"]),
+			Tls:                mitmproxyBool(c["tls"]) || mitmproxyBool(c["tls_established"]),
+			NegotiatedProtocol: mitmproxyString(c["alpn"]),
+		}
+	}
+
+	if c, ok := state["server_conn"].(map[string]interface{}); ok {
+		address := mitmproxyAddressString(c["address"])
+		if address != "" {
+			record.ServerConn = &flowRecordServerConn{
+				Id:          mitmproxyId(c["id"]),
+				Address:     address,
+				PeerAddress: mitmproxyAddressString(c["peername"], c["ip_address"]),
+			}
+			tlsVersion := mitmproxyString(c["tls_version"])
+			if mitmproxyBool(c["tls"]) || mitmproxyBool(c["tls_established"]) || tlsVersion != "" {
+				cipher := mitmproxyString(c["cipher"])
+				if cipher == "" {
+					cipher = mitmproxyString(c["cipher_name"]) // before mitmproxy 7
+				}
+				record.ServerConn.Tls = &flowRecordTls{
+					CipherSuite:        mitmproxyCipherSuite(cipher),
+					ServerName:         mitmproxyString(c["sni"]),
+					NegotiatedProtocol: mitmproxyString(c["alpn"]),
+				}
+				for version, name := range mitmproxyTlsVersions {
+					if name == tlsVersion {
+						record.ServerConn.Tls.Version = version
+					}
+				}
+				if certs, ok := c["certificate_list"].([]interface{}); ok {
+					for _, cert := range certs {
+						if block, _ := pem.Decode(mitmproxyBytes(cert)); block != nil {
+							record.ServerConn.Tls.Certificates = append(record.ServerConn.Tls.Certificates, block.Bytes)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return record, reqBody, respBody, nil
+}
+
+func mitmproxyString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+func mitmproxyBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	}
+	return nil
+}
+
+func mitmproxyInt(v interface{}) int {
+	switch v := v.(type) {
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+func mitmproxyBool(v interface{}) bool {
+	b, _ := v.(bool)
+	return b
+}
+
+func mitmproxyTime(v interface{}) time.Time {
+	switch v := v.(type) {
+	case int64:
+		return time.Unix(v, 0)
+	case float64:
+		return time.UnixMicro(int64(v * 1e6))
+	}
+	return time.Time{}
+}
+
+// mitmproxyId ids are uuid strings, others are mapped to uuid
+func mitmproxyId(v interface{}) uuid.UUID {
+	s := mitmproxyString(v)
+	if id, err := uuid.Parse(s); err == nil {
+		return id
+	}
+	if s == "" {
+		return uuid.New()
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(s))
+}
+
+// mitmproxyAddressString host:port of the first (host, port) in vs
+func mitmproxyAddressString(vs ...interface{}) string {
+	for _, v := range vs {
+		addr, ok := v.([]interface{})
+		if !ok || len(addr) < 2 {
+			continue
+		}
+		return net.JoinHostPort(mitmproxyString(addr[0]), strconv.Itoa(mitmproxyInt(addr[1])))
+	}
+	return ""
+}
+
+func mitmproxyHeader(v interface{}) http.Header {
+	header := make(http.Header)
+	fields, _ := v.([]interface{})
+	for _, field := range fields {
+		kv, ok := field.([]interface{})
+		if !ok || len(kv) != 2 {
+			continue
+		}
+		header.Add(mitmproxyString(kv[0]), mitmproxyString(kv[1]))
+	}
+	return header
+}
+
+func mitmproxyCipherSuite(name string) uint16 {
+	if name == "" {
+		return 0
+	}
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			if suite.Name == name {
+				return suite.ID
+			}
+		}
+	}
+	return 0
+}
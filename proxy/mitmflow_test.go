@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/tnetstring"
+)
+
+func TestMitmproxyFlowRoundTrip(t *testing.T) {
+	connCtx := newConnContext(&flowFileConn{local: flowFileAddr("127.0.0.1:9080"), remote: flowFileAddr("127.0.0.1:50000")}, nil)
+	connCtx.ClientConn.Tls = true
+	connCtx.ServerConn = newServerConn()
+	connCtx.ServerConn.Address = "example.com:8443"
+	connCtx.ServerConn.tlsState = &tls.ConnectionState{
+		Version:     tls.VersionTLS12,
+		CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		ServerName:  "example.com",
+	}
+
+	u, _ := url.Parse("https://example.com:8443/upload?a=1")
+	f := newFlow()
+	f.ConnContext = connCtx
+	f.Request = &Request{Method: "POST", URL: u, Proto: "HTTP/1.1", Header: http.Header{"Content-Type": {"text/plain"}}, Body: []byte("hello")}
+	f.Response = &Response{StatusCode: 201, Header: http.Header{"Set-Cookie": {"a=1", "b=2"}}, Body: []byte{0xff, 0xfe}}
+	f.finish()
+
+	buf := new(bytes.Buffer)
+	handleError(t, NewMitmproxyFlowWriter(buf).Write(f))
+	flows, err := ReadMitmproxyFlows(buf)
+	handleError(t, err)
+	if len(flows) != 1 {
+		t.Fatalf("expected 1 flow, but got %v", len(flows))
+	}
+
+	g := flows[0]
+	if g.Id != f.Id || g.StartTime.UnixMicro() != f.StartTime.UnixMicro() || g.EndTime.UnixMicro() != f.EndTime.UnixMicro() {
+		t.Fatalf("unexpected flow %v %v %v", g.Id, g.StartTime, g.EndTime)
+	}
+	if g.Request.URL.String() != u.String() || g.Request.Method != "POST" || string(g.Request.Body) != "hello" || g.Request.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("unexpected request %+v", g.Request)
+	}
+	if g.Response.StatusCode != 201 || !bytes.Equal(g.Response.Body, []byte{0xff, 0xfe}) || len(g.Response.Header.Values("Set-Cookie")) != 2 {
+		t.Fatalf("unexpected response %+v", g.Response)
+	}
+	if g.ConnContext.Id() != connCtx.Id() || !g.ConnContext.ClientConn.Tls || g.ConnContext.ClientConn.Conn.RemoteAddr().String() != "127.0.0.1:50000" {
+		t.Fatalf("unexpected client conn %+v", g.ConnContext.ClientConn)
+	}
+	state := g.ConnContext.ServerConn.TlsState()
+	if g.ConnContext.ServerConn.Address != "example.com:8443" || state == nil || state.Version != tls.VersionTLS12 || state.CipherSuite != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("unexpected server conn %+v %+v", g.ConnContext.ServerConn, state)
+	}
+}
+
+func TestReadMitmproxyFlows(t *testing.T) {
+	buf := new(bytes.Buffer)
+	for _, state := range []map[string]interface{}{
+		{
+			"version": 20,
+			"type":    "tcp",
+			"id":      "0b0e3d38-2c8a-4a8d-a17e-6a3b2d0fbb0b",
+		},
+		{
+			"version": 20,
+			"type":    "http",
+			"id":      "not-uuid",
+			"error":   map[string]interface{}{"msg": "Connection killed.", "timestamp": 1700000001.5},
+			"client_conn": map[string]interface{}{
+				"id":       "c1",
+				"peername": []interface{}{"::1", 51000},
+				"sockname": []interface{}{"::1", 8080},
+				"tls":      false,
+			},
+			"server_conn": map[string]interface{}{
+				"id":       "s1",
+				"address":  []interface{}{"example.com", 80},
+				"peername": []interface{}{"93.184.216.34", 80},
+			},
+			"request": map[string]interface{}{
+				"host":            "example.com",
+				"port":            80,
+				"method":          []byte("GET"),
+				"scheme":          []byte("http"),
+				"authority":       []byte(""),
+				"path":            []byte("/index.html?q=1"),
+				"http_version":    []byte("HTTP/1.1"),
+				"headers":         []interface{}{[]interface{}{[]byte("host"), []byte("example.com")}, []interface{}{[]byte("accept"), []byte("*/*")}},
+				"content":         []byte(""),
+				"timestamp_start": 1700000000.25,
+				"timestamp_end":   1700000000.5,
+			},
+			"response": nil,
+		},
+	} {
+		data, err := tnetstring.Marshal(state)
+		handleError(t, err)
+		buf.Write(data)
+	}
+
+	flows, err := ReadMitmproxyFlows(buf)
+	handleError(t, err)
+	if len(flows) != 1 {
+		t.Fatalf("tcp flow should be skipped, but got %v flows", len(flows))
+	}
+	f := flows[0]
+	if f.Request.URL.String() != "http://example.com/index.html?q=1" || f.Request.Header.Get("Accept") != "*/*" || f.Response != nil {
+		t.Fatalf("unexpected flow %+v %+v", f.Request, f.Response)
+	}
+	if f.Error == nil || f.Error.Error() != "Connection killed." || f.StartTime.UnixMilli() != 1700000000250 {
+		t.Fatalf("unexpected error or start time %v %v", f.Error, f.StartTime)
+	}
+	if f.ConnContext.ClientConn.Conn.RemoteAddr().String() != "[::1]:51000" || f.ConnContext.ServerConn.Address != "example.com:80" || f.ConnContext.ServerConn.TlsState() != nil {
+		t.Fatalf("unexpected connections %+v %+v", f.ConnContext.ClientConn, f.ConnContext.ServerConn)
+	}
+
+	if _, err := ReadMitmproxyFlows(bytes.NewReader([]byte("5:hello,"))); !errors.Is(err, errMitmproxyFlow) {
+		t.Fatalf("expected invalid mitmproxy flow, but got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -25,10 +26,18 @@ type Options struct {
 	SslInsecure       bool
 	CaRootPath        string
 	NewCaFunc         func() (cert.CA, error) //创建 Ca 的函数
+	CertOptions       *cert.Options           // 默认 Ca 的证书生成选项，为空时使用 cert.DefaultOptions
 	Upstream          string
 	ShutdownTimeout   time.Duration // 服务关闭超时时间
+	ClientCerts       []*ClientCert // 上游服务器要求客户端证书时(mTLS)，按 host 匹配提供的证书
+	CaBundle          string        // 额外信任的上游服务器 CA 证书文件或文件夹(PEM)，与系统根证书一同使用
+	CertPins          []*CertPin    // 按 host 固定上游服务器证书的 SPKI 哈希
+	TlsKeyLogFile     string        // TLS key log 文件(NSS 格式，可用于 Wireshark 解密)，为空时使用环境变量 SSLKEYLOGFILE
 }
 
+// Version of go-mitmproxy
+const Version = "1.8.5"
+
 type StartCallback func(net.Listener) error
 
 type ShutdownCallback func()
@@ -46,6 +55,8 @@ type Proxy struct {
 
 	entry           *entry
 	attacker        *attacker
+	rootCAs         *x509.CertPool                            // upstream trust store, nil means system roots
+	tlsKeyLogWriter io.Writer                                 // global tls key log writer, may be nil
 	shouldIntercept func(req *http.Request) bool              // req is received by proxy.server
 	upstreamProxy   func(req *http.Request) (*url.URL, error) // req is received by proxy.server, not client request
 }
@@ -60,12 +71,26 @@ func NewProxy(opts *Options) (*Proxy, error) {
 
 	proxy := &Proxy{
 		Opts:      opts,
-		Version:   "1.8.5",
+		Version:   Version,
 		Addons:    make([]Addon, 0),
 		errorChan: make(chan error, 1),
 		quitChan:  make(chan os.Signal, 1),
 	}
 
+	if err := loadClientCerts(opts.ClientCerts); err != nil {
+		return nil, err
+	}
+	rootCAs, err := loadRootCAs(opts.CaBundle)
+	if err != nil {
+		return nil, err
+	}
+	proxy.rootCAs = rootCAs
+	tlsKeyLogWriter, err := newTlsKeyLogWriter(opts)
+	if err != nil {
+		return nil, err
+	}
+	proxy.tlsKeyLogWriter = tlsKeyLogWriter
+
 	proxy.entry = newEntry(proxy)
 
 	attacker, err := newAttacker(proxy)
@@ -171,8 +196,12 @@ func (proxy *Proxy) DoRequest(w http.ResponseWriter, r *http.Request) {
 	atk.attack(w, req)
 }
 
+// GetCertificate returns the root certificate of ca, empty certificate when ca has no root, e.g. cert.TrustedCA
 func (proxy *Proxy) GetCertificate() x509.Certificate {
-	return *proxy.attacker.ca.GetRootCA()
+	if root := proxy.attacker.ca.GetRootCA(); root != nil {
+		return *root
+	}
+	return x509.Certificate{}
 }
 
 func (proxy *Proxy) GetCertificateByCN(commonName string) (*tls.Certificate, error) {
@@ -225,13 +254,6 @@ func (proxy *Proxy) fireOnShutdown() {
 	}
 }
 
-func (proxy *Proxy) realUpstreamProxy() func(*http.Request) (*url.URL, error) {
-	return func(cReq *http.Request) (*url.URL, error) {
-		req := cReq.Context().Value(proxyReqCtxKey).(*http.Request)
-		return proxy.getUpstreamProxyUrl(req)
-	}
-}
-
 func (proxy *Proxy) getUpstreamProxyUrl(req *http.Request) (*url.URL, error) {
 	if proxy.upstreamProxy != nil {
 		return proxy.upstreamProxy(req)
@@ -244,14 +266,18 @@ func (proxy *Proxy) getUpstreamProxyUrl(req *http.Request) (*url.URL, error) {
 }
 
 func (proxy *Proxy) getUpstreamConn(ctx context.Context, req *http.Request) (net.Conn, error) {
+	return proxy.dialUpstream(ctx, req, helper.CanonicalAddr(req.URL))
+}
+
+// dial address through the upstream proxy chosen by req
+func (proxy *Proxy) dialUpstream(ctx context.Context, req *http.Request, address string) (net.Conn, error) {
 	proxyUrl, err := proxy.getUpstreamProxyUrl(req)
 	if err != nil {
 		return nil, err
 	}
 	var conn net.Conn
-	address := helper.CanonicalAddr(req.URL)
 	if proxyUrl != nil {
-		conn, err = helper.GetProxyConn(ctx, proxyUrl, address, proxy.Opts.SslInsecure)
+		conn, err = helper.GetProxyConn(ctx, proxyUrl, address, proxy.newUpstreamTlsConfig(proxyUrl.Hostname()))
 	} else {
 		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", address)
 	}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/log"
+	"github.com/timandy/routine"
+)
+
+// replay requests loaded from captures, e.g. HAR files, flows go through addons and upstream same as flows from clients
+
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
+
+// replayConn client connection of replayed flows, nothing to read or write
+type replayConn struct{}
+
+func (replayConn) Read([]byte) (int, error)         { return 0, io.EOF }
+func (replayConn) Write([]byte) (int, error)        { return 0, net.ErrClosed }
+func (replayConn) Close() error                     { return nil }
+func (replayConn) LocalAddr() net.Addr              { return replayAddr{} }
+func (replayConn) RemoteAddr() net.Addr             { return replayAddr{} }
+func (replayConn) SetDeadline(time.Time) error      { return nil }
+func (replayConn) SetReadDeadline(time.Time) error  { return nil }
+func (replayConn) SetWriteDeadline(time.Time) error { return nil }
+
+type ReplayOptions struct {
+	Concurrency int           // number of requests in flight, default 1: one by one
+	Delay       time.Duration // interval between starting two requests
+	Ordered     bool          // call back flows in the order of requests instead of the order of completion
+}
+
+// ReplayRequest sends req through addons and upstream, returns the finished flow with the full response body.
+// Only Method, URL, Proto, Header and Body of req are used.
+func (proxy *Proxy) ReplayRequest(req *Request) *Flow {
+	connCtx := newConnContext(replayConn{}, proxy)
+	connCtx.ClientConn.Tls = req.URL.Scheme == "https"
+	connCtx.Intercept = true
+	for _, addon := range proxy.Addons {
+		addon.ClientConnected(connCtx.ClientConn)
+	}
+	defer func() {
+		for _, addon := range proxy.Addons {
+			addon.ClientDisconnected(connCtx.ClientConn)
+		}
+	}()
+
+	f := newFlow()
+	f.ConnContext = connCtx
+	f.UseSeparateClient = true // no server connection bound to the replay connection
+	defer f.finish()
+
+	ctx := context.WithValue(context.Background(), connContextKey, connCtx)
+	rawReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL.String(), bytes.NewReader(req.Body))
+	if err != nil {
+		f.Request = req
+		f.Error = err
+		f.Response = &Response{StatusCode: 502}
+		return f
+	}
+	rawReq.Header = req.Header.Clone()
+	if rawReq.Header == nil {
+		rawReq.Header = make(http.Header)
+	}
+	if req.Proto != "" {
+		rawReq.Proto = req.Proto
+	}
+	f.Request = newRequest(rawReq)
+	f.Request.Body = req.Body
+	connCtx.FlowCount.Add(1)
+
+	// never send the cut body as the complete one
+	if req.BodyTruncated {
+		f.Error = fmt.Errorf("request body of %v bytes truncated to %v bytes", req.BodySize, len(req.Body))
+		f.Response = &Response{StatusCode: 502}
+		return f
+	}
+
+	proxy.attacker.replay(f)
+	return f
+}
+
+func (a *attacker) replay(f *Flow) {
+	// when addons panic
+	defer func() {
+		if err := recover(); err != nil {
+			log.Warnf("Recovered: %v", routine.NewRuntimeError(err))
+			if f.Response == nil {
+				f.Response = &Response{StatusCode: 502}
+			}
+		}
+	}()
+
+	a.execute(f)
+
+	// read the streamed body, so the flow holds the full response
+	if f.Response.BodyReader != nil {
+		body, err := io.ReadAll(f.Response.BodyReader)
+		if closer, ok := f.Response.BodyReader.(io.Closer); ok {
+			_ = closer.Close()
+		}
+		f.Response.BodyReader = nil
+		if err != nil {
+			log.Errorf("replay read response body error: %v", err)
+			f.Error = err
+		}
+		f.Response.Body = append(f.Response.Body, body...)
+	}
+}
+
+// Replay sends reqs with ReplayRequest, done is called with each finished flow, calls of done are not concurrent.
+// Replay returns when all flows are done.
+func (proxy *Proxy) Replay(reqs []*Request, opts *ReplayOptions, done func(*Flow)) {
+	if opts == nil {
+		opts = &ReplayOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		mu      sync.Mutex
+		next    int
+		pending = make(map[int]*Flow)
+	)
+	complete := func(i int, f *Flow) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !opts.Ordered {
+			done(f)
+			return
+		}
+		pending[i] = f
+		for {
+			f, ok := pending[next]
+			if !ok {
+				return
+			}
+			delete(pending, next)
+			next++
+			done(f)
+		}
+	}
+
+	indexes := make(chan int)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				complete(i, proxy.ReplayRequest(reqs[i]))
+			}
+		}()
+	}
+	for i := range reqs {
+		if i > 0 && opts.Delay > 0 {
+			time.Sleep(opts.Delay)
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
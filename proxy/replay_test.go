@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/cert"
+)
+
+type testReplayAddon struct {
+	BaseAddon
+	requests atomic.Int32
+}
+
+func (a *testReplayAddon) Request(f *Flow) {
+	a.requests.Add(1)
+	f.Request.Header.Set("X-Replay", "1")
+}
+
+func TestReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i, _ := strconv.Atoi(r.URL.Query().Get("i"))
+		// later requests finish first
+		time.Sleep(time.Millisecond * time.Duration(10-i*2))
+		fmt.Fprintf(w, "%v %v", r.URL.Query().Get("i"), r.Header.Get("X-Replay"))
+	}))
+	defer server.Close()
+
+	testProxy, err := NewProxy(&Options{
+		NewCaFunc: func() (cert.CA, error) { return cert.NewSelfSignCAMemory() },
+	})
+	handleError(t, err)
+	addon := &testReplayAddon{}
+	testProxy.AddAddon(addon)
+
+	reqs := make([]*Request, 0)
+	for i := 0; i < 5; i++ {
+		u, _ := url.Parse(fmt.Sprintf("%v/?i=%v", server.URL, i))
+		reqs = append(reqs, &Request{Method: "GET", URL: u, Proto: "HTTP/1.1", Header: make(http.Header)})
+	}
+
+	flows := make([]*Flow, 0)
+	testProxy.Replay(reqs, &ReplayOptions{Concurrency: 5, Ordered: true}, func(f *Flow) {
+		flows = append(flows, f)
+	})
+	if len(flows) != 5 || addon.requests.Load() != 5 {
+		t.Fatalf("expected 5 flows through addons, but got %v flows, %v requests", len(flows), addon.requests.Load())
+	}
+	for i, f := range flows {
+		if f.Error != nil {
+			t.Fatal(f.Error)
+		}
+		select {
+		case <-f.Done():
+		default:
+			t.Fatal("flow should be done")
+		}
+		expected := fmt.Sprintf("%v 1", i)
+		if f.Response.StatusCode != 200 || string(f.Response.Body) != expected {
+			t.Fatalf("expected %q, but got %v %q", expected, f.Response.StatusCode, f.Response.Body)
+		}
+	}
+
+	// truncated request body is never sent
+	f := testProxy.ReplayRequest(&Request{Method: "POST", URL: reqs[0].URL, Proto: "HTTP/1.1", Header: make(http.Header), Body: []byte("a"), BodyTruncated: true, BodySize: 10})
+	if f.Error == nil || f.Response.StatusCode != 502 || addon.requests.Load() != 5 {
+		t.Fatalf("expected truncated request not sent, but got %v %v", f.Response.StatusCode, f.Error)
+	}
+}
@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/lqqyt2423/go-mitmproxy/internal/helper"
+	"github.com/samber/lo"
+)
+
+// CertPin pin upstream server certificates by SPKI hash
+type CertPin struct {
+	Hosts []string // hostname patterns without port, e.g. *.example.com
+	SPKI  []string // base64 encoded sha256 of SubjectPublicKeyInfo, same as HPKP pin-sha256
+}
+
+// upstream certificate verify failed reasons
+const (
+	UpstreamCertUnknownAuthority = "unknown_authority"
+	UpstreamCertHostnameMismatch = "hostname_mismatch"
+	UpstreamCertExpired          = "expired"
+	UpstreamCertPinMismatch      = "pin_mismatch"
+	UpstreamCertInvalid          = "invalid"
+)
+
+// UpstreamCertError upstream server certificate verify failed
+type UpstreamCertError struct {
+	Host   string
+	Reason string // one of UpstreamCert* reasons
+	Err    error
+}
+
+func (e *UpstreamCertError) Error() string {
+	return fmt.Sprintf("upstream %v certificate %v: %v", e.Host, e.Reason, e.Err)
+}
+
+func (e *UpstreamCertError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UpstreamCertError) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{})
+	m["type"] = "upstream_cert"
+	m["host"] = e.Host
+	m["reason"] = e.Reason
+	m["message"] = e.Err.Error()
+	return json.Marshal(m)
+}
+
+type certPinError struct {
+	host string
+}
+
+func (e *certPinError) Error() string {
+	return fmt.Sprintf("no certificate of %v matches the pinned SPKI hashes", e.host)
+}
+
+// newUpstreamCertError returns nil when err is not caused by certificate verification
+func newUpstreamCertError(host string, err error) *UpstreamCertError {
+	if err == nil {
+		return nil
+	}
+	var certErr *UpstreamCertError
+	if errors.As(err, &certErr) {
+		return certErr
+	}
+
+	var (
+		unknownAuthorityErr x509.UnknownAuthorityError
+		hostnameErr         x509.HostnameError
+		invalidErr          x509.CertificateInvalidError
+		pinErr              *certPinError
+		verifyErr           *tls.CertificateVerificationError
+	)
+	reason := ""
+	switch {
+	case errors.As(err, &pinErr):
+		reason = UpstreamCertPinMismatch
+	case errors.As(err, &unknownAuthorityErr):
+		reason = UpstreamCertUnknownAuthority
+	case errors.As(err, &hostnameErr):
+		reason = UpstreamCertHostnameMismatch
+	case errors.As(err, &invalidErr):
+		if invalidErr.Reason == x509.Expired {
+			reason = UpstreamCertExpired
+		} else {
+			reason = UpstreamCertInvalid
+		}
+	case errors.As(err, &verifyErr):
+		reason = UpstreamCertInvalid
+	default:
+		return nil
+	}
+	return &UpstreamCertError{Host: host, Reason: reason, Err: err}
+}
+
+// load system roots and the extra ca bundle file or directory
+func loadRootCAs(caBundle string) (*x509.CertPool, error) {
+	if caBundle == "" {
+		return nil, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	stat, err := os.Stat(caBundle)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{caBundle}
+	if stat.IsDir() {
+		entries, err := os.ReadDir(caBundle)
+		if err != nil {
+			return nil, err
+		}
+		files = make([]string, 0)
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				files = append(files, filepath.Join(caBundle, entry.Name()))
+			}
+		}
+	}
+
+	count := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if pool.AppendCertsFromPEM(data) {
+			count++
+		}
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("no certificate found in ca bundle %v", caBundle)
+	}
+	return pool, nil
+}
+
+// SPKIHash returns the base64 encoded sha256 of certificate's SubjectPublicKeyInfo
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (proxy *Proxy) findCertPins(hostname string) []string {
+	pins := make([]string, 0)
+	for _, pin := range proxy.Opts.CertPins {
+		if helper.MatchHost(hostname, pin.Hosts) {
+			pins = append(pins, pin.SPKI...)
+		}
+	}
+	return pins
+}
+
+// verify pinned SPKI hashes of host, any certificate in the chain matches is ok
+func (proxy *Proxy) verifyCertPins(host string, certs []*x509.Certificate) error {
+	pins := proxy.findCertPins(host)
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, cert := range certs {
+		if lo.Contains(pins, SPKIHash(cert)) {
+			return nil
+		}
+	}
+	return &certPinError{host: host}
+}
+
+// hostname of address host:port
+func addressHostname(address string) string {
+	return (&url.URL{Host: address}).Hostname()
+}
+
+// base tls config used by every connection to upstream, cert pins are looked up by host without port,
+// not by the SNI, which is empty when connecting by ip.
+func (proxy *Proxy) newUpstreamTlsConfig(host string) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: proxy.Opts.SslInsecure,
+		KeyLogWriter:       proxy.tlsKeyLogWriter,
+		RootCAs:            proxy.rootCAs,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return proxy.verifyCertPins(host, cs.PeerCertificates)
+		},
+	}
+}
+
+// dial addr for the client shared by flows, through the upstream proxy of the flow request in ctx
+func (proxy *Proxy) dialSharedClient(ctx context.Context, network, addr string) (net.Conn, error) {
+	req, ok := ctx.Value(proxyReqCtxKey).(*http.Request)
+	if !ok {
+		return nil, errors.New("no proxy request in context")
+	}
+	return proxy.dialUpstream(ctx, req, addr)
+}
+
+// tls dial addr for the client shared by flows, cert pins are verified in handshake by host of addr,
+// so nothing is sent to a server failed to match the pins
+func (proxy *Proxy) dialSharedClientTls(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := proxy.dialSharedClient(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	host := addressHostname(addr)
+	tlsConfig := proxy.newUpstreamTlsConfig(host)
+	tlsConfig.ServerName = host
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	tlsConfig.GetClientCertificate = proxy.getClientCertificateFromCtx
+	if connCtx, ok := ctx.Value(proxyReqCtxKey).(*http.Request).Context().Value(connContextKey).(*ConnContext); ok {
+		tlsConfig.KeyLogWriter = proxy.newConnKeyLogWriter(connCtx)
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testFlowErrorAddon struct {
+	BaseAddon
+	errs chan error
+}
+
+func (a *testFlowErrorAddon) EndFlow(f *Flow) {
+	if f.Request.Method == "CONNECT" {
+		return
+	}
+	a.errs <- f.Error
+}
+
+func TestUpstreamTrustStore(t *testing.T) {
+	helper := &testProxyHelper{
+		server:    &http.Server{},
+		proxyAddr: ":29090",
+	}
+	helper.init(t)
+	var handled atomic.Int32
+	handler := helper.server.Handler
+	helper.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled.Add(1)
+		handler.ServeHTTP(w, r)
+	})
+	httpsEndpoint := helper.httpsEndpoint
+	testProxy := helper.testProxy
+	testProxy.Opts.SslInsecure = false
+	testProxy.AddAddon(NewUpstreamCertAddon(false))
+	errAddon := &testFlowErrorAddon{errs: make(chan error, 1)}
+	testProxy.AddAddon(errAddon)
+	getProxyClient := helper.getProxyClient
+
+	// the upstream server certificate is issued by a private ca, trust the certificate directly
+	upstreamCert := helper.server.TLSConfig.Certificates[0].Certificate[0]
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	handleError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstreamCert}), 0600))
+
+	defer helper.tlsPlainLn.Close()
+	go helper.server.Serve(helper.tlsLn)
+	go testProxy.Start()
+	time.Sleep(time.Millisecond * 10) // wait for test proxy startup
+
+	t.Run("unknown authority", func(t *testing.T) {
+		resp, _ := testGetResponse(t, httpsEndpoint, getProxyClient())
+		if resp.StatusCode != 502 {
+			t.Fatalf("expected %d, but got %d", 502, resp.StatusCode)
+		}
+		var certErr *UpstreamCertError
+		if err := <-errAddon.errs; !errors.As(err, &certErr) || certErr.Reason != UpstreamCertUnknownAuthority {
+			t.Fatalf("expected %s error, but got %v", UpstreamCertUnknownAuthority, err)
+		}
+	})
+
+	t.Run("trusted by ca bundle", func(t *testing.T) {
+		rootCAs, err := loadRootCAs(caFile)
+		handleError(t, err)
+		testProxy.rootCAs = rootCAs
+		defer func() { testProxy.rootCAs = nil }()
+		testSendRequest(t, httpsEndpoint, getProxyClient(), "ok")
+		if err := <-errAddon.errs; err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+	})
+
+	t.Run("pin mismatch", func(t *testing.T) {
+		testProxy.Opts.SslInsecure = true
+		testProxy.Opts.CertPins = []*CertPin{{Hosts: []string{"localhost"}, SPKI: []string{"AAAA"}}}
+		defer func() {
+			testProxy.Opts.SslInsecure = false
+			testProxy.Opts.CertPins = nil
+		}()
+		resp, _ := testGetResponse(t, httpsEndpoint, getProxyClient())
+		if resp.StatusCode != 502 {
+			t.Fatalf("expected %d, but got %d", 502, resp.StatusCode)
+		}
+		var certErr *UpstreamCertError
+		if err := <-errAddon.errs; !errors.As(err, &certErr) || certErr.Reason != UpstreamCertPinMismatch {
+			t.Fatalf("expected %s error, but got %v", UpstreamCertPinMismatch, err)
+		}
+	})
+
+	t.Run("pin mismatch by ip", func(t *testing.T) {
+		testProxy.Opts.SslInsecure = true
+		testProxy.Opts.CertPins = []*CertPin{{Hosts: []string{"127.0.0.1"}, SPKI: []string{"AAAA"}}}
+		defer func() {
+			testProxy.Opts.SslInsecure = false
+			testProxy.Opts.CertPins = nil
+		}()
+		// no SNI is sent to ip, by the server connection and the shared client
+		endpoint := strings.Replace(httpsEndpoint, "localhost", "127.0.0.1", 1)
+		handled.Store(0)
+		for _, separate := range []bool{false, true} {
+			if separate {
+				testProxy.Addons = append([]Addon{&testSeparateClientAddon{}}, testProxy.Addons...)
+				defer func() { testProxy.Addons = testProxy.Addons[1:] }()
+			}
+			resp, _ := testGetResponse(t, endpoint, getProxyClient())
+			if resp.StatusCode != 502 {
+				t.Fatalf("separate client %v: expected %d, but got %d", separate, 502, resp.StatusCode)
+			}
+			var certErr *UpstreamCertError
+			if err := <-errAddon.errs; !errors.As(err, &certErr) || certErr.Reason != UpstreamCertPinMismatch {
+				t.Fatalf("separate client %v: expected %s error, but got %v", separate, UpstreamCertPinMismatch, err)
+			}
+			// pins are verified in handshake, the request never reaches the server
+			if n := handled.Load(); n != 0 {
+				t.Fatalf("separate client %v: expected no request handled by server, but got %d", separate, n)
+			}
+		}
+	})
+}
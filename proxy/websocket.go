@@ -28,7 +28,7 @@ var defaultWebSocket webSocket
 // 	transfer(log, conn, remoteConn)
 // }
 
-func (s *webSocket) wss(res http.ResponseWriter, req *http.Request) {
+func (s *webSocket) wss(res http.ResponseWriter, req *http.Request, tlsConfig *tls.Config) {
 	upgradeBuf, err := httputil.DumpRequest(req, false)
 	if err != nil {
 		log.Errorf("DumpRequest: %v", err)
@@ -48,8 +48,11 @@ func (s *webSocket) wss(res http.ResponseWriter, req *http.Request) {
 	if !strings.Contains(host, ":") {
 		host = host + ":443"
 	}
-	conn, err := tls.Dial("tcp", host, nil)
+	conn, err := tls.Dial("tcp", host, tlsConfig)
 	if err != nil {
+		if certErr := newUpstreamCertError(host, err); certErr != nil {
+			err = certErr
+		}
 		log.Errorf("tls.Dial: %v", err)
 		return
 	}
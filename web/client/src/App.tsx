@@ -13,7 +13,7 @@ import Resizer from './components/Resizer'
 
 import { Flow, FlowManager } from './lib/flow'
 import { parseMessage, SendMessageType, buildMessageMeta, MessageType } from './lib/message'
-import { isInViewPort } from './lib/utils'
+import { getServerHost, isInViewPort } from './lib/utils'
 import { ConnectionManager, IConnection } from './lib/connection'
 
 interface IState {
@@ -21,6 +21,7 @@ interface IState {
   flow: Flow | null
   wsStatus: 'open' | 'close' | 'connecting'
   filterInvalid: boolean
+  pcapExport: boolean // /export/pcapng is served, enabled by -pcap_conns
 }
 
 const wsReconnIntervals = [1, 1, 2, 2, 4, 4, 8, 8, 16, 16, 32, 32]
@@ -48,6 +49,7 @@ class App extends React.Component<IProps, IState> {
       flow: null,
       wsStatus: 'close',
       filterInvalid: false,
+      pcapExport: false,
     }
 
     this.ws = null
@@ -57,6 +59,17 @@ class App extends React.Component<IProps, IState> {
 
   componentDidMount() {
     this.initWs()
+    this.loadCapabilities()
+  }
+
+  loadCapabilities() {
+    fetch(`http://${getServerHost()}/api/capabilities`)
+      .then(res => res.json())
+      .then(data => {
+        const handlers: string[] = data.handlers || []
+        this.setState({ pcapExport: handlers.includes('/export/pcapng') })
+      })
+      .catch(err => console.error(err))
   }
 
   componentWillUnmount() {
@@ -72,12 +85,7 @@ class App extends React.Component<IProps, IState> {
 
     this.setState({ wsStatus: 'connecting' })
 
-    let host
-    if (process.env.NODE_ENV === 'development') {
-      host = 'localhost:9081'
-    } else {
-      host = new URL(document.URL).host
-    }
+    const host = getServerHost()
     this.ws = new WebSocket(`ws://${host}/echo`)
     this.ws.binaryType = 'arraybuffer'
 
@@ -179,7 +187,9 @@ class App extends React.Component<IProps, IState> {
             <div style={{ marginRight: '10px' }}><Button size="sm" onClick={() => {
               this.flowMgr.clear()
               this.setState({ flows: this.flowMgr.showList(), flow: null })
+              fetch(`http://${getServerHost()}/api/flows`, { method: 'DELETE' })
             }}>Clear</Button></div>
+            <div style={{ marginRight: '10px' }}><Button size="sm" variant="outline-primary" href={`http://${getServerHost()}/api/flows/export?format=har`} download>Export HAR</Button></div>
             <div style={{ marginRight: '10px' }}>
               <Form.Control
                 size="sm" placeholder="Filter"
@@ -255,6 +265,7 @@ class App extends React.Component<IProps, IState> {
 
         <ViewFlow
           flow={this.state.flow}
+          pcapExport={this.state.pcapExport}
           onClose={() => { this.setState({ flow: null }) }}
           onReRenderFlows={() => { this.setState({ flows: this.state.flows }) }}
           onMessage={msg => { if (this.ws) this.ws.send(msg) }}
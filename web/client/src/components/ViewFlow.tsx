@@ -1,10 +1,10 @@
-import React, { useState } from 'react'
+import React, { useEffect, useState } from 'react'
 import Button from 'react-bootstrap/Button'
 import FormCheck from 'react-bootstrap/FormCheck'
 import fetchToCurl from 'fetch-to-curl'
 import copy from 'copy-to-clipboard'
 import JSONPretty from 'react-json-pretty'
-import { isTextBody } from '../lib/utils'
+import { getServerHost, isTextBody } from '../lib/utils'
 import type { Flow, IResponse } from '../lib/flow'
 import EditFlow from './EditFlow'
 import { useSize } from 'ahooks'
@@ -13,12 +13,13 @@ import { configViewFlowRequestBodyTab, configViewFlowResponseBodyLineBreak, conf
 
 interface Iprops {
   flow: Flow | null
+  pcapExport: boolean
   onClose: () => void
   onReRenderFlows: () => void
   onMessage: (msg: ArrayBufferLike) => void
 }
 
-function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
+function ViewFlow({ flow, pcapExport, onClose, onReRenderFlows, onMessage }: Iprops) {
   const bodySize = useSize(document.querySelector('body'))
   const initWrapWidth = bodySize ? bodySize.width / 2 : 500
   const maxWrapWidth = bodySize ? bodySize.width * 0.9 : 1000
@@ -30,6 +31,11 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
   const [requestBodyViewTab, setRequestBodyViewTab] = useConfig(configViewFlowRequestBodyTab)
   const [responseBodyLineBreak, setResponseBodyLineBreak] = useConfig(configViewFlowResponseBodyLineBreak)
 
+  useEffect(() => {
+    if (!flow || !flow.storedBody) return
+    flow.loadStoredBody().then(onReRenderFlows).catch(err => console.error(err))
+  }, [flow, onReRenderFlows])
+
   const copyAsCurl = () => {
     if (!flow) return null
     return (
@@ -113,6 +119,15 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
           <p>Flow Info</p>
           <div className="header-block-content">
             <p>Id: {flow.id}</p>
+            <p><a href={`http://${getServerHost()}/api/flows/export?format=har&flow=${flow.id}`} download>Export HAR</a></p>
+            <p>
+              Export Request:{' '}
+              {
+                ['curl', 'httpie', 'raw', 'go', 'python'].map(format => (
+                  <a key={format} style={{ marginRight: '8px' }} href={`http://${getServerHost()}/api/flows/${flow.id}/export?format=${format}`} target="_blank" rel="noreferrer">{format}</a>
+                ))
+              }
+            </p>
           </div>
         </div>
         {
@@ -126,8 +141,49 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
                       <div className="header-block-content">
                         <p>Address: {conn.serverConn.address}</p>
                         <p>Resolved Address: {conn.serverConn.peername}</p>
+                        {
+                          !conn.serverConn.clientCert ? null :
+                            <p>Client Certificate: {conn.serverConn.clientCert}</p>
+                        }
                       </div>
                     </div>
+                    {
+                      !conn.serverConn.tls ? null :
+                        <div className="header-block">
+                          <p>Server TLS</p>
+                          <div className="header-block-content">
+                            <p>Version: {conn.serverConn.tls.version}</p>
+                            <p>Cipher Suite: {conn.serverConn.tls.cipherSuite}</p>
+                            <p>ALPN: {conn.serverConn.tls.alpn || '-'}</p>
+                            <p>SNI: {conn.serverConn.tls.sni || '-'}</p>
+                            <p>OCSP Staple: {conn.serverConn.tls.ocspResponse ? 'yes' : 'no'}</p>
+                          </div>
+                        </div>
+                    }
+                    {
+                      !conn.serverConn.tls ? null :
+                        conn.serverConn.tls.certificates.map((cert, index) => (
+                          <div className="header-block" key={cert.sha256}>
+                            <p>Server Certificate #{index}</p>
+                            <div className="header-block-content">
+                              <p>Subject: {cert.subject}</p>
+                              <p>Issuer: {cert.issuer}</p>
+                              <p>Serial Number: {cert.serialNumber}</p>
+                              <p>Validity: {cert.notBefore} - {cert.notAfter}</p>
+                              {
+                                !(cert.dnsNames || []).concat(cert.ipAddresses).length ? null :
+                                  <p>SANs: {(cert.dnsNames || []).concat(cert.ipAddresses).join(', ')}</p>
+                              }
+                              <p>Is CA: {cert.isCA ? 'true' : 'false'}</p>
+                              <p>Signature Algorithm: {cert.signatureAlgorithm}</p>
+                              <p>Public Key Algorithm: {cert.publicKeyAlgorithm}</p>
+                              <p>SHA1 Fingerprint: {cert.sha1}</p>
+                              <p>SHA256 Fingerprint: {cert.sha256}</p>
+                              <p>SPKI (sha256, base64): {cert.spki}</p>
+                            </div>
+                          </div>
+                        ))
+                    }
                   </>
               }
               <div className="header-block">
@@ -149,6 +205,10 @@ function ViewFlow({ flow, onClose, onReRenderFlows, onMessage }: Iprops) {
                     conn.flowCount == null ? null :
                       <p>Flow Count: {conn.flowCount}</p>
                   }
+                  {
+                    !pcapExport ? null :
+                      <p><a href={`http://${getServerHost()}/export/pcapng?conn=${conn.clientConn.id}`} download>Export pcapng</a></p>
+                  }
                 </div>
               </div>
             </>
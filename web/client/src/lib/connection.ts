@@ -1,3 +1,30 @@
+export interface ICertificate {
+  subject: string
+  issuer: string
+  serialNumber: string
+  notBefore: string
+  notAfter: string
+  dnsNames: string[] | null
+  ipAddresses: string[]
+  emailAddresses: string[] | null
+  uris: string[]
+  isCA: boolean
+  signatureAlgorithm: string
+  publicKeyAlgorithm: string
+  sha1: string
+  sha256: string
+  spki: string
+}
+
+export interface ITlsState {
+  version: string
+  cipherSuite: string
+  alpn: string
+  sni: string
+  ocspResponse: string | null
+  certificates: ICertificate[]
+}
+
 export interface IConnection {
   clientConn: {
     id: string
@@ -8,6 +35,8 @@ export interface IConnection {
     id: string
     address: string
     peername: string
+    clientCert?: string
+    tls?: ITlsState
   }
   intercept: boolean
   opening?: boolean
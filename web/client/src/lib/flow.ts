@@ -1,6 +1,6 @@
 import type { ConnectionManager, IConnection } from './connection'
 import { IMessage, MessageType } from './message'
-import { arrayBufferToBase64, bufHexView, getSize, isTextBody } from './utils'
+import { arrayBufferToBase64, base64ToArrayBuffer, bufHexView, getServerHost, getSize, isTextBody } from './utils'
 import { FlowFilter } from './filter'
 
 export type Header = Record<string, string[]>
@@ -16,6 +16,8 @@ export interface IRequest {
 export interface IFlowRequest {
   connId: string
   request: IRequest
+  storedBody?: boolean // bodies kept by the store, fetched by loadStoredBody
+  duration?: number // ms
 }
 
 export interface IResponse {
@@ -50,6 +52,7 @@ export class Flow {
   public waitIntercept!: boolean
   public request!: IRequest
   public response: IResponse | null = null
+  public storedBody = false
 
   public url!: URL
   private path!: string
@@ -100,6 +103,8 @@ export class Flow {
     const flowRequestMsg = msg.content as IFlowRequest
     this.connId = flowRequestMsg.connId
     this.request = flowRequestMsg.request
+    this.storedBody = !!flowRequestMsg.storedBody
+    if (flowRequestMsg.duration != null) this.costTime = String(flowRequestMsg.duration) + ' ms'
 
     let rawUrl = this.request.url
     if (rawUrl.startsWith('//')) rawUrl = 'http:' + rawUrl
@@ -160,6 +165,37 @@ export class Flow {
     return this
   }
 
+  // loadStoredBody fetches bodies of flow replayed from the store
+  public async loadStoredBody(): Promise<void> {
+    if (!this.storedBody) return
+    this.storedBody = false
+
+    const res = await fetch(`http://${getServerHost()}/api/flows/${this.id}?decode=true`)
+    if (!res.ok) return
+    const detail = await res.json()
+
+    this.request.body = base64ToArrayBuffer(detail.request?.body)
+    this._requestBody = null
+    this._isTextRequest = null
+    this._previewRequestBody = null
+    this._hexviewRequestBody = null
+    this.status = MessageType.REQUEST_BODY
+
+    if (this.response) {
+      this.response.body = base64ToArrayBuffer(detail.response?.body)
+      this._responseBody = null
+      this._isTextResponse = null
+      this._previewResponseBody = null
+      this._hexviewResponseBody = null
+      this.status = MessageType.RESPONSE_BODY
+
+      if (!this.headerContentLengthExist && this.response.body) {
+        this._size = this.response.body.byteLength
+        this.size = getSize(this._size)
+      }
+    }
+  }
+
   public preview(): IFlowPreview {
     return {
       no: this.no,
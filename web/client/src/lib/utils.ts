@@ -8,6 +8,11 @@ export const isTextBody = (payload: IRequest | IResponse) => {
   return /text|javascript|json|x-www-form-urlencoded|xml|form-data/.test(payload.header['Content-Type'].join(''))
 }
 
+export const getServerHost = () => {
+  if (process.env.NODE_ENV === 'development') return 'localhost:9081'
+  return new URL(document.URL).host
+}
+
 export const getSize = (len: number) => {
   if (!len) return '0'
   if (isNaN(len)) return '0'
@@ -42,6 +47,15 @@ export const arrayBufferToBase64 = (buf: ArrayBuffer) => {
   return btoa(binary)
 }
 
+export const base64ToArrayBuffer = (data: string | null | undefined) => {
+  const binary = atob(data || '')
+  const bytes = new Uint8Array(binary.length)
+  for (let i = 0; i < binary.length; i++) {
+    bytes[i] = binary.charCodeAt(i)
+  }
+  return bytes.buffer
+}
+
 export const bufHexView = (buf: ArrayBuffer) => {
   let str = ''
   const bytes = new Uint8Array(buf)
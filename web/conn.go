@@ -5,6 +5,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/lqqyt2423/go-mitmproxy/log"
 	"github.com/lqqyt2423/go-mitmproxy/proxy"
@@ -26,6 +27,8 @@ type concurrentConn struct {
 	waitChansMu sync.Mutex
 
 	breakPointRules []*breakPointRule
+
+	replayed map[uuid.UUID]bool // flows sent live while replaying the store, nil when not replaying
 }
 
 func newConn(c *websocket.Conn) *concurrentConn {
@@ -77,6 +80,7 @@ func (c *concurrentConn) writeMessageMayWait(msg *messageFlow, f *proxy.Flow) {
 	}
 
 	c.mu.Lock()
+	c.markReplayed(msg)
 	err := c.conn.WriteMessage(websocket.BinaryMessage, msg.bytes())
 	c.mu.Unlock()
 	if err != nil {
@@ -92,6 +96,7 @@ func (c *concurrentConn) writeMessageMayWait(msg *messageFlow, f *proxy.Flow) {
 func (c *concurrentConn) writeMessage(msg *messageFlow) {
 	msg.waitIntercept = 0
 	c.mu.Lock()
+	c.markReplayed(msg)
 	err := c.conn.WriteMessage(websocket.BinaryMessage, msg.bytes())
 	c.mu.Unlock()
 	if err != nil {
@@ -100,6 +105,72 @@ func (c *concurrentConn) writeMessage(msg *messageFlow) {
 	}
 }
 
+// markReplayed records flow of msg sent while replaying, c.mu must be held
+func (c *concurrentConn) markReplayed(msg *messageFlow) {
+	if c.replayed != nil && msg.mType == messageTypeRequest {
+		c.replayed[msg.id] = true
+	}
+}
+
+func (c *concurrentConn) startReplay() {
+	c.mu.Lock()
+	c.replayed = make(map[uuid.UUID]bool)
+	c.mu.Unlock()
+}
+
+func (c *concurrentConn) stopReplay() {
+	c.mu.Lock()
+	c.replayed = nil
+	c.mu.Unlock()
+}
+
+// sendFlows sends finished flows, e.g. read from files
+func (c *concurrentConn) sendFlows(flows []*proxy.Flow) {
+	for _, f := range flows {
+		c.trySendConnMessage(f)
+		for _, mType := range []messageType{messageTypeRequest, messageTypeRequestBody, messageTypeResponse, messageTypeResponseBody} {
+			if f.Response == nil && mType >= messageTypeResponse {
+				break
+			}
+			msg, err := newMessageFlow(mType, f)
+			if err != nil {
+				log.Error(fmt.Errorf("web addon gen msg: %w", err))
+				break
+			}
+			c.writeMessage(msg)
+		}
+	}
+}
+
+// sendStoredFlows sends finished flows without bodies, the client fetches bodies from the store on demand
+func (c *concurrentConn) sendStoredFlows(flows []*proxy.Flow) {
+	for _, f := range flows {
+		c.mu.Lock()
+		sent := c.replayed[f.Id]
+		c.mu.Unlock()
+		if sent {
+			continue
+		}
+
+		c.trySendConnMessage(f)
+		msg, err := newMessageStoredRequest(f)
+		if err != nil {
+			log.Error(fmt.Errorf("web addon gen msg: %w", err))
+			continue
+		}
+		c.writeMessage(msg)
+		if f.Response == nil {
+			continue
+		}
+		msg, err = newMessageFlow(messageTypeResponse, f)
+		if err != nil {
+			log.Error(fmt.Errorf("web addon gen msg: %w", err))
+			continue
+		}
+		c.writeMessage(msg)
+	}
+}
+
 func (c *concurrentConn) readloop() {
 	for {
 		mt, data, err := c.conn.ReadMessage()
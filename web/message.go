@@ -122,6 +122,26 @@ func newMessageFlow(mType messageType, f *proxy.Flow) (*messageFlow, error) {
 	}, nil
 }
 
+// newMessageStoredRequest is request message of flow kept by the store, whose bodies are not sent
+func newMessageStoredRequest(f *proxy.Flow) (*messageFlow, error) {
+	m := make(map[string]interface{})
+	m["request"] = f.Request
+	m["connId"] = f.ConnContext.Id().String()
+	m["storedBody"] = true
+	if !f.StartTime.IsZero() && !f.EndTime.IsZero() {
+		m["duration"] = f.EndTime.Sub(f.StartTime).Milliseconds()
+	}
+	content, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return &messageFlow{
+		mType:   messageTypeRequest,
+		id:      f.Id,
+		content: content,
+	}, nil
+}
+
 func newMessageConnClose(connCtx *proxy.ConnContext) *messageFlow {
 	var buf bytes.Buffer
 	binary.Write(&buf, binary.BigEndian, connCtx.FlowCount.Load())
@@ -2,6 +2,7 @@ package web
 
 import (
 	"embed"
+	"encoding/json"
 	"fmt"
 	"io/fs"
 	"net/http"
@@ -18,14 +19,28 @@ var assets embed.FS
 type WebAddon struct {
 	proxy.BaseAddon
 
-	server   *http.Server
-	upgrader *websocket.Upgrader
+	server    *http.Server
+	serverMux *http.ServeMux
+	upgrader  *websocket.Upgrader
+
+	handlers   []string // patterns registered by Handle, served at /api/capabilities
+	handlersMu sync.RWMutex
 
 	conns   []*concurrentConn
 	connsMu sync.RWMutex
 
 	flowMessageState map[*proxy.Flow]messageType
 	flowMu           sync.Mutex
+
+	loadedFlows   []*proxy.Flow // finished flows added by AddFlows, sent to every client
+	store         FlowStore     // finished flows sent to every newly connected client
+	loadedFlowsMu sync.RWMutex
+}
+
+// FlowStore keeps finished flows, e.g. addon.FlowStore
+type FlowStore interface {
+	http.Handler              // serves /api/flows
+	Summaries() []*proxy.Flow // finished flows without bodies, fetched by GET /api/flows/{id}
 }
 
 func NewWebAddon(addr string) *WebAddon {
@@ -41,6 +56,7 @@ func NewWebAddon(addr string) *WebAddon {
 
 	serverMux := new(http.ServeMux)
 	serverMux.HandleFunc("/echo", web.echo)
+	serverMux.HandleFunc("/api/capabilities", web.capabilities)
 
 	fsys, err := fs.Sub(assets, "client/build")
 	if err != nil {
@@ -48,6 +64,7 @@ func NewWebAddon(addr string) *WebAddon {
 	}
 	serverMux.Handle("/", http.FileServer(http.FS(fsys)))
 
+	web.serverMux = serverMux
 	web.server = &http.Server{Addr: addr, Handler: serverMux}
 	web.conns = make([]*concurrentConn, 0)
 
@@ -60,6 +77,46 @@ func NewWebAddon(addr string) *WebAddon {
 	return web
 }
 
+// Handle registers extra handler on the web interface server, e.g. export endpoints of other addons
+func (web *WebAddon) Handle(pattern string, handler http.Handler) {
+	web.serverMux.Handle(pattern, handler)
+	web.handlersMu.Lock()
+	web.handlers = append(web.handlers, pattern)
+	web.handlersMu.Unlock()
+}
+
+// capabilities lists extra handlers, so the client only shows links of enabled endpoints, e.g. /export/pcapng
+func (web *WebAddon) capabilities(w http.ResponseWriter, r *http.Request) {
+	web.handlersMu.RLock()
+	handlers := append([]string{}, web.handlers...)
+	web.handlersMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"handlers": handlers}); err != nil {
+		log.Error(err)
+	}
+}
+
+// AddFlows shows finished flows in the web interface, e.g. read from files
+func (web *WebAddon) AddFlows(flows []*proxy.Flow) {
+	web.loadedFlowsMu.Lock()
+	web.loadedFlows = append(web.loadedFlows, flows...)
+	web.loadedFlowsMu.Unlock()
+
+	web.forEachConn(func(c *concurrentConn) {
+		c.sendFlows(flows)
+	})
+}
+
+// SetFlowStore serves REST api of store at /api/flows, and replays flows of store to newly connected clients
+func (web *WebAddon) SetFlowStore(store FlowStore) {
+	web.loadedFlowsMu.Lock()
+	web.store = store
+	web.loadedFlowsMu.Unlock()
+	web.Handle("/api/flows", store)
+	web.Handle("/api/flows/", store)
+}
+
 func (web *WebAddon) echo(w http.ResponseWriter, r *http.Request) {
 	c, err := web.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -68,12 +125,24 @@ func (web *WebAddon) echo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	conn := newConn(c)
+	// flows sent live while replaying the store are skipped in the replay
+	conn.startReplay()
 	web.addConn(conn)
 	defer func() {
 		web.removeConn(conn)
 		c.Close()
 	}()
 
+	web.loadedFlowsMu.RLock()
+	loadedFlows := web.loadedFlows
+	store := web.store
+	web.loadedFlowsMu.RUnlock()
+	conn.sendFlows(loadedFlows)
+	if store != nil {
+		conn.sendStoredFlows(store.Summaries())
+	}
+	conn.stopReplay()
+
 	conn.readloop()
 }
 